@@ -1,10 +1,26 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
 	"github.com/FinalCAD/vpn-stack/aws-openvpn-updater/internal/app"
 	"github.com/FinalCAD/vpn-stack/aws-openvpn-updater/internal/configs"
+	"github.com/FinalCAD/vpn-stack/aws-openvpn-updater/internal/membership"
+	"github.com/FinalCAD/vpn-stack/aws-openvpn-updater/internal/openvpn"
+	"github.com/FinalCAD/vpn-stack/aws-openvpn-updater/internal/settings"
+	"github.com/FinalCAD/vpn-stack/aws-openvpn-updater/internal/state"
+	"github.com/FinalCAD/vpn-stack/aws-openvpn-updater/internal/totp"
+	"github.com/FinalCAD/vpn-stack/aws-openvpn-updater/internal/utils"
 	"github.com/rs/zerolog/log"
-	"os"
 )
 
 type Exit struct{ Code int }
@@ -20,13 +36,278 @@ func exitHandler() {
 
 func main() {
 	defer exitHandler()
+	settings.RegisterFlags(flag.CommandLine)
 	config := configs.InitApp()
-	if config.Environment == "" {
-		log.Fatal().Msg("Missing environment command line parameter")
+	switch config.Command {
+	case configs.CommandRun:
+		run(config)
+	case configs.CommandValidate:
+		validate(config)
+	case configs.CommandConfig:
+		printConfig(config)
+	case configs.CommandInventory:
+		inventory(config)
+	case configs.CommandDownloads:
+		downloads(config)
+	case configs.CommandPlan:
+		plan(config)
+	case configs.CommandRotation:
+		rotation(config)
+	case configs.CommandVerify:
+		verify(config)
+	case configs.CommandConnect:
+		clientConnect(config)
+	case configs.CommandHistory:
+		connections(config)
+	case configs.CommandTlsVerify:
+		tlsCryptV2Verify(config)
+	default:
+		log.Fatal().Msgf("Unknown command: %s", config.Command)
+	}
+}
+
+func run(config *configs.Config) {
+	ctx, cancel := utils.GetSignalContext()
+	defer cancel()
+	app, err := app.Create(ctx, config)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Error during setup")
+	}
+	app.Start(ctx)
+}
+
+func inventory(config *configs.Config) {
+	ctx, cancel := utils.GetSignalContext()
+	defer cancel()
+	app, err := app.Create(ctx, config)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Error during setup")
+	}
+	app.Inventory(ctx)
+}
+
+func plan(config *configs.Config) {
+	ctx, cancel := utils.GetSignalContext()
+	defer cancel()
+	app, err := app.Create(ctx, config)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Error during setup")
 	}
-	app, err := app.Create(config)
+	app.Plan(ctx)
+}
+
+func rotation(config *configs.Config) {
+	if len(config.Args) != 1 {
+		log.Fatal().Msg("Usage: ca-rotation start|publish|retire|status")
+	}
+	ctx, cancel := utils.GetSignalContext()
+	defer cancel()
+	app, err := app.Create(ctx, config)
 	if err != nil {
 		log.Fatal().Err(err).Msg("Error during setup")
 	}
-	app.Start()
+	switch config.Args[0] {
+	case "start":
+		err = app.StartRotation(ctx)
+	case "publish":
+		err = app.PublishRotation()
+	case "retire":
+		err = app.RetireRotation()
+	case "status":
+		err = printStatus(ctx, app)
+	default:
+		log.Fatal().Msg("Usage: ca-rotation start|publish|retire|status")
+	}
+	if err != nil {
+		log.Fatal().Err(err).Msgf("Error during ca rotation %s", config.Args[0])
+	}
+}
+
+func printStatus(ctx context.Context, a *app.App) error {
+	err := a.LookupUsers(ctx)
+	if err != nil {
+		return err
+	}
+	status, err := a.Status(ctx)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(status)
+}
+
+// verify checks the code entered as password against the second factor of the certificate common name,
+// called by openvpn as auth-user-pass-verify with via-file. Any failure exits with 1, refusing the client.
+func verify(config *configs.Config) {
+	if len(config.Args) != 1 {
+		log.Fatal().Msg("Usage: verify <credentials file>")
+	}
+	s, err := settings.CreateSettings(config)
+	if s == nil {
+		log.Fatal().Err(err).Msg("Error reading configuration")
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Configuration has errors")
+	}
+	if !s.Totp.Enabled {
+		log.Fatal().Msg("Second factor disabled by totp.enabled")
+	}
+	content, err := os.ReadFile(config.Args[0])
+	if err != nil {
+		log.Fatal().Err(err).Msg("Error reading credentials")
+	}
+	// The file holds the username and the password on two lines, the user is identified by its certificate
+	lines := strings.Split(string(content), "\n")
+	user := os.Getenv("common_name")
+	if len(lines) < 2 || user == "" {
+		log.Fatal().Msg("Missing password or certificate common name")
+	}
+	ok, err := totp.CreateStore(s.Totp.Path, s.Totp.KeyFile, s.Totp.Group).Verify(user, strings.TrimSpace(lines[1]), time.Now(), s.Totp.Skew)
+	if err != nil {
+		log.Fatal().Err(err).Msgf("Error verifying second factor of %s", user)
+	}
+	if !ok {
+		log.Warn().Msgf("Invalid second factor code for %s", user)
+		panic(Exit{Code: 1})
+	}
+	log.Info().Msgf("Second factor verified for %s", user)
+}
+
+// tlsCryptV2Verify refuses the tls-crypt-v2 client keys of revoked users, called by openvpn as
+// tls-crypt-v2-verify before the tls handshake.
+func tlsCryptV2Verify(config *configs.Config) {
+	s, err := settings.CreateSettings(config)
+	if s == nil {
+		log.Fatal().Err(err).Msg("Error reading configuration")
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Configuration has errors")
+	}
+	revokedPath := openvpn.CreateOpenVpnConfig(s.OpenVpn).TlsCryptV2RevokedPath
+	err = openvpn.VerifyTlsCryptV2(revokedPath, os.Getenv("metadata_type"), os.Getenv("metadata_file"))
+	if errors.Is(err, openvpn.ErrTlsCryptV2Refused) {
+		log.Warn().Err(err).Msgf("Client key refused from %s", os.Getenv("untrusted_ip"))
+		panic(Exit{Code: 1})
+	}
+	if err != nil {
+		log.Fatal().Err(err).Msg("Error verifying tls-crypt-v2 client key")
+	}
+}
+
+// clientConnect refuses the client unless its certificate common name is in the membership cache written
+// by the daemon, with a fresh entry. Called by openvpn as client-connect, each decision is audited.
+func clientConnect(config *configs.Config) {
+	s, err := settings.CreateSettings(config)
+	if s == nil {
+		log.Fatal().Err(err).Msg("Error reading configuration")
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Configuration has errors")
+	}
+	if !s.Connect.Enabled {
+		log.Fatal().Msg("Membership check disabled by connect.enabled")
+	}
+	members, err := membership.Read(s.Connect.CacheFile)
+	if err != nil {
+		log.Error().Err(err).Msg("Error reading the membership cache")
+	}
+	user := os.Getenv("common_name")
+	decision := membership.Check(members, user, time.Now(), time.Duration(s.Connect.MaxAge)*time.Second)
+	decision.Address = os.Getenv("untrusted_ip")
+	if s.Connect.AuditLog != "" {
+		err = membership.Audit(s.Connect.AuditLog, decision)
+		if err != nil {
+			log.Error().Err(err).Msg("Error writing the audit log")
+		}
+	}
+	if !decision.Allowed {
+		log.Warn().Msgf("Connection refused for %s from %s: %s", user, decision.Address, decision.Reason)
+		panic(Exit{Code: 1})
+	}
+	log.Info().Msgf("Connection allowed for %s from %s", user, decision.Address)
+}
+
+func downloads(config *configs.Config) {
+	s, err := settings.CreateSettings(config)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Error reading configuration")
+	}
+	store, err := state.Open(s.Params.StateFile)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Error reading state")
+	}
+	var records []state.Download
+	store.View(func(data *state.Data) {
+		for _, download := range data.Downloads {
+			records = append(records, *download)
+		}
+	})
+	sort.Slice(records, func(i, j int) bool { return records[i].Created.Before(records[j].Created) })
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "USER\tCREATED\tDOWNLOADED\tREMOTE ADDRESS\tREFUSED")
+	for _, d := range records {
+		downloaded := "-"
+		if !d.DownloadedAt.IsZero() {
+			downloaded = d.DownloadedAt.Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\n", d.User, d.Created.Format(time.RFC3339), downloaded, d.RemoteAddr, d.Refused)
+	}
+	w.Flush()
+}
+
+func connections(config *configs.Config) {
+	s, err := settings.CreateSettings(config)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Error reading configuration")
+	}
+	store, err := state.Open(s.Params.StateFile)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Error reading state")
+	}
+	var records []state.Connection
+	store.View(func(data *state.Data) {
+		for _, connection := range data.Connections {
+			records = append(records, *connection)
+		}
+	})
+	sort.Slice(records, func(i, j int) bool { return records[i].LastConnected.After(records[j].LastConnected) })
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "USER\tCONNECTED\tLAST CONNECTED\tLAST SEEN\tREAL ADDRESS\tRECEIVED\tSENT\tSESSIONS\tSESSION TIME")
+	for _, c := range records {
+		fmt.Fprintf(w, "%s\t%v\t%s\t%s\t%s\t%d\t%d\t%d\t%s\n", c.User, c.Connected(), c.LastConnected.Format(time.RFC3339),
+			c.LastSeen.Format(time.RFC3339), c.RealAddress, c.BytesReceived, c.BytesSent, len(c.Sessions), c.SessionTime())
+	}
+	w.Flush()
+}
+
+func validate(config *configs.Config) {
+	s, err := settings.CreateSettings(config)
+	if s != nil {
+		err = errors.Join(err, s.Validate())
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid configuration %s:\n", config.ConfigFile)
+		for _, problem := range strings.Split(err.Error(), "\n") {
+			fmt.Fprintf(os.Stderr, "  - %s\n", problem)
+		}
+		panic(Exit{Code: 1})
+	}
+	fmt.Printf("Configuration %s is valid\n", config.ConfigFile)
+}
+
+func printConfig(config *configs.Config) {
+	if len(config.Args) != 1 || config.Args[0] != "print" {
+		log.Fatal().Msg("Usage: config print")
+	}
+	s, err := settings.CreateSettings(config)
+	if s == nil {
+		log.Fatal().Err(err).Msg("Error reading configuration")
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Configuration has errors")
+	}
+	s.Print(os.Stdout)
 }
@@ -1,164 +1,497 @@
 package app
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/FinalCAD/vpn-stack/aws-openvpn-updater/internal/awssdk"
 	"github.com/FinalCAD/vpn-stack/aws-openvpn-updater/internal/configs"
 	"github.com/FinalCAD/vpn-stack/aws-openvpn-updater/internal/openvpn"
+	"github.com/FinalCAD/vpn-stack/aws-openvpn-updater/internal/policy"
+	"github.com/FinalCAD/vpn-stack/aws-openvpn-updater/internal/portal"
+	"github.com/FinalCAD/vpn-stack/aws-openvpn-updater/internal/server"
 	"github.com/FinalCAD/vpn-stack/aws-openvpn-updater/internal/settings"
+	"github.com/FinalCAD/vpn-stack/aws-openvpn-updater/internal/state"
+	"github.com/FinalCAD/vpn-stack/aws-openvpn-updater/internal/totp"
 	"github.com/FinalCAD/vpn-stack/aws-openvpn-updater/internal/utils"
 	"github.com/rs/zerolog/log"
 )
 
+// stepTimeout bounds the processing of a single user (easyrsa, S3 upload and mail).
+const stepTimeout time.Duration = time.Duration(5) * time.Minute
+
 type App struct {
 	Settings      *settings.Settings
 	OpenVpnConfig *openvpn.OpenVpnConfig
 	AwsSdkConfig  *awssdk.AwsSdkConfig
+	Resolver      *awssdk.ParameterResolver
+	State         *state.Store
+	Totp          *totp.Store
 	IamUsers      []awssdk.User
+	// policies are read from the IAM tags of the members of the vpn group by name, once per loop
+	policies map[string]policy.Policy
+	// seen is the time of the lookup each member of the vpn group was last returned by
+	seen map[string]time.Time
+	// skipped are the members of the vpn group whose policy could not be read by the last lookup
+	skipped map[string]bool
+	// userMutex serializes the changes of users between the update loop and the http server
+	userMutex sync.Mutex
+	// nextConfig is the pki of the new ca while a ca rotation is published, nil otherwise
+	nextConfig *openvpn.OpenVpnConfig
+	// statusUpdated is the update time of the status file last recorded
+	statusUpdated time.Time
+	// plan is set by Plan, which leaves the membership cache of the running daemon untouched
+	plan bool
 }
 
-func (a App) String() string {
+func (a *App) String() string {
 	return fmt.Sprintf("[ Settings: %v, OpenVpn: %v, AWS: %v ]", a.Settings, a.OpenVpnConfig, a.AwsSdkConfig)
 }
 
-func Create(cfg *configs.Config) (*App, error) {
+func Create(ctx context.Context, cfg *configs.Config) (*App, error) {
 	settings, err := settings.CreateSettings(cfg)
 	if err != nil {
 		return nil, err
 	}
+
+	awssdkcfg, err := awssdk.CreateIAMConfig(ctx, settings.Aws)
+	if err != nil {
+		return nil, err
+	}
+	resolver := awssdkcfg.CreateParameterResolver()
+	err = settings.ResolveReferences(ctx, resolver)
+	if err != nil {
+		return nil, err
+	}
 	log.Debug().Msgf("Settings: %s", settings)
 
-	if settings.Params.SendMail && settings.Params.SenderMail == "" {
-		errtxt := "error in configuration file, if send-mail is enable you must provide a sender"
-		log.Error().Err(err).Msg(errtxt)
-		return nil, errors.New(errtxt)
+	err = settings.Validate()
+	if err != nil {
+		return nil, err
 	}
 
-	openvpncfg := openvpn.CreateOpenVpnConfig(settings.OpenVpn)
-	awssdkcfg, err := awssdk.CreateIAMConfig(settings.Aws)
+	store, err := state.Open(settings.Params.StateFile)
 	if err != nil {
 		return nil, err
 	}
-	app := &App{Settings: settings, OpenVpnConfig: openvpncfg, AwsSdkConfig: awssdkcfg}
+
+	openvpncfg := openvpn.CreateOpenVpnConfig(settings.OpenVpn)
+	app := &App{Settings: settings, OpenVpnConfig: openvpncfg, AwsSdkConfig: awssdkcfg, Resolver: resolver, State: store,
+		Totp: totp.CreateStore(settings.Totp.Path, settings.Totp.KeyFile, settings.Totp.Group)}
 	return app, nil
 }
 
-func (app *App) Start() {
-	var err error
-	exitChan := utils.GetFireSignalsChannel()
+// reload reads the configuration file again and fetches fresh values for every reference.
+// Aws profile, region and role to assume are only read at startup.
+func (app *App) reload(ctx context.Context) error {
+	settings, err := settings.CreateSettings(app.Settings.Config)
+	if err != nil {
+		return err
+	}
+	app.Resolver.Flush()
+	err = settings.ResolveReferences(ctx, app.Resolver)
+	if err != nil {
+		return err
+	}
+	err = settings.Validate()
+	if err != nil {
+		return err
+	}
+	log.Debug().Msgf("Settings: %s", settings)
+
+	openvpncfg := openvpn.CreateOpenVpnConfig(settings.OpenVpn)
+	// Portal and status handlers read them while the loop waits
+	app.userMutex.Lock()
+	defer app.userMutex.Unlock()
+	app.Settings = settings
+	app.AwsSdkConfig.AwsConfig = settings.Aws
+	app.OpenVpnConfig = openvpncfg
+	app.Totp = totp.CreateStore(settings.Totp.Path, settings.Totp.KeyFile, settings.Totp.Group)
+	return nil
+}
+
+// Start runs the update loop until ctx is cancelled. The user being processed when
+// ctx is cancelled is completed before Start returns. SIGHUP reloads the configuration.
+// When an event queue is configured, group membership events trigger the loop immediately.
+func (app *App) Start(ctx context.Context) {
+	done := make(chan struct{})
+	reloadChan := utils.GetReloadChannel()
+	wakeChan := make(chan struct{}, 1)
+	stopWatch := app.watchGroupEvents(ctx, wakeChan)
+	stopConnections := app.watchConnections(ctx)
+	if app.Settings.Server.Listen != "" {
+		srv, err := app.createServer()
+		if err != nil {
+			log.Error().Err(err).Msg("Error creating http server")
+		} else {
+			go srv.Start(ctx)
+		}
+	}
 	go func() {
+		defer close(done)
 		for {
-			log.Debug().Msg("-- Start update user loop --")
-			err = app.lookupUsers()
-			if err == nil {
-				app.createUsers()
-				app.deleteUsers()
+			app.update(ctx)
+			select {
+			case <-ctx.Done():
+				return
+			case <-reloadChan:
+				log.Info().Msg("Reloading configuration")
+				err := app.reload(ctx)
+				if err != nil {
+					log.Error().Err(err).Msg("Error reloading configuration, keeping previous one")
+				} else {
+					// The watchers read the queue, the group and the status file when they start
+					stopWatch()
+					stopWatch = app.watchGroupEvents(ctx, wakeChan)
+					stopConnections()
+					stopConnections = app.watchConnections(ctx)
+				}
+			case <-wakeChan:
+				log.Info().Msg("Group membership changed, starting update loop")
+			case <-time.After(time.Second * time.Duration(app.Settings.Params.RequestInterval)):
 			}
-			log.Debug().Msg("-- End update user loop --")
-			time.Sleep(time.Second * time.Duration(app.Settings.Params.RequestInterval))
 		}
 	}()
-	<-exitChan
+	<-done
 	log.Info().Msg("Program ended from signal")
 }
 
-func (app *App) lookupUsers() error {
+// watchGroupEvents starts watching the event queue when one is configured and returns the function stopping it.
+func (app *App) watchGroupEvents(ctx context.Context, wake chan<- struct{}) context.CancelFunc {
+	watchCtx, cancel := context.WithCancel(ctx)
+	if app.Settings.Aws.EventQueueUrl != "" {
+		go app.AwsSdkConfig.WatchGroupEvents(watchCtx, wake)
+	}
+	return cancel
+}
+
+// update runs one synchronisation: users are created and deleted to match the IAM group, then stale
+// profiles are regenerated and stray artifacts reported.
+func (app *App) update(ctx context.Context) {
+	log.Debug().Msg("-- Start update user loop --")
+	err := app.Settings.ResolveReferences(ctx, app.Resolver)
+	if err != nil {
+		log.Error().Err(err).Msg("Error resolving configuration references, keeping previous values")
+	}
+	err = app.LookupUsers(ctx)
+	if err == nil {
+		app.writeMembers()
+		app.createUsers(ctx)
+		app.deleteUsers(ctx)
+		app.pruneConnections()
+		app.writeClientConfigs()
+		if ctx.Err() == nil {
+			app.revokeDormant(ctx)
+		}
+		if ctx.Err() == nil {
+			app.notifyExpiries(ctx)
+		}
+		if ctx.Err() == nil {
+			app.notifyCertificateExpiries(ctx)
+		}
+		if ctx.Err() == nil {
+			app.sendExpiryDigest(ctx)
+		}
+		if ctx.Err() == nil {
+			app.rotateCa(ctx)
+		}
+		if ctx.Err() == nil {
+			app.refreshProfiles(ctx)
+		}
+		if ctx.Err() == nil {
+			app.migrateKeys(ctx)
+		}
+		if ctx.Err() == nil {
+			app.enrollMembers(ctx)
+		}
+		if app.Settings.Params.Inventory && ctx.Err() == nil {
+			app.Inventory(ctx)
+		}
+	}
+	log.Debug().Msg("-- End update user loop --")
+}
+
+// Plan logs what the next loop would change, as a forced dry run.
+func (app *App) Plan(ctx context.Context) {
+	app.Settings.Params.Dryrun = true
+	app.plan = true
+	app.update(ctx)
+}
+
+// stepContext returns the context used to process a single user. It is detached
+// from the root context so that a step in progress is not interrupted by shutdown.
+func stepContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), stepTimeout)
+}
+
+// LookupUsers reads the openvpn indexes, the members of the vpn group and their policies. Members
+// disabled by their tags are left out.
+func (app *App) LookupUsers(ctx context.Context) error {
+	app.userMutex.Lock()
 	err := app.OpenVpnConfig.GetUser()
+	if err == nil {
+		err = app.lookupNextUsers()
+	}
+	app.userMutex.Unlock()
 	if err != nil {
 		log.Error().Err(err).Msg("Error getting openvpn users")
 		return err
 	}
 
-	app.IamUsers, err = app.AwsSdkConfig.GetIAMUser()
+	lookedUp := time.Now()
+	iamUsers, err := app.AwsSdkConfig.GetIAMUser(ctx)
 	if err != nil {
 		log.Error().Err(err).Msg("Error getting iam users")
 		return err
 	}
+	iamUsers, policies, skipped, err := app.lookupPolicies(ctx, iamUsers)
+	if err != nil {
+		log.Error().Err(err).Msg("Error getting iam user tags")
+		return err
+	}
+	app.userMutex.Lock()
+	app.IamUsers = iamUsers
+	app.policies = policies
+	app.skipped = skipped
+	// Skipped members keep the lookup they were last seen in
+	seen := map[string]time.Time{}
+	for name := range skipped {
+		if previous, ok := app.seen[name]; ok {
+			seen[name] = previous
+		}
+	}
+	for _, user := range iamUsers {
+		seen[user.Name] = lookedUp
+	}
+	app.seen = seen
+	app.userMutex.Unlock()
 	return nil
 }
 
-func (app *App) createUsers() {
-	var found bool
+// createUsers issues a certificate to the members without one, and a new one to those whose certificate
+// is due for renewal.
+func (app *App) createUsers(ctx context.Context) {
+	revoked := app.dormantRevoked()
+	now := time.Now()
 	for _, user := range app.IamUsers {
-		found = false
-		for _, account := range app.OpenVpnConfig.CertificateInfos {
+		if ctx.Err() != nil {
+			log.Info().Msg("Shutdown requested, skipping remaining user creations")
+			return
+		}
+		if revoked[user.Name] {
+			log.Debug().Msgf("Skipping user revoked for dormancy: %s", user.Name)
+			continue
+		}
+		var certInfo *openvpn.CertificateInfo
+		for i, account := range app.OpenVpnConfig.CertificateInfos {
 			if user.Name == account.Name {
-				found = true
+				certInfo = &app.OpenVpnConfig.CertificateInfos[i]
 				break
 			}
 		}
-		if !found {
-			app.createUser(user)
+		stepCtx, cancel := stepContext()
+		if certInfo == nil {
+			app.createUser(stepCtx, user)
+		} else if expiry, err := certInfo.Expiry(); err == nil && app.userPolicy(user.Name).Renew(certInfo.Issued, expiry, now) {
+			app.renewUser(stepCtx, user, expiry)
 		}
+		cancel()
 	}
 }
 
-func (app *App) createUser(user awssdk.User) {
+func (app *App) createUser(ctx context.Context, user awssdk.User) {
+	app.userMutex.Lock()
+	defer app.userMutex.Unlock()
 	log.Info().Msgf("Adding new user: %s", user.Name)
+	if app.Settings.Params.Dryrun {
+		log.Info().Msgf("Dry run creating config for user: %s", user.Name)
+		return
+	}
+	filePath, passphrase, err := app.issueUser(ctx, user)
+	if err != nil {
+		log.Error().Err(err).Msgf("Error creating openvpn client config: %s", user.Name)
+		return
+	}
+	app.recordProfile(user.Name)
+	err = app.deliverProfile(ctx, user, filePath, passphrase, false)
+	if errors.Is(err, errPassphraseUndelivered) {
+		app.withdrawUser(ctx, user.Name, err)
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Msgf("Error delivering client config: %s", user.Name)
+		return
+	}
+	err = app.enrollTotp(ctx, user)
+	if err != nil {
+		log.Error().Err(err).Msgf("Error enrolling second factor: %s", user.Name)
+		return
+	}
+	log.Info().Msgf("Added new user successfully: %s", user.Name)
+}
+
+// renewUser revokes the certificate of the user expiring at expiry and delivers a profile with a new one.
+func (app *App) renewUser(ctx context.Context, user awssdk.User, expiry time.Time) {
+	app.userMutex.Lock()
+	defer app.userMutex.Unlock()
+	log.Info().Msgf("Renewing user %s, its certificate expires on %s", user.Name, expiry.Format(time.RFC3339))
+	if app.Settings.Params.Dryrun {
+		log.Info().Msgf("Dry run renewing config for user: %s", user.Name)
+		return
+	}
+	err := app.OpenVpnConfig.DeleteUser(ctx, user.Name)
+	if err != nil {
+		log.Error().Err(err).Msgf("Error revoking openvpn client config: %s", user.Name)
+		return
+	}
+	err = app.revokeUnderNextCa(ctx, user.Name)
+	if err != nil {
+		log.Error().Err(err).Msgf("Error revoking openvpn client config under the new ca: %s", user.Name)
+	}
+	filePath, passphrase, err := app.issueUser(ctx, user)
+	if err != nil {
+		log.Error().Err(err).Msgf("Error renewing openvpn client config: %s", user.Name)
+		return
+	}
+	app.recordProfile(user.Name)
+	err = app.deliverProfile(ctx, user, filePath, passphrase, true)
+	if errors.Is(err, errPassphraseUndelivered) {
+		app.withdrawUser(ctx, user.Name, err)
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Msgf("Error delivering client config: %s", user.Name)
+		return
+	}
+	log.Info().Msgf("Renewed user successfully: %s", user.Name)
+}
+
+// deliverProfile uploads the profile and mails its link, and sends the passphrase over its own channel even
+// when the profile could not be delivered. update selects the mail announcing a regenerated profile.
+// A passphrase that could not be sent is lost, the error then wraps errPassphraseUndelivered.
+func (app *App) deliverProfile(ctx context.Context, user awssdk.User, filePath string, passphrase string, update bool) error {
+	var errs []error
+	if err := app.deliverLink(ctx, user, filePath, update); err != nil {
+		errs = append(errs, err)
+	}
+	if passphrase != "" {
+		if err := app.sendPassphrase(ctx, user, passphrase); err != nil {
+			errs = append(errs, fmt.Errorf("%w: %w", errPassphraseUndelivered, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (app *App) deliverLink(ctx context.Context, user awssdk.User, filePath string, update bool) error {
 	var presignUrl string
-	var filePath string
 	var err error
-	if !app.Settings.Params.Dryrun {
-		filePath, err = app.OpenVpnConfig.CreateUser(user.Name, app.Settings.Params.UseFqdn)
+	if app.Settings.Params.S3Upload {
+		presignUrl, err = app.uploadUserConfig(ctx, user.Name, filePath)
 		if err != nil {
-			log.Error().Err(err).Msgf("Error creating openvpn client config: %s", user.Name)
-			return
+			return fmt.Errorf("s3 upload: %w", err)
 		}
-	} else {
-		log.Info().Msgf("Dry run creating config for user: %s", user.Name)
 	}
-	if app.Settings.Params.S3Upload && !app.Settings.Params.Dryrun {
-		presignUrl, err = app.AwsSdkConfig.SaveConfS3(app.Settings.Config.Environment, user.Name, filePath)
+	if app.Settings.Params.SendMail {
+		send := app.AwsSdkConfig.SendMail
+		if update {
+			send = app.AwsSdkConfig.SendUpdateMail
+		}
+		err = send(ctx, app.Settings.Config.Environment, user, presignUrl, app.qrContent(presignUrl, filePath), app.Settings.Params.SenderMail)
 		if err != nil {
-			log.Error().Err(err).Msgf("Error s3 upload: %s", user.Name)
-			return
+			return fmt.Errorf("sending email: %w", err)
 		}
 	}
-	if app.Settings.Params.SendMail && !app.Settings.Params.Dryrun {
-		err = app.AwsSdkConfig.SendMail(app.Settings.Config.Environment, user, presignUrl, app.Settings.Params.SenderMail)
+	return nil
+}
+
+// uploadUserConfig uploads the client config and returns the link sent to the user,
+// a one-time link to the updater when download-once is enabled, a presigned url otherwise.
+func (app *App) uploadUserConfig(ctx context.Context, user string, filePath string) (string, error) {
+	if !app.Settings.Params.DownloadOnce {
+		return app.AwsSdkConfig.SaveConfS3(ctx, app.Settings.Config.Environment, user, filePath)
+	}
+	key, err := app.AwsSdkConfig.UploadConfS3(ctx, app.Settings.Config.Environment, user, filePath)
+	if err != nil {
+		return "", err
+	}
+	return app.createDownloadLink(user, key)
+}
+
+func (app *App) createServer() (*server.Server, error) {
+	srv := server.CreateServer(app.Settings.Server.Listen)
+	// Checked by Settings.Validate
+	trustedProxies, _ := settings.ParseTrustedProxies(app.Settings.Server.TrustedProxies)
+	srv.Handle(server.DownloadPath, server.DownloadHandler(app, trustedProxies))
+	srv.Handle(server.StatusPath, server.StatusHandler(app, app.Settings.Server.StatusToken))
+	srv.Handle(server.MetricsPath, server.MetricsHandler(app, app.Settings.Server.StatusToken))
+	if app.Settings.Portal.Enabled {
+		p, err := app.createPortal()
 		if err != nil {
-			log.Error().Err(err).Msgf("Error sending email: %s", user.Name)
-			return
+			return nil, err
 		}
+		srv.Handle(portal.Path, p.Handler())
 	}
-	log.Info().Msgf("Added new user successfully: %s", user.Name)
+	return srv, nil
 }
 
-func (app *App) deleteUsers() {
-	var found bool
+func (app *App) deleteUsers(ctx context.Context) {
 	for _, account := range app.OpenVpnConfig.CertificateInfos {
-		found = false
-		for _, user := range app.IamUsers {
-			if user.Name == account.Name {
-				found = true
-				break
-			}
+		if ctx.Err() != nil {
+			log.Info().Msg("Shutdown requested, skipping remaining user deletions")
+			return
 		}
-		if !found {
-			app.deleteUser(account.Name)
+		if !app.isMember(account.Name) {
+			stepCtx, cancel := stepContext()
+			app.deleteUser(stepCtx, account.Name)
+			cancel()
 		}
 	}
 }
 
-func (app *App) deleteUser(user string) {
+// deleteUser revokes the user and removes its profile, it returns whether the certificate was revoked.
+func (app *App) deleteUser(ctx context.Context, user string) bool {
+	app.userMutex.Lock()
+	defer app.userMutex.Unlock()
 	log.Info().Msgf("Deleting existing user: %s", user)
 	var err error
 	if !app.Settings.Params.Dryrun {
-		err = app.OpenVpnConfig.DeleteUser(user)
+		err = app.OpenVpnConfig.DeleteUser(ctx, user)
 		if err != nil {
 			log.Error().Err(err).Msgf("Error revoking openvpn client config: %s", user)
-			return
+			return false
+		}
+		err = app.revokeUnderNextCa(ctx, user)
+		if err != nil {
+			log.Error().Err(err).Msgf("Error revoking openvpn client config under the new ca: %s", user)
+			return true
+		}
+		err = app.Totp.Delete(user)
+		if err != nil {
+			log.Error().Err(err).Msgf("Error deleting second factor: %s", user)
+		}
+		err = app.State.Update(func(data *state.Data) error {
+			delete(data.Profiles, user)
+			if data.Rotation != nil {
+				delete(data.Rotation.Reissued, user)
+			}
+			return nil
+		})
+		if err != nil {
+			log.Error().Err(err).Msgf("Error removing profile state of user: %s", user)
 		}
 	} else {
 		log.Info().Msgf("Dry run deleting config for user: %s", user)
 	}
 	if app.Settings.Params.S3Upload && !app.Settings.Params.Dryrun {
-		err = app.AwsSdkConfig.RemoveConfS3(app.Settings.Config.Environment, user)
-		log.Error().Err(err).Msgf("Error removing S3 file client config: %s", user)
-		return
+		err = app.AwsSdkConfig.RemoveConfS3(ctx, app.Settings.Config.Environment, user, openvpn.ProfileExtensions())
+		if err != nil {
+			log.Error().Err(err).Msgf("Error removing S3 file client config: %s", user)
+			return true
+		}
 	}
 	log.Info().Msgf("Deleted user successfully: %s", user)
+	return true
 }
@@ -0,0 +1,30 @@
+package app
+
+import (
+	"time"
+
+	"github.com/FinalCAD/vpn-stack/aws-openvpn-updater/internal/membership"
+	"github.com/rs/zerolog/log"
+)
+
+// writeMembers caches the members of the vpn group just looked up for the client-connect command. The
+// daemon writes it in dry run too, plan does not.
+func (app *App) writeMembers() {
+	if !app.Settings.Connect.Enabled {
+		return
+	}
+	if app.plan {
+		log.Info().Msg("Plan leaves the membership cache unchanged")
+		return
+	}
+	app.userMutex.Lock()
+	seen := make(map[string]time.Time, len(app.seen))
+	for user, lookedUp := range app.seen {
+		seen[user] = lookedUp
+	}
+	app.userMutex.Unlock()
+	err := membership.Write(app.Settings.Connect.CacheFile, seen, time.Now())
+	if err != nil {
+		log.Error().Err(err).Msg("Error writing the membership cache")
+	}
+}
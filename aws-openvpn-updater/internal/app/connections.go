@@ -0,0 +1,180 @@
+package app
+
+import (
+	"context"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/FinalCAD/vpn-stack/aws-openvpn-updater/internal/openvpn"
+	"github.com/FinalCAD/vpn-stack/aws-openvpn-updater/internal/server"
+	"github.com/FinalCAD/vpn-stack/aws-openvpn-updater/internal/state"
+	"github.com/rs/zerolog/log"
+)
+
+// watchConnections records the clients of the openvpn status file every status-interval when a status
+// file is configured and returns the function stopping it.
+func (app *App) watchConnections(ctx context.Context) context.CancelFunc {
+	watchCtx, cancel := context.WithCancel(ctx)
+	if app.Settings.OpenVpn.StatusFile == "" {
+		return cancel
+	}
+	interval := time.Second * time.Duration(app.Settings.OpenVpn.StatusInterval)
+	go func() {
+		for {
+			app.recordConnections()
+			select {
+			case <-watchCtx.Done():
+				return
+			case <-time.After(interval):
+			}
+		}
+	}()
+	return cancel
+}
+
+// recordConnections updates the connection history of the users from the status file, unless
+// openvpn did not write it again since the last read.
+func (app *App) recordConnections() {
+	path := app.Settings.OpenVpn.StatusFile
+	if path == "" {
+		return
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		log.Error().Err(err).Msg("Error reading openvpn status file")
+		return
+	}
+	defer file.Close()
+	status, err := openvpn.ParseStatusLog(file)
+	if err != nil {
+		log.Error().Err(err).Msg("Error parsing openvpn status file")
+		return
+	}
+	if !status.Updated.IsZero() && status.Updated.Equal(app.statusUpdated) {
+		return
+	}
+	now := status.Updated
+	if now.IsZero() {
+		now = time.Now()
+	}
+
+	err = app.State.Update(func(data *state.Data) error {
+		connected := map[string][]time.Time{}
+		for _, client := range status.Clients {
+			// Clients are UNDEF until authenticated
+			if client.CommonName == "" || client.CommonName == "UNDEF" {
+				continue
+			}
+			connection, ok := data.Connections[client.CommonName]
+			if !ok {
+				connection = &state.Connection{User: client.CommonName}
+				data.Connections[client.CommonName] = connection
+			}
+			connection.Observe(client.ConnectedSince, client.RealAddress, client.BytesReceived, client.BytesSent, now)
+			connected[client.CommonName] = append(connected[client.CommonName], client.ConnectedSince)
+		}
+		for user, connection := range data.Connections {
+			connection.Disconnect(connected[user])
+		}
+		if data.ConnectionsSince.IsZero() {
+			data.ConnectionsSince = now
+		}
+		return nil
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("Error saving connection history")
+		return
+	}
+	app.statusUpdated = status.Updated
+	log.Debug().Msgf("Connection history updated, %d clients connected", len(status.Clients))
+}
+
+// pruneConnections forgets the connection history of the users that left the vpn group.
+func (app *App) pruneConnections() {
+	if app.Settings.Params.Dryrun {
+		return
+	}
+	app.userMutex.Lock()
+	members := map[string]bool{}
+	for _, user := range app.IamUsers {
+		members[user.Name] = true
+	}
+	for name := range app.skipped {
+		members[name] = true
+	}
+	app.userMutex.Unlock()
+	err := app.State.Update(func(data *state.Data) error {
+		data.PruneConnections(members)
+		return nil
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("Error pruning connection history")
+	}
+}
+
+// ConnectionStatus summarizes the connection history of a user.
+type ConnectionStatus struct {
+	User           string    `json:"user"`
+	Connected      bool      `json:"connected"`
+	LastConnected  time.Time `json:"last-connected"`
+	LastSeen       time.Time `json:"last-seen"`
+	RealAddress    string    `json:"real-address"`
+	BytesReceived  int64     `json:"bytes-received"`
+	BytesSent      int64     `json:"bytes-sent"`
+	Sessions       int       `json:"sessions"`
+	SessionSeconds int64     `json:"session-seconds"`
+}
+
+// connectionStatuses returns the connection history of every user, by name.
+func (app *App) connectionStatuses() []ConnectionStatus {
+	var statuses []ConnectionStatus
+	app.State.View(func(data *state.Data) {
+		for _, c := range data.Connections {
+			statuses = append(statuses, ConnectionStatus{User: c.User, Connected: c.Connected(), LastConnected: c.LastConnected,
+				LastSeen: c.LastSeen, RealAddress: c.RealAddress, BytesReceived: c.BytesReceived, BytesSent: c.BytesSent,
+				Sessions: len(c.Sessions), SessionSeconds: int64(c.SessionTime().Seconds())})
+		}
+	})
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].User < statuses[j].User })
+	return statuses
+}
+
+// Metrics returns the users, certificates and connection history in the prometheus format.
+func (app *App) Metrics(ctx context.Context) ([]server.Metric, error) {
+	app.userMutex.Lock()
+	metrics := []server.Metric{
+		{Name: "openvpn_updater_members", Help: "Members of the vpn group.", Type: "gauge", Value: float64(len(app.IamUsers))},
+		{Name: "openvpn_updater_certificates", Help: "Valid client certificates.", Type: "gauge",
+			Value: float64(len(app.OpenVpnConfig.CertificateInfos))},
+	}
+	app.userMutex.Unlock()
+
+	connected := 0
+	statuses := app.connectionStatuses()
+	for _, c := range statuses {
+		if c.Connected {
+			connected++
+		}
+	}
+	metrics = append(metrics, server.Metric{Name: "openvpn_updater_connected_clients", Help: "Clients connected at the last status update.",
+		Type: "gauge", Value: float64(connected)})
+	for _, c := range statuses {
+		user := map[string]string{"user": c.User}
+		value := 0.0
+		if c.Connected {
+			value = 1
+		}
+		metrics = append(metrics,
+			server.Metric{Name: "openvpn_updater_client_connected", Help: "Whether the user is connected.", Type: "gauge", Labels: user, Value: value},
+			server.Metric{Name: "openvpn_updater_client_last_connected_timestamp_seconds", Help: "Start of the last session of the user.",
+				Type: "gauge", Labels: user, Value: float64(c.LastConnected.Unix())},
+			server.Metric{Name: "openvpn_updater_client_received_bytes_total", Help: "Bytes received from the user.", Type: "counter",
+				Labels: user, Value: float64(c.BytesReceived)},
+			server.Metric{Name: "openvpn_updater_client_sent_bytes_total", Help: "Bytes sent to the user.", Type: "counter",
+				Labels: user, Value: float64(c.BytesSent)},
+			server.Metric{Name: "openvpn_updater_client_session_seconds", Help: "Duration of the sessions of the user kept in history.",
+				Type: "gauge", Labels: user, Value: float64(c.SessionSeconds)})
+	}
+	return metrics, nil
+}
@@ -0,0 +1,162 @@
+package app
+
+import (
+	"context"
+	"time"
+
+	"github.com/FinalCAD/vpn-stack/aws-openvpn-updater/internal/awssdk"
+	"github.com/FinalCAD/vpn-stack/aws-openvpn-updater/internal/policy"
+	"github.com/FinalCAD/vpn-stack/aws-openvpn-updater/internal/state"
+	"github.com/rs/zerolog/log"
+)
+
+const day = 24 * time.Hour
+
+// dormantCandidate is a member of the vpn group without connection since the warning threshold, or
+// revoked for dormancy.
+type dormantCandidate struct {
+	user   awssdk.User
+	last   time.Time
+	record state.Dormancy
+}
+
+// revokeDormant warns then revokes the members of the vpn group that did not connect for
+// settings.dormant-days. Revoked users are not issued again until they leave the vpn group or get the
+// vpn-keep tag.
+func (app *App) revokeDormant(ctx context.Context) {
+	days := app.Settings.Params.DormantDays
+	if days == 0 {
+		return
+	}
+	dormancy := time.Duration(days) * day
+	warning := time.Duration(app.Settings.Params.DormantWarning) * day
+	now := time.Now()
+
+	var candidates []dormantCandidate
+	var left []string
+	app.State.View(func(data *state.Data) {
+		for name, record := range data.Dormant {
+			user, found := app.iamUser(name)
+			if !app.isMember(name) {
+				left = append(left, name)
+			} else if found && !record.Revoked.IsZero() {
+				candidates = append(candidates, dormantCandidate{user: user, record: *record})
+			}
+		}
+		// Activity is unknown before the history started
+		if data.ConnectionsSince.IsZero() {
+			return
+		}
+		for _, certInfo := range app.OpenVpnConfig.CertificateInfos {
+			user, found := app.iamUser(certInfo.Name)
+			if !found {
+				continue
+			}
+			candidate := dormantCandidate{user: user, last: data.LastActivity(certInfo.Name, certInfo.Issued)}
+			if record, ok := data.Dormant[certInfo.Name]; ok {
+				if !record.Revoked.IsZero() {
+					continue
+				}
+				candidate.record = *record
+			}
+			candidates = append(candidates, candidate)
+		}
+	})
+
+	app.forgetDormant(left)
+	for _, candidate := range candidates {
+		if ctx.Err() != nil {
+			log.Info().Msg("Shutdown requested, skipping remaining dormant users")
+			return
+		}
+		keep := app.userPolicy(candidate.user.Name).Keep
+		stepCtx, cancel := stepContext()
+		switch action := candidate.record.NextAction(candidate.last, now, dormancy, warning, keep); action {
+		case state.DormantEnable:
+			log.Info().Msgf("Re-enabling dormant user %s with the %s tag", candidate.user.Name, policy.KeepTag)
+			if !app.Settings.Params.Dryrun {
+				app.recordDormant(candidate.user.Name, action, now)
+			}
+		case state.DormantWarn:
+			app.warnDormant(stepCtx, candidate.user, candidate.last.Add(dormancy), now)
+		case state.DormantRevoke:
+			app.revokeDormantUser(stepCtx, candidate.user, now)
+		default:
+			if keep && now.Sub(candidate.last) >= dormancy-warning {
+				log.Debug().Msgf("Dormant user %s is kept by its %s tag", candidate.user.Name, policy.KeepTag)
+			}
+		}
+		cancel()
+	}
+}
+
+// forgetDormant forgets the users that left the vpn group.
+func (app *App) forgetDormant(left []string) {
+	if len(left) == 0 || app.Settings.Params.Dryrun {
+		return
+	}
+	err := app.State.Update(func(data *state.Data) error {
+		for _, name := range left {
+			delete(data.Dormant, name)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("Error clearing dormant users")
+	}
+}
+
+func (app *App) warnDormant(ctx context.Context, user awssdk.User, deadline time.Time, now time.Time) {
+	log.Info().Msgf("Warning dormant user %s of its revocation on %s", user.Name, deadline.Format("2006-01-02"))
+	if app.Settings.Params.Dryrun {
+		log.Info().Msgf("Dry run warning dormant user: %s", user.Name)
+		return
+	}
+	// The deadline runs from the warning, a user who cannot be mailed is warned in the logs only
+	if _, err := user.Recipient(); err != nil {
+		log.Warn().Err(err).Msgf("Unable to mail the dormancy warning, recording it: %s", user.Name)
+		app.recordDormant(user.Name, state.DormantWarn, now)
+		return
+	}
+	err := app.AwsSdkConfig.SendDormantMail(ctx, app.Settings.Config.Environment, user, app.Settings.Params.DormantDays, deadline, app.Settings.Params.SenderMail)
+	if err != nil {
+		log.Error().Err(err).Msgf("Error sending dormancy warning: %s", user.Name)
+		return
+	}
+	app.recordDormant(user.Name, state.DormantWarn, now)
+}
+
+func (app *App) revokeDormantUser(ctx context.Context, user awssdk.User, now time.Time) {
+	log.Info().Msgf("Revoking dormant user: %s", user.Name)
+	if app.Settings.Params.Dryrun {
+		log.Info().Msgf("Dry run revoking dormant user: %s", user.Name)
+		return
+	}
+	if !app.deleteUser(ctx, user.Name) {
+		return
+	}
+	app.recordDormant(user.Name, state.DormantRevoke, now)
+}
+
+func (app *App) recordDormant(user string, action state.DormantAction, now time.Time) {
+	err := app.State.Update(func(data *state.Data) error {
+		data.RecordDormant(user, action, now)
+		return nil
+	})
+	if err != nil {
+		log.Error().Err(err).Msgf("Error saving dormancy of user: %s", user)
+	}
+}
+
+// dormantRevoked returns the users revoked for dormancy, not issued again by createUsers.
+func (app *App) dormantRevoked() map[string]bool {
+	revoked := map[string]bool{}
+	app.State.View(func(data *state.Data) {
+		for name, record := range data.Dormant {
+			if !record.Revoked.IsZero() {
+				revoked[name] = true
+			}
+		}
+	})
+	return revoked
+}
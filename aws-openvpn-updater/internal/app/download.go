@@ -0,0 +1,113 @@
+package app
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/FinalCAD/vpn-stack/aws-openvpn-updater/internal/server"
+	"github.com/FinalCAD/vpn-stack/aws-openvpn-updater/internal/state"
+	"github.com/rs/zerolog/log"
+)
+
+// redirectPresignTtl is the lifetime of the S3 url a redeemed download link redirects to.
+const redirectPresignTtl time.Duration = time.Duration(1) * time.Minute
+
+// downloadRetention is how long expired download links are kept for the downloads command and the rotation status.
+const downloadRetention time.Duration = time.Duration(90) * 24 * time.Hour
+
+// createDownloadLink records a one-time link to the uploaded object, valid for presign-ttl.
+func (app *App) createDownloadLink(user string, key string) (string, error) {
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	now := time.Now()
+	download := &state.Download{
+		Token:   base64.RawURLEncoding.EncodeToString(random),
+		User:    user,
+		Key:     key,
+		Created: now,
+		Expires: now.Add(app.AwsSdkConfig.PresignTtl()),
+	}
+	err := app.State.Update(func(data *state.Data) error {
+		data.PruneDownloads(now.Add(-downloadRetention))
+		data.Downloads[download.Token] = download
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s%s%s", strings.TrimSuffix(app.Settings.Server.PublicUrl, "/"), server.DownloadPath, download.Token), nil
+}
+
+// Check returns whether the download link can still be redeemed.
+func (app *App) Check(token string) error {
+	err := server.ErrNotFound
+	app.State.View(func(data *state.Data) {
+		if d, ok := data.Downloads[token]; ok {
+			err = usable(d, time.Now())
+		}
+	})
+	return err
+}
+
+// usable returns server.ErrGone when the download link was used or expired.
+func usable(d *state.Download, now time.Time) error {
+	if !d.DownloadedAt.IsZero() || now.After(d.Expires) {
+		return server.ErrGone
+	}
+	return nil
+}
+
+// Redeem returns a short-lived url to the profile and records the use of the download link once the url
+// is presigned. Later uses are refused and counted.
+func (app *App) Redeem(ctx context.Context, token string, remoteAddr string, userAgent string) (string, error) {
+	var download state.Download
+	err := app.State.Update(func(data *state.Data) error {
+		d, ok := data.Downloads[token]
+		if !ok {
+			return server.ErrNotFound
+		}
+		download = *d
+		if usable(d, time.Now()) != nil {
+			d.Refused++
+			log.Warn().Msgf("Refused used or expired download link for user %s from %s, downloaded at %s from %s",
+				d.User, remoteAddr, d.DownloadedAt.Format(time.RFC3339), d.RemoteAddr)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	if err = usable(&download, time.Now()); err != nil {
+		return "", err
+	}
+
+	url, err := app.AwsSdkConfig.PresignS3(ctx, download.Key, redirectPresignTtl)
+	if err != nil {
+		return "", err
+	}
+	err = app.State.Update(func(data *state.Data) error {
+		d, ok := data.Downloads[token]
+		if !ok {
+			return server.ErrNotFound
+		}
+		// Another request redeemed the link while presigning
+		if err := usable(d, time.Now()); err != nil {
+			return err
+		}
+		d.DownloadedAt = time.Now()
+		d.RemoteAddr = remoteAddr
+		d.UserAgent = userAgent
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	log.Info().Msgf("Profile downloaded for user %s from %s", download.User, remoteAddr)
+	return url, nil
+}
@@ -0,0 +1,219 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/FinalCAD/vpn-stack/aws-openvpn-updater/internal/awssdk"
+	"github.com/FinalCAD/vpn-stack/aws-openvpn-updater/internal/state"
+	"github.com/rs/zerolog/log"
+)
+
+// noticeAccessExpiry is the kind of the notices sent before the end of the access of a user.
+const noticeAccessExpiry string = "access-expiry"
+
+const expiryOpsTXT string = "The vpn access of %s to %s ends on %s at midnight (UTC), its certificate will be revoked then."
+
+// notifyExpiries notifies the members with a certificate, and the operators, expiry.notice-days before
+// the end of their access. Each notice is sent once per end date, a new date is notified again.
+func (app *App) notifyExpiries(ctx context.Context) {
+	notice := time.Duration(app.Settings.Expiry.NoticeDays) * day
+	now := time.Now()
+	var due []awssdk.User
+	app.State.View(func(data *state.Data) {
+		for _, user := range app.IamUsers {
+			expires := app.userPolicy(user.Name).Expires
+			if expires.IsZero() || expires.Sub(now) > notice || !app.hasCertificate(user.Name) {
+				continue
+			}
+			if !data.Notified(noticeAccessExpiry, user.Name, expires) {
+				due = append(due, user)
+			}
+		}
+	})
+	for _, user := range due {
+		if ctx.Err() != nil {
+			log.Info().Msg("Shutdown requested, skipping remaining expiry notices")
+			return
+		}
+		stepCtx, cancel := stepContext()
+		app.notifyExpiry(stepCtx, user, now)
+		cancel()
+	}
+	if app.Settings.Params.Dryrun {
+		return
+	}
+	err := app.State.Update(func(data *state.Data) error {
+		data.PruneNotices(now)
+		return nil
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("Error pruning notices")
+	}
+}
+
+func (app *App) notifyExpiry(ctx context.Context, user awssdk.User, now time.Time) {
+	expires := app.userPolicy(user.Name).Expires
+	lastDay := expires.AddDate(0, 0, -1).Format("2006-01-02")
+	log.Info().Msgf("Notifying the end of the access of user %s on %s", user.Name, lastDay)
+	if app.Settings.Params.Dryrun {
+		log.Info().Msgf("Dry run notifying access expiry for user: %s", user.Name)
+		return
+	}
+	env := app.Settings.Config.Environment
+	if app.Settings.Params.SendMail {
+		err := app.AwsSdkConfig.SendExpiryMail(ctx, env, user, expires, app.Settings.Params.SenderMail)
+		if err != nil {
+			log.Error().Err(err).Msgf("Error sending access expiry notice: %s", user.Name)
+			return
+		}
+	}
+	err := app.notifyOps(ctx, fmt.Sprintf("VPN access of %s to %s ends soon", user.Name, env), fmt.Sprintf(expiryOpsTXT, user.Name, env, lastDay), user.Name)
+	if err != nil {
+		log.Error().Err(err).Msgf("Error notifying operators of access expiry: %s", user.Name)
+	}
+	err = app.State.Update(func(data *state.Data) error {
+		data.RecordNotice(noticeAccessExpiry, user.Name, expires, now)
+		return nil
+	})
+	if err != nil {
+		log.Error().Err(err).Msgf("Error saving access expiry notice: %s", user.Name)
+	}
+}
+
+// noticeExpiryDigest is the kind of the daily digest of the operators.
+const noticeExpiryDigest string = "expiry-digest"
+
+type certificateNotice struct {
+	user   awssdk.User
+	expiry time.Time
+	kind   string
+}
+
+// notifyCertificateExpiries warns the members before the expiry of their certificate in index.txt, once
+// per expiry.certificate-notice-days threshold. Only the smallest threshold crossed is sent, so that a
+// notice missed while the updater was stopped does not follow a later one. Users whose access ends
+// before are left to the access expiry notice, certificates of vpn-validity-days are renewed instead.
+func (app *App) notifyCertificateExpiries(ctx context.Context) {
+	thresholds := app.Settings.Expiry.CertificateNoticeDays
+	if len(thresholds) == 0 || !app.Settings.Params.SendMail {
+		return
+	}
+	now := time.Now()
+	var due []certificateNotice
+	app.State.View(func(data *state.Data) {
+		for _, certInfo := range app.OpenVpnConfig.CertificateInfos {
+			user, found := app.iamUser(certInfo.Name)
+			if !found {
+				continue
+			}
+			expiry, err := certInfo.Expiry()
+			if err != nil {
+				continue
+			}
+			p := app.userPolicy(user.Name)
+			if !p.Expires.IsZero() && p.Expires.Before(expiry.Add(day)) {
+				continue
+			}
+			// Renewed by createUsers
+			if p.ValidityDays != 0 {
+				continue
+			}
+			kind := state.CertificateNoticeKind(thresholds, expiry.Sub(now))
+			if kind != "" && !data.Notified(kind, user.Name, expiry) {
+				due = append(due, certificateNotice{user: user, expiry: expiry, kind: kind})
+			}
+		}
+	})
+	for _, notice := range due {
+		if ctx.Err() != nil {
+			log.Info().Msg("Shutdown requested, skipping remaining certificate expiry notices")
+			return
+		}
+		stepCtx, cancel := stepContext()
+		app.notifyCertificateExpiry(stepCtx, notice, now)
+		cancel()
+	}
+}
+
+func (app *App) notifyCertificateExpiry(ctx context.Context, notice certificateNotice, now time.Time) {
+	log.Info().Msgf("Notifying the expiry of the certificate of user %s on %s", notice.user.Name, notice.expiry.Format(time.RFC3339))
+	if app.Settings.Params.Dryrun {
+		log.Info().Msgf("Dry run notifying certificate expiry for user: %s", notice.user.Name)
+		return
+	}
+	err := app.AwsSdkConfig.SendCertificateExpiryMail(ctx, app.Settings.Config.Environment, notice.user, notice.expiry, app.Settings.Params.SenderMail)
+	if err != nil {
+		log.Error().Err(err).Msgf("Error sending certificate expiry notice: %s", notice.user.Name)
+		return
+	}
+	err = app.State.Update(func(data *state.Data) error {
+		data.RecordNotice(notice.kind, notice.user.Name, notice.expiry, now)
+		return nil
+	})
+	if err != nil {
+		log.Error().Err(err).Msgf("Error saving certificate expiry notice: %s", notice.user.Name)
+	}
+}
+
+// sendExpiryDigest sends the operators, once a day, the certificates expiring and the accesses ending
+// within expiry.digest-days.
+func (app *App) sendExpiryDigest(ctx context.Context) {
+	days := app.Settings.Expiry.DigestDays
+	if days == 0 || (app.Settings.Ops.Email == "" && app.Settings.Ops.WebhookUrl == "") {
+		return
+	}
+	now := time.Now()
+	// The digest of a day is due at its end
+	due := now.UTC().Truncate(day).Add(day)
+	var sent bool
+	app.State.View(func(data *state.Data) {
+		sent = data.Notified(noticeExpiryDigest, "", due)
+	})
+	if sent {
+		return
+	}
+	lines := app.upcomingExpiries(now, time.Duration(days)*day)
+	if len(lines) == 0 {
+		return
+	}
+	env := app.Settings.Config.Environment
+	log.Info().Msgf("Sending the digest of %d expirations within %d days", len(lines), days)
+	if app.Settings.Params.Dryrun {
+		log.Info().Msg("Dry run sending expiry digest")
+		return
+	}
+	err := app.notifyOps(ctx, fmt.Sprintf("VPN expirations for %s within %d days", env, days), strings.Join(lines, "\n"), "")
+	if err != nil {
+		log.Error().Err(err).Msg("Error sending expiry digest")
+		return
+	}
+	err = app.State.Update(func(data *state.Data) error {
+		data.RecordNotice(noticeExpiryDigest, "", due, now)
+		return nil
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("Error saving expiry digest notice")
+	}
+}
+
+// upcomingExpiries lists the certificates of the members expiring and their accesses ending within window, by date.
+func (app *App) upcomingExpiries(now time.Time, window time.Duration) []string {
+	var expirations []state.Expiration
+	for _, certInfo := range app.OpenVpnConfig.CertificateInfos {
+		if _, found := app.iamUser(certInfo.Name); !found {
+			continue
+		}
+		expiry, err := certInfo.Expiry()
+		if err != nil {
+			continue
+		}
+		expirations = append(expirations, state.Expiration{User: certInfo.Name, Date: expiry})
+	}
+	for _, user := range app.IamUsers {
+		expirations = append(expirations, state.Expiration{User: user.Name, Date: app.userPolicy(user.Name).Expires, Access: true})
+	}
+	return state.UpcomingExpirations(expirations, now, window)
+}
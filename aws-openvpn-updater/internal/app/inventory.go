@@ -0,0 +1,90 @@
+package app
+
+import (
+	"context"
+
+	"github.com/FinalCAD/vpn-stack/aws-openvpn-updater/internal/openvpn"
+	"github.com/rs/zerolog/log"
+)
+
+// Inventory reports the artifacts left without a valid certificate: client configs, S3 objects,
+// certificates missing from the index and private keys. They are removed when inventory-cleanup
+// is enabled, following the dry-run rules.
+func (app *App) Inventory(ctx context.Context) {
+	if app.rotation().Active() {
+		log.Info().Msg("Inventory skipped during the ca rotation")
+		return
+	}
+	// The index may have changed during this loop
+	app.userMutex.Lock()
+	err := app.OpenVpnConfig.GetUser()
+	if err != nil {
+		app.userMutex.Unlock()
+		log.Error().Err(err).Msg("Error getting openvpn users")
+		return
+	}
+	inventory, err := app.OpenVpnConfig.ScanInventory()
+	app.userMutex.Unlock()
+	if err != nil {
+		log.Error().Err(err).Msg("Error scanning openvpn inventory")
+		return
+	}
+	for _, file := range inventory.OrphanedConfigs {
+		log.Warn().Msgf("Inventory: client config without valid certificate: %s", file)
+	}
+	for _, file := range inventory.UnindexedCerts {
+		log.Warn().Msgf("Inventory: issued certificate missing from index: %s", file)
+	}
+	for _, file := range inventory.LeftoverKeys {
+		log.Warn().Msgf("Inventory: private key without valid certificate: %s", file)
+	}
+
+	var strayObjects []string
+	if app.Settings.Params.S3Upload {
+		strayObjects, err = app.strayObjects(ctx)
+		if err != nil {
+			log.Error().Err(err).Msg("Error listing S3 client configs")
+		}
+		for _, key := range strayObjects {
+			log.Warn().Msgf("Inventory: S3 object without valid certificate: %s", key)
+		}
+	}
+
+	if !app.Settings.Params.InventoryClean || len(inventory.Files())+len(strayObjects) == 0 {
+		return
+	}
+	if app.Settings.Params.Dryrun {
+		log.Info().Msgf("Dry run removing %d stray files and %d stray S3 objects", len(inventory.Files()), len(strayObjects))
+		return
+	}
+	err = app.OpenVpnConfig.RemoveInventory(inventory)
+	if err != nil {
+		log.Error().Err(err).Msg("Error removing stray files")
+	}
+	for _, key := range strayObjects {
+		err = app.AwsSdkConfig.RemoveObjectS3(ctx, key)
+		if err != nil {
+			log.Error().Err(err).Msgf("Error removing stray S3 object: %s", key)
+			continue
+		}
+		log.Info().Msgf("Removed stray S3 object: %s", key)
+	}
+}
+
+func (app *App) strayObjects(ctx context.Context) ([]string, error) {
+	clients := map[string]bool{}
+	for _, certInfo := range app.OpenVpnConfig.CertificateInfos {
+		clients[certInfo.Name] = true
+	}
+	keys, err := app.AwsSdkConfig.ListConfS3(ctx, app.Settings.Config.Environment)
+	if err != nil {
+		return nil, err
+	}
+	var stray []string
+	for _, key := range keys {
+		if !clients[openvpn.ProfileUser(key)] {
+			stray = append(stray, key)
+		}
+	}
+	return stray, nil
+}
@@ -0,0 +1,105 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"sort"
+
+	"github.com/FinalCAD/vpn-stack/aws-openvpn-updater/internal/awssdk"
+	"github.com/rs/zerolog/log"
+)
+
+// keyAlgorithms counts the valid client certificates per key algorithm.
+func (app *App) keyAlgorithms() map[string]int {
+	algorithms := map[string]int{}
+	for _, certInfo := range app.OpenVpnConfig.CertificateInfos {
+		algorithms[certInfo.Algorithm]++
+	}
+	return algorithms
+}
+
+// outdatedKeys returns the sorted users whose certificate key algorithm differs from the configured one,
+// none when it depends on the easyrsa vars file.
+func (app *App) outdatedKeys() []string {
+	expected := app.OpenVpnConfig.KeyOptions.Algorithm()
+	if expected == "" {
+		return nil
+	}
+	var outdated []string
+	for _, certInfo := range app.OpenVpnConfig.CertificateInfos {
+		if certInfo.Algorithm != expected {
+			outdated = append(outdated, certInfo.Name)
+		}
+	}
+	sort.Strings(outdated)
+	return outdated
+}
+
+// migrateKeys logs the certificates with another key algorithm than the configured one and, with key-migrate,
+// reissues those of the vpn group members, at most refresh-limit per loop.
+func (app *App) migrateKeys(ctx context.Context) {
+	outdated := app.outdatedKeys()
+	if len(outdated) == 0 {
+		return
+	}
+	log.Info().Msgf("%d certificates use another key algorithm than %s: %v, algorithms: %v", len(outdated),
+		app.OpenVpnConfig.KeyOptions.Algorithm(), outdated, app.keyAlgorithms())
+	if !app.Settings.OpenVpn.KeyMigrate || app.Settings.Params.RefreshLimit == 0 {
+		return
+	}
+	if app.rotation().Active() {
+		log.Debug().Msg("Key migration suspended during the ca rotation")
+		return
+	}
+	migrated := 0
+	for _, name := range outdated {
+		user, found := app.iamUser(name)
+		if !found {
+			continue
+		}
+		if ctx.Err() != nil {
+			log.Info().Msg("Shutdown requested, skipping remaining key migrations")
+			return
+		}
+		if migrated == app.Settings.Params.RefreshLimit {
+			log.Info().Msg("Refresh limit reached, remaining keys are migrated by the next loops")
+			return
+		}
+		stepCtx, cancel := stepContext()
+		app.migrateKey(stepCtx, user)
+		cancel()
+		migrated++
+	}
+}
+
+// migrateKey revokes the certificate of the user and issues one with the configured key algorithm.
+func (app *App) migrateKey(ctx context.Context, user awssdk.User) {
+	app.userMutex.Lock()
+	defer app.userMutex.Unlock()
+	log.Info().Msgf("Migrating key of user: %s", user.Name)
+	if app.Settings.Params.Dryrun {
+		log.Info().Msgf("Dry run migrating key for user: %s", user.Name)
+		return
+	}
+	err := app.OpenVpnConfig.DeleteUser(ctx, user.Name)
+	if err != nil {
+		log.Error().Err(err).Msgf("Error revoking openvpn client config: %s", user.Name)
+		return
+	}
+	filePath, passphrase, err := app.issueUser(ctx, user)
+	if err != nil {
+		log.Error().Err(err).Msgf("Error reissuing openvpn client config: %s", user.Name)
+		return
+	}
+	app.recordProfile(user.Name)
+	err = app.deliverProfile(ctx, user, filePath, passphrase, true)
+	if errors.Is(err, errPassphraseUndelivered) {
+		app.withdrawUser(ctx, user.Name, err)
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Msgf("Error delivering client config: %s", user.Name)
+		return
+	}
+	log.Info().Msgf("Migrated key successfully: %s", user.Name)
+}
@@ -0,0 +1,21 @@
+package app
+
+import (
+	"context"
+	"errors"
+
+	"github.com/FinalCAD/vpn-stack/aws-openvpn-updater/internal/notify"
+)
+
+// notifyOps sends a notice to the operators by email and webhook, whichever are configured. user is
+// the subject of the notice, empty for notices about several users.
+func (app *App) notifyOps(ctx context.Context, subject string, text string, user string) error {
+	var errs []error
+	if app.Settings.Ops.Email != "" {
+		errs = append(errs, app.AwsSdkConfig.SendOpsMail(ctx, app.Settings.Ops.Email, subject, text, app.Settings.Params.SenderMail))
+	}
+	if app.Settings.Ops.WebhookUrl != "" {
+		errs = append(errs, notify.CreateWebhook(app.Settings.Ops.WebhookUrl).Send(ctx, notify.Message{User: user, Text: subject + "\n" + text}))
+	}
+	return errors.Join(errs...)
+}
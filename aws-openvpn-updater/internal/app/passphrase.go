@@ -0,0 +1,85 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/FinalCAD/vpn-stack/aws-openvpn-updater/internal/awssdk"
+	"github.com/FinalCAD/vpn-stack/aws-openvpn-updater/internal/notify"
+	"github.com/FinalCAD/vpn-stack/aws-openvpn-updater/internal/openvpn"
+	"github.com/FinalCAD/vpn-stack/aws-openvpn-updater/internal/settings"
+	"github.com/FinalCAD/vpn-stack/aws-openvpn-updater/internal/state"
+	"github.com/rs/zerolog/log"
+)
+
+// errPassphraseUndelivered marks a profile whose passphrase could not be sent, its certificate must be reissued.
+var errPassphraseUndelivered = errors.New("passphrase not delivered")
+
+const passphraseWebhookTXT string = "The private key of your %s vpn configuration is protected by the passphrase %s , your vpn client asks for it when connecting."
+
+// userPassphrase generates a passphrase when the environment or one of the IAM groups of the user requires it.
+func (app *App) userPassphrase(ctx context.Context, user awssdk.User) (string, error) {
+	required := app.Settings.Passphrase.Enabled
+	if !required && len(app.Settings.Passphrase.Groups) > 0 {
+		groups, err := app.AwsSdkConfig.GetUserGroups(ctx, user.Account)
+		if err != nil {
+			return "", err
+		}
+		required = intersects(groups, app.Settings.Passphrase.Groups)
+	}
+	if !required {
+		return "", nil
+	}
+	return openvpn.GeneratePassphrase()
+}
+
+// sendPassphrase delivers the passphrase over the configured channel, never along with the profile link.
+func (app *App) sendPassphrase(ctx context.Context, user awssdk.User, passphrase string) error {
+	env := app.Settings.Config.Environment
+	if app.Settings.Passphrase.Channel != settings.PassphraseChannelWebhook {
+		return app.AwsSdkConfig.SendPassphraseMail(ctx, env, user, passphrase, app.Settings.Params.SenderMail)
+	}
+	email, err := user.Recipient()
+	if err != nil {
+		return err
+	}
+	return notify.CreateWebhook(app.Settings.Passphrase.WebhookUrl).Send(ctx, notify.Message{
+		Email: email,
+		User:  user.Name,
+		Text:  fmt.Sprintf(passphraseWebhookTXT, env, passphrase),
+	})
+}
+
+// withdrawUser revokes the certificate of a user whose passphrase was lost so that createUsers issues a new one
+// with a new passphrase at the next loop. The caller holds userMutex.
+func (app *App) withdrawUser(ctx context.Context, user string, cause error) {
+	log.Error().Err(cause).Msgf("Error delivering passphrase, revoking the certificate to reissue it at the next loop: %s", user)
+	err := app.OpenVpnConfig.DeleteUser(ctx, user)
+	if err != nil {
+		log.Error().Err(err).Msgf("Error revoking openvpn client config: %s", user)
+		return
+	}
+	err = app.revokeUnderNextCa(ctx, user)
+	if err != nil {
+		log.Error().Err(err).Msgf("Error revoking openvpn client config under the new ca: %s", user)
+	}
+	err = app.State.Update(func(data *state.Data) error {
+		delete(data.Profiles, user)
+		return nil
+	})
+	if err != nil {
+		log.Error().Err(err).Msgf("Error removing profile state of user: %s", user)
+	}
+}
+
+func intersects(a []string, b []string) bool {
+	for _, x := range a {
+		for _, y := range b {
+			if x == y {
+				return true
+			}
+		}
+	}
+	return false
+}
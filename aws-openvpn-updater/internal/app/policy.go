@@ -0,0 +1,164 @@
+package app
+
+import (
+	"context"
+	"net/netip"
+	"sort"
+	"time"
+
+	"github.com/FinalCAD/vpn-stack/aws-openvpn-updater/internal/awssdk"
+	"github.com/FinalCAD/vpn-stack/aws-openvpn-updater/internal/openvpn"
+	"github.com/FinalCAD/vpn-stack/aws-openvpn-updater/internal/policy"
+	"github.com/FinalCAD/vpn-stack/aws-openvpn-updater/internal/settings"
+	"github.com/rs/zerolog/log"
+)
+
+// lookupPolicies reads the tags of the members of the vpn group once per loop and returns the members
+// not disabled nor expired, with their email and locale, their policies and the members skipped. The previous
+// policy of a user is kept when its tags cannot be read, users without one are skipped until a later loop.
+func (app *App) lookupPolicies(ctx context.Context, users []awssdk.User) ([]awssdk.User, map[string]policy.Policy, map[string]bool, error) {
+	app.userMutex.Lock()
+	previous := app.policies
+	app.userMutex.Unlock()
+	// Checked by Settings.Validate
+	groupExpiries, _ := settings.ParseGroupExpiries(app.Settings.Expiry.Groups)
+	now := time.Now()
+
+	policies := map[string]policy.Policy{}
+	skipped := map[string]bool{}
+	var enabled []awssdk.User
+	for _, user := range users {
+		if ctx.Err() != nil {
+			return nil, nil, nil, ctx.Err()
+		}
+		p, err := app.readPolicy(ctx, user, groupExpiries)
+		if err != nil {
+			var ok bool
+			p, ok = previous[user.Name]
+			if !ok {
+				log.Error().Err(err).Msgf("Unable to read policy of %s, skipping it", user.Name)
+				skipped[user.Name] = true
+				continue
+			}
+			log.Warn().Err(err).Msgf("Unable to read policy of %s, keeping its previous one", user.Name)
+		}
+		policies[user.Name] = p
+		if p.Disabled {
+			log.Debug().Msgf("User %s is disabled by its %s tag", user.Name, policy.DisabledTag)
+			continue
+		}
+		if p.Expired(now) {
+			log.Debug().Msgf("Access of user %s expired on %s", user.Name, p.Expires.Format(time.RFC3339))
+			continue
+		}
+		user.Email = p.Email
+		user.Locale = p.Locale
+		enabled = append(enabled, user)
+	}
+	return enabled, policies, skipped, nil
+}
+
+// readPolicy reads the policy of the user from its tags, the access ends at the earliest date of its
+// groups without vpn-expires tag.
+func (app *App) readPolicy(ctx context.Context, user awssdk.User, groupExpiries map[string]time.Time) (policy.Policy, error) {
+	tags, err := app.AwsSdkConfig.GetUserTags(ctx, user.Account)
+	if err != nil {
+		return policy.Policy{}, err
+	}
+	p, errs := policy.Parse(tags)
+	for _, err := range errs {
+		log.Warn().Err(err).Msgf("Ignoring tag of %s", user.Name)
+	}
+	if !p.Expires.IsZero() || len(groupExpiries) == 0 {
+		return p, nil
+	}
+	groups, err := app.AwsSdkConfig.GetUserGroups(ctx, user.Account)
+	if err != nil {
+		return policy.Policy{}, err
+	}
+	for _, group := range groups {
+		if expires, ok := groupExpiries[group]; ok && (p.Expires.IsZero() || expires.Before(p.Expires)) {
+			p.Expires = expires
+		}
+	}
+	return p, nil
+}
+
+// isMember tells whether the user is a member of the vpn group enabled by the last lookup, or whose policy could
+// not be read. Skipped members are neither issued nor revoked.
+func (app *App) isMember(user string) bool {
+	_, found := app.iamUser(user)
+	return found || app.skipped[user]
+}
+
+// userPolicy returns the policy of the user read by the last lookup.
+func (app *App) userPolicy(user string) policy.Policy {
+	return app.policies[user]
+}
+
+// writeClientConfigs writes the client-config-dir files of the members with routes or a static address,
+// and removes those of the other users. Static addresses assigned twice are kept by the first user by name.
+func (app *App) writeClientConfigs() {
+	dir := app.Settings.OpenVpn.ClientConfigDir
+	if dir == "" {
+		return
+	}
+	app.userMutex.Lock()
+	defer app.userMutex.Unlock()
+
+	configs := map[string]string{}
+	assigned := map[string]string{}
+	names := make([]string, 0, len(app.IamUsers))
+	for _, user := range app.IamUsers {
+		names = append(names, user.Name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		p := app.userPolicy(name)
+		if p.StaticIp.IsValid() {
+			address := p.StaticIp.Addr().String()
+			if owner, ok := assigned[address]; ok {
+				log.Warn().Msgf("Static address %s of %s is already assigned to %s, ignoring it", address, name, owner)
+				p.StaticIp = netip.Prefix{}
+			} else {
+				assigned[address] = name
+			}
+		}
+		if p.HasClientConfig() {
+			configs[name] = openvpn.ClientConfig(p.Routes, p.StaticIp)
+		}
+	}
+
+	for name, content := range configs {
+		if app.Settings.Params.Dryrun {
+			log.Info().Msgf("Dry run writing client config for user: %s", name)
+			continue
+		}
+		changed, err := openvpn.WriteClientConfig(dir, name, content)
+		if err != nil {
+			log.Error().Err(err).Msgf("Error writing client config: %s", name)
+			continue
+		}
+		if changed {
+			log.Info().Msgf("Wrote client config of user %s, applied at its next connection", name)
+		}
+	}
+	written, err := openvpn.ClientConfigs(dir)
+	if err != nil {
+		log.Error().Err(err).Msg("Error listing client configs")
+		return
+	}
+	for _, name := range written {
+		if _, ok := configs[name]; ok || app.skipped[name] {
+			continue
+		}
+		log.Info().Msgf("Removing client config of user: %s", name)
+		if app.Settings.Params.Dryrun {
+			continue
+		}
+		err = openvpn.RemoveClientConfig(dir, name)
+		if err != nil {
+			log.Error().Err(err).Msgf("Error removing client config: %s", name)
+		}
+	}
+}
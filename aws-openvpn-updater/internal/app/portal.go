@@ -0,0 +1,130 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/FinalCAD/vpn-stack/aws-openvpn-updater/internal/awssdk"
+	"github.com/FinalCAD/vpn-stack/aws-openvpn-updater/internal/portal"
+	"github.com/rs/zerolog/log"
+)
+
+func (app *App) createPortal() (*portal.Portal, error) {
+	return portal.CreatePortal(portal.Config{
+		Environment:  app.Settings.Config.Environment,
+		Issuer:       app.Settings.Portal.Issuer,
+		ClientId:     app.Settings.Portal.ClientId,
+		ClientSecret: app.Settings.Portal.ClientSecret,
+		RedirectUrl:  strings.TrimSuffix(app.Settings.Server.PublicUrl, "/") + portal.CallbackPath,
+		UserClaim:    app.Settings.Portal.UserClaim,
+		SessionTtl:   time.Duration(app.Settings.Portal.SessionTtl) * time.Second,
+	}, app)
+}
+
+// LookupUser maps an identity to the IAM user of the vpn group, by email tag for the email claim,
+// by IAM user name otherwise. Members are those of the last lookup.
+func (app *App) LookupUser(ctx context.Context, claim string, value string) (string, error) {
+	app.userMutex.Lock()
+	defer app.userMutex.Unlock()
+	for _, user := range app.IamUsers {
+		if claim != "email" {
+			if user.Account == value {
+				return user.Name, nil
+			}
+			continue
+		}
+		if user.Email != "" && strings.EqualFold(user.Email, value) {
+			return user.Name, nil
+		}
+	}
+	return "", portal.ErrUnknownUser
+}
+
+func (app *App) hasCertificate(user string) bool {
+	for _, account := range app.OpenVpnConfig.CertificateInfos {
+		if account.Name == user {
+			return true
+		}
+	}
+	return false
+}
+
+// DownloadProfile uploads the current profile of the user again and returns a presigned url to it.
+func (app *App) DownloadProfile(ctx context.Context, user string) (string, error) {
+	app.userMutex.Lock()
+	defer app.userMutex.Unlock()
+	if app.Settings.Params.Dryrun {
+		return "", errors.New("download disabled by dry run")
+	}
+	if !app.hasCertificate(user) {
+		return "", fmt.Errorf("no valid certificate for user %s yet", user)
+	}
+	iamUser, found := app.iamUser(user)
+	if !found {
+		return "", fmt.Errorf("user %s is not a member of the vpn group", user)
+	}
+	filePath, err := app.profileConfig(user).WriteProfile(ctx, user, app.userProfile(iamUser))
+	if err != nil {
+		return "", err
+	}
+	app.recordProfile(user)
+	return app.AwsSdkConfig.SaveConfS3(ctx, app.Settings.Config.Environment, user, filePath)
+}
+
+// ReissueProfile revokes the certificate of the user and issues a new one. The request context is not
+// used for easyrsa so that a disconnected client cannot leave the user without certificate.
+func (app *App) ReissueProfile(ctx context.Context, user string) (string, error) {
+	app.userMutex.Lock()
+	defer app.userMutex.Unlock()
+	if app.Settings.Params.Dryrun {
+		return "", errors.New("reissue disabled by dry run")
+	}
+	if !app.hasCertificate(user) {
+		return "", fmt.Errorf("no valid certificate for user %s yet", user)
+	}
+	iamUser, found := app.iamUser(user)
+	if !found {
+		return "", fmt.Errorf("user %s is not a member of the vpn group", user)
+	}
+	stepCtx, cancel := stepContext()
+	defer cancel()
+
+	log.Info().Msgf("Reissuing user: %s", user)
+	err := app.OpenVpnConfig.DeleteUser(stepCtx, user)
+	if err != nil {
+		return "", err
+	}
+	err = app.revokeUnderNextCa(stepCtx, user)
+	if err != nil {
+		return "", err
+	}
+	filePath, passphrase, err := app.issueUser(stepCtx, iamUser)
+	if err != nil {
+		return "", err
+	}
+	app.recordProfile(user)
+	presignUrl, err := app.AwsSdkConfig.SaveConfS3(stepCtx, app.Settings.Config.Environment, user, filePath)
+	if err != nil {
+		return "", err
+	}
+	if passphrase != "" {
+		err = app.sendPassphrase(stepCtx, iamUser, passphrase)
+		if err != nil {
+			app.withdrawUser(stepCtx, user, err)
+			return "", fmt.Errorf("%w: %w", errPassphraseUndelivered, err)
+		}
+	}
+	return presignUrl, nil
+}
+
+func (app *App) iamUser(name string) (awssdk.User, bool) {
+	for _, user := range app.IamUsers {
+		if user.Name == name {
+			return user, true
+		}
+	}
+	return awssdk.User{}, false
+}
@@ -0,0 +1,71 @@
+package app
+
+import (
+	"context"
+	"path/filepath"
+	"time"
+
+	"github.com/FinalCAD/vpn-stack/aws-openvpn-updater/internal/awssdk"
+	"github.com/FinalCAD/vpn-stack/aws-openvpn-updater/internal/openvpn"
+	"github.com/FinalCAD/vpn-stack/aws-openvpn-updater/internal/settings"
+)
+
+// issueUser creates the certificate and client profile of the user and returns the profile path
+// and the passphrase encrypting the key, empty when the user key is not encrypted.
+func (app *App) issueUser(ctx context.Context, user awssdk.User) (string, string, error) {
+	passphrase, err := app.userPassphrase(ctx, user)
+	if err != nil {
+		return "", "", err
+	}
+	profile := app.userProfile(user)
+	profile.Passphrase = passphrase
+	filePath, err := app.OpenVpnConfig.CreateUser(ctx, user.Name, profile)
+	if err != nil {
+		return "", "", err
+	}
+	if app.nextConfig != nil {
+		// Users added during a ca rotation get their profile from the new ca right away, the certificate
+		// of the old ca keeps the membership of the vpn group read from its index until retirement
+		filePath, err = app.nextConfig.CreateUser(ctx, user.Name, profile)
+		if err != nil {
+			return "", "", err
+		}
+		app.recordReissued(user.Name)
+	}
+	return filePath, passphrase, nil
+}
+
+// userProfile returns how the profile of the user is rendered, without passphrase. The format of the user
+// policy overrides the settings, the certificate lasts until the end of its access.
+func (app *App) userProfile(user awssdk.User) openvpn.Profile {
+	// Remotes are checked by Settings.Validate
+	remotes, _ := settings.ParseRemotes(app.Settings.OpenVpn.Remotes)
+	p := app.userPolicy(user.Name)
+	format := p.Format
+	if format == "" {
+		format = app.Settings.Params.ProfileFormat
+	}
+	return openvpn.Profile{UseFqdn: app.Settings.Params.UseFqdn, Remotes: remotes, RemoteRandom: app.Settings.OpenVpn.RemoteRandom,
+		Format: format, ValidityDays: p.CertificateDays(time.Now())}
+}
+
+// importProfileUrl prefixes the profile url so that OpenVPN Connect imports it when the QR code is scanned.
+const importProfileUrl string = "openvpn://import-profile/"
+
+// qrContent returns what the QR code of the mail encodes, empty without QR code. OpenVPN Connect only
+// imports inline profiles, bundles are linked directly.
+func (app *App) qrContent(urlStr string, filePath string) string {
+	if urlStr == "" {
+		return ""
+	}
+	switch app.Settings.Params.QrCode {
+	case settings.QrCodeUrl:
+		return urlStr
+	case settings.QrCodeImport:
+		if filepath.Ext(filePath) != ".ovpn" {
+			return urlStr
+		}
+		return importProfileUrl + urlStr
+	}
+	return ""
+}
@@ -0,0 +1,162 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/FinalCAD/vpn-stack/aws-openvpn-updater/internal/awssdk"
+	"github.com/FinalCAD/vpn-stack/aws-openvpn-updater/internal/openvpn"
+	"github.com/FinalCAD/vpn-stack/aws-openvpn-updater/internal/state"
+	"github.com/rs/zerolog/log"
+)
+
+type staleProfile struct {
+	user    awssdk.User
+	changed []string
+}
+
+// recordProfile stores the fingerprints of the server side inputs the profile of the user was just written from.
+func (app *App) recordProfile(user string) {
+	fingerprints, err := app.profileConfig(user).Fingerprints()
+	if err != nil {
+		log.Error().Err(err).Msgf("Error reading profile inputs of user: %s", user)
+		return
+	}
+	err = app.State.Update(func(data *state.Data) error {
+		data.Profiles[user] = &state.Profile{User: user, Fingerprints: fingerprints, Written: time.Now()}
+		return nil
+	})
+	if err != nil {
+		log.Error().Err(err).Msgf("Error saving profile state of user: %s", user)
+	}
+}
+
+// refreshProfiles regenerates, uploads and sends again the profiles written from server side inputs
+// that changed since, at most refresh-limit per loop. Profiles without recorded fingerprints, written
+// by previous versions, are taken as up to date.
+func (app *App) refreshProfiles(ctx context.Context) {
+	if app.Settings.Params.RefreshLimit == 0 {
+		return
+	}
+	if app.rotation().Active() {
+		log.Debug().Msg("Profile refresh suspended during the ca rotation")
+		return
+	}
+	current, err := app.OpenVpnConfig.Fingerprints()
+	if err != nil {
+		log.Error().Err(err).Msg("Error reading profile inputs")
+		return
+	}
+	stale, err := app.staleProfiles(current)
+	if err != nil {
+		log.Error().Err(err).Msg("Error saving profile state")
+	}
+	for i, profile := range stale {
+		if ctx.Err() != nil {
+			log.Info().Msg("Shutdown requested, skipping remaining profile refreshes")
+			return
+		}
+		if i == app.Settings.Params.RefreshLimit {
+			log.Info().Msgf("Refresh limit reached, %d stale profiles left for the next loops", len(stale)-i)
+			return
+		}
+		stepCtx, cancel := stepContext()
+		app.refreshProfile(stepCtx, profile)
+		cancel()
+	}
+}
+
+// staleProfiles returns the members of the vpn group with a valid certificate whose profile inputs changed,
+// recording the current fingerprints of those without any.
+func (app *App) staleProfiles(current map[string]string) ([]staleProfile, error) {
+	// Portal reissues change the certificates and the members concurrently
+	app.userMutex.Lock()
+	defer app.userMutex.Unlock()
+	certified := map[string]bool{}
+	for _, certInfo := range app.OpenVpnConfig.CertificateInfos {
+		certified[certInfo.Name] = true
+	}
+	var stale []staleProfile
+	var unrecorded []string
+	app.State.View(func(data *state.Data) {
+		for _, user := range app.IamUsers {
+			if !certified[user.Name] {
+				continue
+			}
+			profile, ok := data.Profiles[user.Name]
+			if !ok {
+				unrecorded = append(unrecorded, user.Name)
+				continue
+			}
+			if changed := openvpn.ChangedFingerprints(profile.Fingerprints, current); len(changed) > 0 {
+				stale = append(stale, staleProfile{user: user, changed: changed})
+			}
+		}
+	})
+	if len(unrecorded) == 0 || app.Settings.Params.Dryrun {
+		return stale, nil
+	}
+	return stale, app.State.Update(func(data *state.Data) error {
+		now := time.Now()
+		for _, user := range unrecorded {
+			data.Profiles[user] = &state.Profile{User: user, Fingerprints: current, Written: now}
+		}
+		return nil
+	})
+}
+
+func (app *App) refreshProfile(ctx context.Context, profile staleProfile) {
+	app.userMutex.Lock()
+	defer app.userMutex.Unlock()
+	user := profile.user
+	log.Info().Msgf("Regenerating profile of user %s, changed: %v", user.Name, profile.changed)
+	if app.Settings.Params.Dryrun {
+		log.Info().Msgf("Dry run regenerating profile for user: %s", user.Name)
+		return
+	}
+	for _, name := range profile.changed {
+		switch name {
+		case openvpn.FingerprintCa:
+			err := app.OpenVpnConfig.RemoveP12(user.Name)
+			if err != nil {
+				log.Error().Err(err).Msgf("Error removing PKCS#12 bundle: %s", user.Name)
+				return
+			}
+		case openvpn.FingerprintTlsCrypt:
+			// Client keys are wrapped by the server key, a new one makes them unusable
+			err := app.OpenVpnConfig.RemoveTlsCryptV2Key(user.Name)
+			if err != nil {
+				log.Error().Err(err).Msgf("Error removing tls-crypt-v2 key: %s", user.Name)
+				return
+			}
+		}
+	}
+	filePath, err := app.OpenVpnConfig.WriteProfile(ctx, user.Name, app.userProfile(user))
+	passphrase := ""
+	if errors.Is(err, openvpn.ErrPassphraseRequired) {
+		// The bundle of an encrypted key can only be exported with the passphrase of a new certificate
+		log.Info().Msgf("Reissuing user %s to export its PKCS#12 bundle", user.Name)
+		err = app.OpenVpnConfig.DeleteUser(ctx, user.Name)
+		if err != nil {
+			log.Error().Err(err).Msgf("Error revoking openvpn client config: %s", user.Name)
+			return
+		}
+		filePath, passphrase, err = app.issueUser(ctx, user)
+	}
+	if err != nil {
+		log.Error().Err(err).Msgf("Error regenerating openvpn client config: %s", user.Name)
+		return
+	}
+	app.recordProfile(user.Name)
+	err = app.deliverProfile(ctx, user, filePath, passphrase, true)
+	if errors.Is(err, errPassphraseUndelivered) {
+		app.withdrawUser(ctx, user.Name, err)
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Msgf("Error delivering client config: %s", user.Name)
+		return
+	}
+	log.Info().Msgf("Regenerated profile successfully: %s", user.Name)
+}
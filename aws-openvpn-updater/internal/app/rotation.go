@@ -0,0 +1,314 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/FinalCAD/vpn-stack/aws-openvpn-updater/internal/awssdk"
+	"github.com/FinalCAD/vpn-stack/aws-openvpn-updater/internal/openvpn"
+	"github.com/FinalCAD/vpn-stack/aws-openvpn-updater/internal/state"
+	"github.com/rs/zerolog/log"
+)
+
+// RotationStatus reports the progress of the last ca rotation.
+type RotationStatus struct {
+	Phase     string    `json:"phase"`
+	Started   time.Time `json:"started"`
+	Published time.Time `json:"published,omitempty"`
+	Deadline  time.Time `json:"deadline,omitempty"`
+	Retired   time.Time `json:"retired,omitempty"`
+	// Reissued counts the members of the vpn group reissued under the new ca, Pending lists the others
+	Reissued int      `json:"reissued"`
+	Pending  []string `json:"pending"`
+	// Downloaded counts the reissued profiles downloaded through a one-time link
+	Downloaded int `json:"downloaded"`
+}
+
+func (r RotationStatus) String() string {
+	return fmt.Sprintf("[ Phase: %s, Deadline: %s, Reissued: %d, Pending: %d, Downloaded: %d ]",
+		r.Phase, r.Deadline.Format(time.RFC3339), r.Reissued, len(r.Pending), r.Downloaded)
+}
+
+// rotation returns a copy of the last ca rotation, nil when none was started.
+func (app *App) rotation() *state.Rotation {
+	var rotation *state.Rotation
+	app.State.View(func(data *state.Data) {
+		if data.Rotation != nil {
+			r := *data.Rotation
+			r.Reissued = map[string]time.Time{}
+			for user, reissued := range data.Rotation.Reissued {
+				r.Reissued[user] = reissued
+			}
+			rotation = &r
+		}
+	})
+	return rotation
+}
+
+// lookupNextUsers reads the index of the new ca while the server trusts both cas.
+func (app *App) lookupNextUsers() error {
+	rotation := app.rotation()
+	if rotation == nil || rotation.Phase != state.RotationPublished {
+		app.nextConfig = nil
+		return nil
+	}
+	if app.nextConfig == nil {
+		app.nextConfig = app.OpenVpnConfig.NextConfig()
+	}
+	return app.nextConfig.GetUser()
+}
+
+// profileConfig returns the pki the profile of the user is written from, the new one once reissued.
+func (app *App) profileConfig(user string) *openvpn.OpenVpnConfig {
+	if app.nextConfig != nil && hasCertificateIn(app.nextConfig, user) {
+		return app.nextConfig
+	}
+	return app.OpenVpnConfig
+}
+
+// revokeUnderNextCa revokes the user certificate of the new ca, if any, and publishes both crls again
+// as revoking replaced the server crl with the one of a single ca.
+func (app *App) revokeUnderNextCa(ctx context.Context, user string) error {
+	if app.nextConfig == nil {
+		return nil
+	}
+	if hasCertificateIn(app.nextConfig, user) {
+		err := app.nextConfig.DeleteUser(ctx, user)
+		if err != nil {
+			return err
+		}
+	}
+	return app.OpenVpnConfig.PublishNext(app.nextConfig)
+}
+
+func hasCertificateIn(config *openvpn.OpenVpnConfig, user string) bool {
+	for _, account := range config.CertificateInfos {
+		if account.Name == user {
+			return true
+		}
+	}
+	return false
+}
+
+// StartRotation creates the new ca next to the current one, the server does not trust it yet.
+func (app *App) StartRotation(ctx context.Context) error {
+	if app.Settings.Params.Dryrun {
+		return errors.New("ca rotation disabled by dry run")
+	}
+	if app.rotation().Active() {
+		return errors.New("a ca rotation is already in progress")
+	}
+	now := time.Now()
+	_, err := app.OpenVpnConfig.InitNextPki(ctx, fmt.Sprintf("%s-ca-%s", app.Settings.Config.Environment, now.UTC().Format("20060102")))
+	if err != nil {
+		return err
+	}
+	return app.State.Update(func(data *state.Data) error {
+		return data.StartRotation(now)
+	})
+}
+
+// PublishRotation makes the server trust both cas and sets the deadline of the old one, clients are
+// then reissued under the new ca by the update loop.
+func (app *App) PublishRotation() error {
+	if app.Settings.Params.Dryrun {
+		return errors.New("ca rotation disabled by dry run")
+	}
+	rotation := app.rotation()
+	if rotation == nil || rotation.Phase != state.RotationPrepared {
+		return errors.New("no prepared ca rotation to publish")
+	}
+	err := app.OpenVpnConfig.PublishNext(app.OpenVpnConfig.NextConfig())
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	err = app.State.Update(func(data *state.Data) error {
+		return data.PublishRotation(now, app.Settings.OpenVpn.CaRotationDays)
+	})
+	if err != nil {
+		return err
+	}
+	log.Warn().Msg("Ca bundle and crls published, restart the openvpn server to trust the new ca")
+	return nil
+}
+
+// RetireRotation retires the old ca before the deadline.
+func (app *App) RetireRotation() error {
+	if app.Settings.Params.Dryrun {
+		return errors.New("ca rotation disabled by dry run")
+	}
+	rotation := app.rotation()
+	if rotation == nil || rotation.Phase != state.RotationPublished {
+		return errors.New("no published ca rotation to retire")
+	}
+	app.userMutex.Lock()
+	defer app.userMutex.Unlock()
+	return app.retireCa()
+}
+
+// rotateCa reissues the members of the vpn group under the new ca, refresh-limit per loop, and retires
+// the old ca once the deadline passed. Users left behind are issued a new profile by the next loop.
+func (app *App) rotateCa(ctx context.Context) {
+	rotation := app.rotation()
+	if !rotation.Active() {
+		return
+	}
+	status := app.rotationStatus(rotation)
+	log.Info().Msgf("Ca rotation: %s", status)
+	if rotation.Phase != state.RotationPublished {
+		return
+	}
+	if time.Now().After(rotation.Deadline) {
+		if app.Settings.Params.Dryrun {
+			log.Info().Msgf("Dry run retiring the old ca, %d users not reissued", len(status.Pending))
+			return
+		}
+		app.userMutex.Lock()
+		err := app.retireCa()
+		app.userMutex.Unlock()
+		if err != nil {
+			log.Error().Err(err).Msg("Error retiring the old ca")
+		}
+		return
+	}
+	for i, name := range status.Pending {
+		if ctx.Err() != nil {
+			log.Info().Msg("Shutdown requested, skipping remaining ca reissues")
+			return
+		}
+		if i == app.Settings.Params.RefreshLimit {
+			log.Info().Msgf("Refresh limit reached, %d users left to reissue under the new ca", len(status.Pending)-i)
+			return
+		}
+		user, _ := app.iamUser(name)
+		stepCtx, cancel := stepContext()
+		app.reissueUnderNextCa(stepCtx, user)
+		cancel()
+	}
+}
+
+func (app *App) reissueUnderNextCa(ctx context.Context, user awssdk.User) {
+	app.userMutex.Lock()
+	defer app.userMutex.Unlock()
+	log.Info().Msgf("Reissuing user under the new ca: %s", user.Name)
+	if app.Settings.Params.Dryrun {
+		log.Info().Msgf("Dry run reissuing user under the new ca: %s", user.Name)
+		return
+	}
+	passphrase, err := app.userPassphrase(ctx, user)
+	if err != nil {
+		log.Error().Err(err).Msgf("Error reading passphrase policy: %s", user.Name)
+		return
+	}
+	profile := app.userProfile(user)
+	profile.Passphrase = passphrase
+	filePath, err := app.nextConfig.CreateUser(ctx, user.Name, profile)
+	if err != nil {
+		log.Error().Err(err).Msgf("Error reissuing openvpn client config: %s", user.Name)
+		return
+	}
+	app.recordReissued(user.Name)
+	app.recordProfile(user.Name)
+	err = app.deliverProfile(ctx, user, filePath, passphrase, true)
+	if errors.Is(err, errPassphraseUndelivered) {
+		log.Error().Err(err).Msgf("Error delivering passphrase, revoking the new certificate to reissue it at the next loop: %s", user.Name)
+		err = app.revokeUnderNextCa(ctx, user.Name)
+		if err != nil {
+			log.Error().Err(err).Msgf("Error revoking openvpn client config under the new ca: %s", user.Name)
+			return
+		}
+		app.forgetReissued(user.Name)
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Msgf("Error delivering client config: %s", user.Name)
+		return
+	}
+	log.Info().Msgf("Reissued user under the new ca successfully: %s", user.Name)
+}
+
+// recordReissued marks the user as reissued under the new ca and reads the new index.
+func (app *App) recordReissued(user string) {
+	err := app.State.Update(func(data *state.Data) error {
+		if data.Rotation != nil {
+			data.Rotation.Reissued[user] = time.Now()
+		}
+		return nil
+	})
+	if err != nil {
+		log.Error().Err(err).Msgf("Error saving ca rotation state of user: %s", user)
+	}
+	err = app.nextConfig.GetUser()
+	if err != nil {
+		log.Error().Err(err).Msg("Error getting openvpn users of the new ca")
+	}
+}
+
+// forgetReissued marks the user as pending again after its certificate under the new ca was revoked.
+func (app *App) forgetReissued(user string) {
+	err := app.State.Update(func(data *state.Data) error {
+		if data.Rotation != nil {
+			delete(data.Rotation.Reissued, user)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Error().Err(err).Msgf("Error saving ca rotation state of user: %s", user)
+	}
+	err = app.nextConfig.GetUser()
+	if err != nil {
+		log.Error().Err(err).Msg("Error getting openvpn users of the new ca")
+	}
+}
+
+// retireCa replaces the old pki with the new one. Profiles of reissued users stay valid as they
+// embed both cas, the others are forgotten so that the next loop issues them under the new ca.
+func (app *App) retireCa() error {
+	now := time.Now()
+	retired, err := app.OpenVpnConfig.RetirePki(now)
+	if err != nil {
+		return err
+	}
+	app.OpenVpnConfig = openvpn.CreateOpenVpnConfig(app.Settings.OpenVpn)
+	app.nextConfig = nil
+	fingerprints, err := app.OpenVpnConfig.Fingerprints()
+	if err != nil {
+		return err
+	}
+	err = app.State.Update(func(data *state.Data) error {
+		return data.RetireRotation(now, fingerprints)
+	})
+	if err != nil {
+		return err
+	}
+	log.Warn().Msgf("Old ca retired to %s, restart the openvpn server to load the new ca and server certificate", retired)
+	return nil
+}
+
+func (app *App) rotationStatus(rotation *state.Rotation) *RotationStatus {
+	status := &RotationStatus{Phase: rotation.Phase, Started: rotation.Started, Published: rotation.Published,
+		Deadline: rotation.Deadline, Retired: rotation.Retired, Pending: []string{}}
+	for _, user := range app.IamUsers {
+		if _, ok := rotation.Reissued[user.Name]; ok {
+			status.Reissued++
+		} else if hasCertificateIn(app.OpenVpnConfig, user.Name) {
+			status.Pending = append(status.Pending, user.Name)
+		}
+	}
+	sort.Strings(status.Pending)
+	downloaded := map[string]bool{}
+	app.State.View(func(data *state.Data) {
+		for _, download := range data.Downloads {
+			reissued, ok := rotation.Reissued[download.User]
+			if ok && !download.DownloadedAt.IsZero() && !download.Created.Before(reissued) {
+				downloaded[download.User] = true
+			}
+		}
+	})
+	status.Downloaded = len(downloaded)
+	return status
+}
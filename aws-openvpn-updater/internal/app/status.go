@@ -0,0 +1,56 @@
+package app
+
+import (
+	"context"
+	"sort"
+
+	"github.com/FinalCAD/vpn-stack/aws-openvpn-updater/internal/state"
+)
+
+// Status is served as json by the status api.
+type Status struct {
+	Environment string `json:"environment"`
+	// Members of the vpn group and valid client certificates
+	Members      int `json:"members"`
+	Certificates int `json:"certificates"`
+	// Valid client certificates per key algorithm, and the users whose key algorithm is not the configured one
+	Algorithms   map[string]int `json:"algorithms"`
+	OutdatedKeys []string       `json:"outdated-keys,omitempty"`
+	// Progress of the last ca rotation, if any
+	Rotation *RotationStatus `json:"rotation,omitempty"`
+	// Clients connected at the last status update and the connection history of every user
+	Connected   int                `json:"connected"`
+	Connections []ConnectionStatus `json:"connections,omitempty"`
+	// Users warned or revoked for dormancy
+	Dormant []state.Dormancy `json:"dormant,omitempty"`
+}
+
+// Status reports the users, their key algorithms, the progress of the last ca rotation, the connections
+// and the dormant users.
+func (app *App) Status(ctx context.Context) (interface{}, error) {
+	app.userMutex.Lock()
+	defer app.userMutex.Unlock()
+	status := &Status{
+		Environment:  app.Settings.Config.Environment,
+		Members:      len(app.IamUsers),
+		Certificates: len(app.OpenVpnConfig.CertificateInfos),
+		Algorithms:   app.keyAlgorithms(),
+		OutdatedKeys: app.outdatedKeys(),
+	}
+	if rotation := app.rotation(); rotation != nil {
+		status.Rotation = app.rotationStatus(rotation)
+	}
+	status.Connections = app.connectionStatuses()
+	for _, c := range status.Connections {
+		if c.Connected {
+			status.Connected++
+		}
+	}
+	app.State.View(func(data *state.Data) {
+		for _, record := range data.Dormant {
+			status.Dormant = append(status.Dormant, *record)
+		}
+	})
+	sort.Slice(status.Dormant, func(i, j int) bool { return status.Dormant[i].User < status.Dormant[j].User })
+	return status, nil
+}
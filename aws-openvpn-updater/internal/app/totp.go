@@ -0,0 +1,66 @@
+package app
+
+import (
+	"context"
+	"errors"
+
+	"github.com/FinalCAD/vpn-stack/aws-openvpn-updater/internal/awssdk"
+	"github.com/FinalCAD/vpn-stack/aws-openvpn-updater/internal/totp"
+	"github.com/rs/zerolog/log"
+)
+
+// enrollTotp generates the second factor secret of the user unless enrolled, and mails it. The secret
+// is deleted when the mail fails so that the user is enrolled again.
+func (app *App) enrollTotp(ctx context.Context, user awssdk.User) error {
+	if !app.Settings.Totp.Enabled || app.Totp.Has(user.Name) {
+		return nil
+	}
+	secret, err := app.Totp.Enroll(user.Name)
+	if err != nil {
+		return err
+	}
+	uri := totp.URI(app.Settings.Totp.Issuer, user.Name, secret)
+	err = app.AwsSdkConfig.SendTotpMail(ctx, app.Settings.Config.Environment, user, secret, uri, app.Settings.Params.SenderMail)
+	if err != nil {
+		// Enrolled again by the next loop
+		return errors.Join(err, app.Totp.Delete(user.Name))
+	}
+	return nil
+}
+
+// enrollMembers enrolls the members of the vpn group with a certificate and no second factor, ie when
+// totp was just enabled, at most refresh-limit per loop.
+func (app *App) enrollMembers(ctx context.Context) {
+	if !app.Settings.Totp.Enabled || app.Settings.Params.RefreshLimit == 0 {
+		return
+	}
+	enrolled := 0
+	for _, user := range app.IamUsers {
+		if !app.hasCertificate(user.Name) || app.Totp.Has(user.Name) {
+			continue
+		}
+		if ctx.Err() != nil {
+			log.Info().Msg("Shutdown requested, skipping remaining enrollments")
+			return
+		}
+		if enrolled == app.Settings.Params.RefreshLimit {
+			log.Info().Msg("Refresh limit reached, remaining users are enrolled by the next loops")
+			return
+		}
+		enrolled++
+		if app.Settings.Params.Dryrun {
+			log.Info().Msgf("Dry run enrolling second factor for user: %s", user.Name)
+			continue
+		}
+		stepCtx, cancel := stepContext()
+		app.userMutex.Lock()
+		err := app.enrollTotp(stepCtx, user)
+		app.userMutex.Unlock()
+		cancel()
+		if err != nil {
+			log.Error().Err(err).Msgf("Error enrolling second factor: %s", user.Name)
+			continue
+		}
+		log.Info().Msgf("Enrolled second factor successfully: %s", user.Name)
+	}
+}
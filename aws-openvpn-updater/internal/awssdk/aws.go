@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
 	"time"
 
@@ -20,14 +21,69 @@ import (
 	"github.com/rs/zerolog/log"
 )
 
-const sessionDuration time.Duration = time.Duration(6) * time.Hour
+const sessionDuration time.Duration = time.Duration(settings.AssumedRoleSessionTtl) * time.Second
+
+// requestTimeout bounds every single AWS api call.
+const requestTimeout time.Duration = time.Duration(30) * time.Second
 const mailTXT string = `
 VPN access :
 
 In this email you'll find a link to download your configuration file for your personal vpn acces.
 Do not share this information with anyone, including colleagues.
 Those credentials are unique to you and must not be disclosed under any circumstances.
-This link will be invalidated during the next hour
+This link will be invalidated in %s
+
+`
+const mailUpdateTXT string = `
+VPN access :
+
+The server side configuration of the vpn changed and your configuration file must be replaced.
+In this email you'll find a link to download your updated configuration file, import it in place of the previous one.
+Do not share this information with anyone, including colleagues.
+Those credentials are unique to you and must not be disclosed under any circumstances.
+This link will be invalidated in %s
+
+`
+const passphraseMailTXT string = `
+VPN access :
+
+The private key of your vpn configuration is protected by the following passphrase, your vpn client
+asks for it when connecting. The configuration file is sent in a separate email.
+Do not share this information with anyone, including colleagues, and do not store it next to the configuration file.
+
+`
+
+const totpMailTXT string = `
+VPN access :
+
+Connecting to the vpn now requires a code from an authenticator app in addition to your configuration file.
+Add the following key to your authenticator app, or scan the QR code of the html version of this email.
+Your vpn client asks for a username and a password when connecting: enter any username and the 6 digits code of the app as password.
+Do not share this information with anyone, including colleagues.
+
+`
+
+const dormantMailTXT string = `
+VPN access :
+
+You did not connect to the vpn for %d days. Your vpn access will be revoked on %s unless you connect before.
+Once revoked, ask your administrator to restore it.
+
+`
+
+const expiryMailTXT string = `
+VPN access :
+
+Your vpn access ends on %s at midnight (UTC), your configuration will be revoked then.
+Contact your administrator to extend it.
+
+`
+
+const certificateMailTXT string = `
+VPN access :
+
+The certificate of your vpn configuration expires on %s, it will stop working then.
+Contact your administrator to get a new configuration.
 
 `
 
@@ -39,23 +95,37 @@ type AwsSdkConfig struct {
 type User struct {
 	Name    string
 	Account string
+	// Email and Locale are read from the IAM user tags by the updater
+	Email  string
+	Locale string
 }
 
 func (u User) String() string {
-	return fmt.Sprintf("[ Name: %v, Account: %v ]", u.Name, u.Account)
+	return fmt.Sprintf("[ Name: %v, Account: %v, Email: %v, Locale: %v ]", u.Name, u.Account, u.Email, u.Locale)
+}
+
+// Recipient returns the email of the user read from its IAM tags by the updater.
+func (u User) Recipient() (string, error) {
+	if u.Email == "" {
+		return "", fmt.Errorf("email tag not found for IAM user: %s", u.Name)
+	}
+	return u.Email, nil
 }
 
-func CreateIAMConfig(awsconfig *settings.Aws) (*AwsSdkConfig, error) {
+func CreateIAMConfig(ctx context.Context, awsconfig *settings.Aws) (*AwsSdkConfig, error) {
 	var cfg aws.Config
 	var err error
 
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+
 	if awsconfig.Profile != "" {
 		log.Debug().Msgf("Aws config using profile: %v", awsconfig.Profile)
-		cfg, err = config.LoadDefaultConfig(context.TODO(),
+		cfg, err = config.LoadDefaultConfig(ctx,
 			config.WithSharedConfigProfile(awsconfig.Profile), config.WithRegion(awsconfig.Region))
 	} else {
 		log.Debug().Msgf("Aws config using default role: %v", awsconfig.Profile)
-		cfg, err = config.LoadDefaultConfig(context.TODO(), config.WithRegion(awsconfig.Region))
+		cfg, err = config.LoadDefaultConfig(ctx, config.WithRegion(awsconfig.Region))
 	}
 
 	if err != nil {
@@ -74,12 +144,15 @@ func CreateIAMConfig(awsconfig *settings.Aws) (*AwsSdkConfig, error) {
 	return &AwsSdkConfig{AwsConfig: awsconfig, SdkConfig: cfg}, nil
 }
 
-func (awsSdkCfg *AwsSdkConfig) GetIAMUser() ([]User, error) {
+func (awsSdkCfg *AwsSdkConfig) GetIAMUser(ctx context.Context) ([]User, error) {
 	var users []User
 	group := awsSdkCfg.AwsConfig.VpnGroup
 
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+
 	svc := iam.NewFromConfig(awsSdkCfg.SdkConfig)
-	resp, err := svc.GetGroup(context.TODO(), &iam.GetGroupInput{
+	resp, err := svc.GetGroup(ctx, &iam.GetGroupInput{
 		GroupName: &group,
 	})
 
@@ -95,7 +168,30 @@ func (awsSdkCfg *AwsSdkConfig) GetIAMUser() ([]User, error) {
 	return users, nil
 }
 
-func (awsSdkCfg *AwsSdkConfig) SaveConfS3(env string, user string, filePath string) (string, error) {
+// PresignTtl is the lifetime of the links sent to users.
+func (awsSdkCfg *AwsSdkConfig) PresignTtl() time.Duration {
+	return time.Duration(awsSdkCfg.AwsConfig.PresignTtl) * time.Second
+}
+
+// SaveConfS3 uploads the client config and returns a presigned url valid for presign-ttl.
+func (awsSdkCfg *AwsSdkConfig) SaveConfS3(ctx context.Context, env string, user string, filePath string) (string, error) {
+	key, err := awsSdkCfg.UploadConfS3(ctx, env, user, filePath)
+	if err != nil {
+		return "", err
+	}
+	url, err := awsSdkCfg.PresignS3(ctx, key, awsSdkCfg.PresignTtl())
+	if err != nil {
+		return "", err
+	}
+	log.Debug().Msgf("Presign url generated for env %s user %s: %s", env, user, url)
+	return url, nil
+}
+
+// UploadConfS3 uploads the client config and returns its key.
+func (awsSdkCfg *AwsSdkConfig) UploadConfS3(ctx context.Context, env string, user string, filePath string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+
 	s3Client := s3.NewFromConfig(awsSdkCfg.SdkConfig)
 	file, err := os.Open(filePath)
 	if err != nil {
@@ -104,36 +200,143 @@ func (awsSdkCfg *AwsSdkConfig) SaveConfS3(env string, user string, filePath stri
 	defer file.Close()
 
 	uploader := manager.NewUploader(s3Client)
-	key := fmt.Sprintf("%s/%s.ovpn", env, user)
-	_, err = uploader.Upload(context.TODO(), &s3.PutObjectInput{
-		Bucket: &awsSdkCfg.AwsConfig.BucketName,
-		Key:    &key,
-		Body:   file,
-	})
+	key := fmt.Sprintf("%s/%s%s", env, user, filepath.Ext(filePath))
+	issued := time.Now()
+	_, err = uploader.Upload(ctx, awsSdkCfg.profileObjectInput(env, user, key, filePath, file, issued, issued.Add(awsSdkCfg.PresignTtl())))
 	if err != nil {
 		return "", err
 	}
+	return key, nil
+}
+
+// PresignS3 generates a presigned url to download an object.
+func (awsSdkCfg *AwsSdkConfig) PresignS3(ctx context.Context, key string, ttl time.Duration) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
 
-	// Generate a presigned URL for the uploaded file in S3
-	presign := s3.NewPresignClient(s3Client)
-	req, err := presign.PresignGetObject(context.TODO(), &s3.GetObjectInput{
+	presign := s3.NewPresignClient(s3.NewFromConfig(awsSdkCfg.SdkConfig))
+	req, err := presign.PresignGetObject(ctx, &s3.GetObjectInput{
 		Bucket: &awsSdkCfg.AwsConfig.BucketName,
 		Key:    &key},
-		s3.WithPresignExpires(sessionDuration))
+		s3.WithPresignExpires(ttl))
 	if err != nil {
 		return "", err
 	}
-	log.Debug().Msgf("Presign url generated for env %s user %s: %s", env, user, req.URL)
-
 	return req.URL, nil
 }
 
-func (awsSdkCfg *AwsSdkConfig) SendMail(env string, user User, urlStr string, senderMail string) error {
-	subject := fmt.Sprintf("Your VPN access to %s", env)
-	recipient, _ := awsSdkCfg.GetEmail(user.Account)
-	sender := senderMail
+// SendMail sends the link to the profile of the user, qrContent is embedded as a QR code in an html
+// alternative of the text mail unless empty.
+func (awsSdkCfg *AwsSdkConfig) SendMail(ctx context.Context, env string, user User, urlStr string, qrContent string,
+	senderMail string) error {
+	m := userMessages(user)
+	err := awsSdkCfg.sendLink(ctx, user, senderMail, fmt.Sprintf(m.subject, env), m.mail, urlStr, qrContent)
+	if err != nil {
+		return err
+	}
 
-	message := mailTXT + urlStr
+	log.Debug().Msgf("Email sent with the presigned URL for env %s : %s", env, user.Name)
+	return nil
+}
+
+// SendUpdateMail sends the link to the regenerated profile of the user, like SendMail.
+func (awsSdkCfg *AwsSdkConfig) SendUpdateMail(ctx context.Context, env string, user User, urlStr string, qrContent string,
+	senderMail string) error {
+	m := userMessages(user)
+	err := awsSdkCfg.sendLink(ctx, user, senderMail, fmt.Sprintf(m.updateSubject, env), m.mailUpdate, urlStr, qrContent)
+	if err != nil {
+		return err
+	}
+
+	log.Debug().Msgf("Email sent with the updated profile URL for env %s : %s", env, user.Name)
+	return nil
+}
+
+func (awsSdkCfg *AwsSdkConfig) sendLink(ctx context.Context, user User, sender string, subject string, text string,
+	urlStr string, qrContent string) error {
+	m := userMessages(user)
+	ttl := m.formatDuration(awsSdkCfg.PresignTtl())
+	message := fmt.Sprintf(text, ttl) + urlStr
+	if qrContent == "" {
+		return awsSdkCfg.sendText(ctx, user, sender, subject, message)
+	}
+	return awsSdkCfg.sendQrMail(ctx, user, sender, subject, message, m.mailHTML,
+		map[string]string{"Url": urlStr, "Ttl": ttl}, qrContent)
+}
+
+// SendPassphraseMail sends the passphrase of the user key in its own email, apart from the profile link.
+func (awsSdkCfg *AwsSdkConfig) SendPassphraseMail(ctx context.Context, env string, user User, passphrase string, senderMail string) error {
+	m := userMessages(user)
+	err := awsSdkCfg.sendText(ctx, user, senderMail, fmt.Sprintf(m.passphraseSubject, env), m.passphrase+passphrase)
+	if err != nil {
+		return err
+	}
+
+	log.Debug().Msgf("Email sent with the passphrase for env %s : %s", env, user.Name)
+	return nil
+}
+
+// SendTotpMail sends the second factor secret of the user, with the otpauth uri as a QR code.
+func (awsSdkCfg *AwsSdkConfig) SendTotpMail(ctx context.Context, env string, user User, secret string, uri string, senderMail string) error {
+	m := userMessages(user)
+	err := awsSdkCfg.sendQrMail(ctx, user, senderMail, fmt.Sprintf(m.totpSubject, env), m.totp+secret, m.totpHTML,
+		map[string]string{"Secret": secret}, uri)
+	if err != nil {
+		return err
+	}
+
+	log.Debug().Msgf("Email sent with the second factor for env %s : %s", env, user.Name)
+	return nil
+}
+
+// SendDormantMail warns the user that its certificate is revoked at deadline without connection.
+func (awsSdkCfg *AwsSdkConfig) SendDormantMail(ctx context.Context, env string, user User, days int, deadline time.Time, senderMail string) error {
+	m := userMessages(user)
+	err := awsSdkCfg.sendText(ctx, user, senderMail, fmt.Sprintf(m.dormantSubject, env), fmt.Sprintf(m.dormant, days, deadline.Format("2006-01-02")))
+	if err != nil {
+		return err
+	}
+
+	log.Debug().Msgf("Email sent with the dormancy warning for env %s : %s", env, user.Name)
+	return nil
+}
+
+// SendExpiryMail notifies the user that its access ends at expires, midnight after its last day.
+func (awsSdkCfg *AwsSdkConfig) SendExpiryMail(ctx context.Context, env string, user User, expires time.Time, senderMail string) error {
+	m := userMessages(user)
+	lastDay := expires.AddDate(0, 0, -1).Format("2006-01-02")
+	err := awsSdkCfg.sendText(ctx, user, senderMail, fmt.Sprintf(m.expirySubject, env), fmt.Sprintf(m.expiry, lastDay))
+	if err != nil {
+		return err
+	}
+
+	log.Debug().Msgf("Email sent with the access expiry for env %s : %s", env, user.Name)
+	return nil
+}
+
+// SendCertificateExpiryMail warns the user that the certificate of its profile expires at expiry.
+func (awsSdkCfg *AwsSdkConfig) SendCertificateExpiryMail(ctx context.Context, env string, user User, expiry time.Time, senderMail string) error {
+	m := userMessages(user)
+	err := awsSdkCfg.sendText(ctx, user, senderMail, fmt.Sprintf(m.certificateSubject, env), fmt.Sprintf(m.certificate, expiry.UTC().Format("2006-01-02 15:04 MST")))
+	if err != nil {
+		return err
+	}
+
+	log.Debug().Msgf("Email sent with the certificate expiry for env %s : %s", env, user.Name)
+	return nil
+}
+
+// SendOpsMail sends a text notice to the operators.
+func (awsSdkCfg *AwsSdkConfig) SendOpsMail(ctx context.Context, recipient string, subject string, text string, senderMail string) error {
+	return awsSdkCfg.sendText(ctx, User{Email: recipient}, senderMail, subject, text)
+}
+
+func (awsSdkCfg *AwsSdkConfig) sendText(ctx context.Context, user User, sender string, subject string, message string) error {
+	recipient, err := user.Recipient()
+	if err != nil {
+		return err
+	}
+	charset := "UTF-8"
 
 	emailInput := &ses.SendEmailInput{
 		Destination: &types.Destination{
@@ -142,62 +345,115 @@ func (awsSdkCfg *AwsSdkConfig) SendMail(env string, user User, urlStr string, se
 		Message: &types.Message{
 			Body: &types.Body{
 				Text: &types.Content{
-					Data: &message,
+					Data:    &message,
+					Charset: &charset,
 				},
 			},
 			Subject: &types.Content{
-				Data: &subject,
+				Data:    &subject,
+				Charset: &charset,
 			},
 		},
 		Source: &sender,
 	}
 
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+
 	sesClient := ses.NewFromConfig(awsSdkCfg.SdkConfig)
-	_, err := sesClient.SendEmail(context.TODO(), emailInput)
-	if err != nil {
-		return err
-	}
+	_, err = sesClient.SendEmail(ctx, emailInput)
+	return err
+}
 
-	log.Debug().Msgf("Email sent with the presigned URL for env %s : %s", env, user.Name)
+// RemoveConfS3 removes the profiles of the user uploaded with any of the extensions.
+func (awsSdkCfg *AwsSdkConfig) RemoveConfS3(ctx context.Context, env string, user string, extensions []string) error {
+	for _, ext := range extensions {
+		err := awsSdkCfg.RemoveObjectS3(ctx, fmt.Sprintf("%s/%s%s", env, user, ext))
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-func (awsSdkCfg *AwsSdkConfig) RemoveConfS3(env string, user string) error {
-	key := fmt.Sprintf("%s/%s.ovpn", env, user)
+func (awsSdkCfg *AwsSdkConfig) RemoveObjectS3(ctx context.Context, key string) error {
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+
 	s3Client := s3.NewFromConfig(awsSdkCfg.SdkConfig)
 	input := &s3.DeleteObjectInput{
 		Bucket: &awsSdkCfg.AwsConfig.BucketName,
 		Key:    &key,
 	}
-	_, err := s3Client.DeleteObject(context.TODO(), input)
+	_, err := s3Client.DeleteObject(ctx, input)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func (awsSdkCfg *AwsSdkConfig) GetEmail(user string) (string, error) {
+// ListConfS3 returns the keys of every object stored under the environment prefix.
+func (awsSdkCfg *AwsSdkConfig) ListConfS3(ctx context.Context, env string) ([]string, error) {
+	var keys []string
+	prefix := env + "/"
+	s3Client := s3.NewFromConfig(awsSdkCfg.SdkConfig)
+	paginator := s3.NewListObjectsV2Paginator(s3Client, &s3.ListObjectsV2Input{
+		Bucket: &awsSdkCfg.AwsConfig.BucketName,
+		Prefix: &prefix,
+	})
+	for paginator.HasMorePages() {
+		pageCtx, cancel := context.WithTimeout(ctx, requestTimeout)
+		page, err := paginator.NextPage(pageCtx)
+		cancel()
+		if err != nil {
+			return nil, err
+		}
+		for _, object := range page.Contents {
+			keys = append(keys, *object.Key)
+		}
+	}
+	return keys, nil
+}
+
+// GetUserTags returns the tags of the IAM user by key.
+func (awsSdkCfg *AwsSdkConfig) GetUserTags(ctx context.Context, user string) (map[string]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+
 	iamClient := iam.NewFromConfig(awsSdkCfg.SdkConfig)
 	input := &iam.ListUserTagsInput{
 		UserName: &user,
 	}
 
-	result, err := iamClient.ListUserTags(context.TODO(), input)
+	result, err := iamClient.ListUserTags(ctx, input)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	var emailTagValue string
+	tags := map[string]string{}
 	for _, tag := range result.Tags {
-		if *tag.Key == "email" {
-			emailTagValue = *tag.Value
-			break
-		}
+		tags[*tag.Key] = *tag.Value
 	}
+	return tags, nil
+}
 
-	if emailTagValue == "" {
-		return "", fmt.Errorf("email tag not found for IAM user: %s", user)
+// GetUserGroups returns the names of the IAM groups the user belongs to.
+func (awsSdkCfg *AwsSdkConfig) GetUserGroups(ctx context.Context, user string) ([]string, error) {
+	var groups []string
+	iamClient := iam.NewFromConfig(awsSdkCfg.SdkConfig)
+	paginator := iam.NewListGroupsForUserPaginator(iamClient, &iam.ListGroupsForUserInput{
+		UserName: &user,
+	})
+	for paginator.HasMorePages() {
+		pageCtx, cancel := context.WithTimeout(ctx, requestTimeout)
+		page, err := paginator.NextPage(pageCtx)
+		cancel()
+		if err != nil {
+			return nil, err
+		}
+		for _, group := range page.Groups {
+			groups = append(groups, *group.GroupName)
+		}
 	}
-
-	return emailTagValue, nil
+	return groups, nil
 }
@@ -0,0 +1,119 @@
+package awssdk
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	eventWaitTime     int32         = 20
+	eventMaxMessages  int32         = 10
+	eventRetryBackoff time.Duration = time.Duration(10) * time.Second
+)
+
+// groupEvent is the part of a CloudTrail event forwarded by EventBridge used to detect group changes.
+type groupEvent struct {
+	Source string `json:"source"`
+	Detail struct {
+		EventName         string `json:"eventName"`
+		RequestParameters struct {
+			GroupName string `json:"groupName"`
+			UserName  string `json:"userName"`
+		} `json:"requestParameters"`
+	} `json:"detail"`
+}
+
+// parseGroupEvent returns the event if body is an AddUserToGroup or RemoveUserFromGroup event on group.
+func parseGroupEvent(body string, group string) (*groupEvent, error) {
+	var event groupEvent
+	if err := json.Unmarshal([]byte(body), &event); err != nil {
+		return nil, err
+	}
+	if event.Source != "aws.iam" {
+		return nil, fmt.Errorf("unexpected event source: %s", event.Source)
+	}
+	switch event.Detail.EventName {
+	case "AddUserToGroup", "RemoveUserFromGroup":
+	default:
+		return nil, nil
+	}
+	if event.Detail.RequestParameters.GroupName != group {
+		return nil, nil
+	}
+	return &event, nil
+}
+
+// WatchGroupEvents long polls the event queue and notifies wake each time a member is added to
+// or removed from the vpn group. Every received message is deleted before notifying.
+// It returns when ctx is cancelled.
+func (awsSdkCfg *AwsSdkConfig) WatchGroupEvents(ctx context.Context, wake chan<- struct{}) {
+	queueUrl := awsSdkCfg.AwsConfig.EventQueueUrl
+	group := awsSdkCfg.AwsConfig.VpnGroup
+	endpoint := awsSdkCfg.AwsConfig.SqsEndpoint
+	sqsClient := sqs.NewFromConfig(awsSdkCfg.SdkConfig, func(o *sqs.Options) {
+		if endpoint != "" {
+			o.BaseEndpoint = aws.String(endpoint)
+		}
+	})
+	log.Info().Msgf("Watching group events from queue: %s", queueUrl)
+
+	for ctx.Err() == nil {
+		err := receiveGroupEvents(ctx, sqsClient, queueUrl, group, wake)
+		if err != nil && ctx.Err() == nil {
+			log.Error().Err(err).Msg("Error receiving group events")
+			select {
+			case <-ctx.Done():
+			case <-time.After(eventRetryBackoff):
+			}
+		}
+	}
+}
+
+func receiveGroupEvents(ctx context.Context, sqsClient *sqs.Client, queueUrl string, group string, wake chan<- struct{}) error {
+	receiveCtx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+
+	resp, err := sqsClient.ReceiveMessage(receiveCtx, &sqs.ReceiveMessageInput{
+		QueueUrl:            &queueUrl,
+		MaxNumberOfMessages: eventMaxMessages,
+		WaitTimeSeconds:     eventWaitTime,
+	})
+	if err != nil {
+		return err
+	}
+
+	changed := false
+	for _, message := range resp.Messages {
+		event, err := parseGroupEvent(aws.ToString(message.Body), group)
+		if err != nil {
+			log.Warn().Err(err).Msgf("Ignoring invalid group event: %s", aws.ToString(message.MessageId))
+		} else if event != nil {
+			log.Info().Msgf("Group event received: %s %s", event.Detail.EventName, event.Detail.RequestParameters.UserName)
+			changed = true
+		}
+
+		deleteCtx, cancel := context.WithTimeout(ctx, requestTimeout)
+		_, err = sqsClient.DeleteMessage(deleteCtx, &sqs.DeleteMessageInput{
+			QueueUrl:      &queueUrl,
+			ReceiptHandle: message.ReceiptHandle,
+		})
+		cancel()
+		if err != nil {
+			return err
+		}
+	}
+
+	if changed {
+		select {
+		case wake <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package awssdk
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+const addUserEvent string = `{"source":"aws.iam","detail-type":"AWS API Call via CloudTrail",` +
+	`"detail":{"eventName":"AddUserToGroup","requestParameters":{"groupName":"tf-vpn-sandbox","userName":"john.doe"}}}`
+
+func TestParseGroupEvent(t *testing.T) {
+	tests := []struct {
+		name  string
+		body  string
+		match bool
+		err   bool
+	}{
+		{"add user", addUserEvent, true, false},
+		{"remove user", `{"source":"aws.iam","detail":{"eventName":"RemoveUserFromGroup","requestParameters":{"groupName":"tf-vpn-sandbox"}}}`, true, false},
+		{"other group", `{"source":"aws.iam","detail":{"eventName":"AddUserToGroup","requestParameters":{"groupName":"admins"}}}`, false, false},
+		{"other event", `{"source":"aws.iam","detail":{"eventName":"CreateUser","requestParameters":{"userName":"john.doe"}}}`, false, false},
+		{"other source", `{"source":"aws.s3","detail":{"eventName":"PutObject"}}`, false, true},
+		{"not json", `hello`, false, true},
+	}
+	for _, test := range tests {
+		got, err := parseGroupEvent(test.body, "tf-vpn-sandbox")
+		if (err != nil) != test.err {
+			t.Errorf("%s: got error %v, wanted error %v", test.name, err, test.err)
+		}
+		if (got != nil) != test.match {
+			t.Errorf("%s: got %v, wanted match %v", test.name, got, test.match)
+		}
+	}
+}
+
+// fakeSqsEndpoint answers the SQS query api with one message, then empty receives.
+func fakeSqsEndpoint(t *testing.T, body string) (*httptest.Server, *[]string) {
+	var mutex sync.Mutex
+	var deleted []string
+	sent := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Error(err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		mutex.Lock()
+		defer mutex.Unlock()
+		w.Header().Set("Content-Type", "text/xml")
+		switch r.Form.Get("Action") {
+		case "ReceiveMessage":
+			message := ""
+			if !sent {
+				sent = true
+				message = fmt.Sprintf("<Message><MessageId>1</MessageId><ReceiptHandle>handle-1</ReceiptHandle><Body>%s</Body></Message>",
+					html.EscapeString(body))
+			}
+			fmt.Fprintf(w, "<ReceiveMessageResponse><ReceiveMessageResult>%s</ReceiveMessageResult>"+
+				"<ResponseMetadata><RequestId>1</RequestId></ResponseMetadata></ReceiveMessageResponse>", message)
+		case "DeleteMessage":
+			deleted = append(deleted, r.Form.Get("ReceiptHandle"))
+			fmt.Fprint(w, "<DeleteMessageResponse><ResponseMetadata><RequestId>2</RequestId></ResponseMetadata></DeleteMessageResponse>")
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	return server, &deleted
+}
+
+func TestWatchGroupEvents(t *testing.T) {
+	server, deleted := fakeSqsEndpoint(t, addUserEvent)
+	defer server.Close()
+	awsSdkCfg := testSdkConfig("")
+	awsSdkCfg.AwsConfig.VpnGroup = "tf-vpn-sandbox"
+	awsSdkCfg.AwsConfig.EventQueueUrl = server.URL + "/123456789012/vpn-events"
+	awsSdkCfg.AwsConfig.SqsEndpoint = server.URL
+
+	ctx, cancel := context.WithCancel(context.Background())
+	wake := make(chan struct{}, 1)
+	done := make(chan struct{})
+	go func() {
+		awsSdkCfg.WatchGroupEvents(ctx, wake)
+		close(done)
+	}()
+
+	select {
+	case <-wake:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no wake up received")
+	}
+	cancel()
+	<-done
+	server.Close()
+	if len(*deleted) != 1 || (*deleted)[0] != "handle-1" {
+		t.Errorf("got deleted %v, wanted [handle-1]", *deleted)
+	}
+}
@@ -0,0 +1,179 @@
+package awssdk
+
+import (
+	"fmt"
+	"html/template"
+	"time"
+)
+
+// messages are the mails sent to users in one language, subjects are formatted with the environment.
+type messages struct {
+	subject            string
+	updateSubject      string
+	passphraseSubject  string
+	totpSubject        string
+	dormantSubject     string
+	expirySubject      string
+	certificateSubject string
+	mail               string
+	mailUpdate         string
+	passphrase         string
+	totp               string
+	dormant            string
+	expiry             string
+	certificate        string
+	mailHTML           *template.Template
+	totpHTML           *template.Template
+	hours              string
+	minutes            string
+}
+
+const defaultLocale string = "en"
+
+// locales are the languages of the mails by vpn-locale tag value, see policy.Locales.
+var locales = map[string]*messages{
+	"en": {
+		subject:            "Your VPN access to %s",
+		updateSubject:      "Your VPN configuration for %s was updated",
+		passphraseSubject:  "Your VPN passphrase for %s",
+		totpSubject:        "Your VPN second factor for %s",
+		dormantSubject:     "Your VPN access to %s will be revoked",
+		expirySubject:      "Your VPN access to %s ends soon",
+		certificateSubject: "Your VPN configuration for %s expires soon",
+		mail:               mailTXT,
+		mailUpdate:         mailUpdateTXT,
+		passphrase:         passphraseMailTXT,
+		totp:               totpMailTXT,
+		dormant:            dormantMailTXT,
+		expiry:             expiryMailTXT,
+		certificate:        certificateMailTXT,
+		mailHTML:           mailHTML,
+		totpHTML:           totpHTML,
+		hours:              "%d hour(s)",
+		minutes:            "%d minute(s)",
+	},
+	"fr": {
+		subject:            "Votre accès VPN à %s",
+		updateSubject:      "Votre configuration VPN pour %s a été mise à jour",
+		passphraseSubject:  "Votre phrase secrète VPN pour %s",
+		totpSubject:        "Votre second facteur VPN pour %s",
+		dormantSubject:     "Votre accès VPN à %s va être révoqué",
+		expirySubject:      "Votre accès VPN à %s se termine bientôt",
+		certificateSubject: "Votre configuration VPN pour %s expire bientôt",
+		mail:               mailFrTXT,
+		mailUpdate:         mailUpdateFrTXT,
+		passphrase:         passphraseMailFrTXT,
+		totp:               totpMailFrTXT,
+		dormant:            dormantMailFrTXT,
+		expiry:             expiryMailFrTXT,
+		certificate:        certificateMailFrTXT,
+		mailHTML:           mailFrHTML,
+		totpHTML:           totpFrHTML,
+		hours:              "%d heure(s)",
+		minutes:            "%d minute(s)",
+	},
+}
+
+const mailFrTXT string = `
+Accès VPN :
+
+Vous trouverez dans cet email un lien pour télécharger le fichier de configuration de votre accès vpn personnel.
+Ne partagez ces informations avec personne, collègues compris.
+Ces identifiants vous sont propres et ne doivent en aucun cas être divulgués.
+Ce lien expirera dans %s
+
+`
+const mailUpdateFrTXT string = `
+Accès VPN :
+
+La configuration du serveur vpn a changé et votre fichier de configuration doit être remplacé.
+Vous trouverez dans cet email un lien pour télécharger votre nouveau fichier de configuration, importez-le à la place du précédent.
+Ne partagez ces informations avec personne, collègues compris.
+Ces identifiants vous sont propres et ne doivent en aucun cas être divulgués.
+Ce lien expirera dans %s
+
+`
+const passphraseMailFrTXT string = `
+Accès VPN :
+
+La clé privée de votre configuration vpn est protégée par la phrase secrète suivante, votre client vpn
+la demande à la connexion. Le fichier de configuration est envoyé dans un email séparé.
+Ne la partagez avec personne, collègues compris, et ne la conservez pas à côté du fichier de configuration.
+
+`
+
+const totpMailFrTXT string = `
+Accès VPN :
+
+La connexion au vpn demande désormais un code d'une application d'authentification en plus de votre fichier de configuration.
+Ajoutez la clé suivante à votre application d'authentification, ou scannez le QR code de la version html de cet email.
+Votre client vpn demande un nom d'utilisateur et un mot de passe à la connexion : saisissez un nom quelconque et le code à 6 chiffres de l'application comme mot de passe.
+Ne partagez ces informations avec personne, collègues compris.
+
+`
+
+const dormantMailFrTXT string = `
+Accès VPN :
+
+Vous ne vous êtes pas connecté au vpn depuis %d jours. Votre accès vpn sera révoqué le %s si vous ne vous connectez pas d'ici là.
+Une fois révoqué, demandez à votre administrateur de le rétablir.
+
+`
+
+const expiryMailFrTXT string = `
+Accès VPN :
+
+Votre accès vpn se termine le %s au soir (UTC), votre configuration sera alors révoquée.
+Pour le prolonger, contactez votre administrateur.
+
+`
+
+const certificateMailFrTXT string = `
+Accès VPN :
+
+Le certificat de votre configuration vpn expire le %s, elle ne fonctionnera plus ensuite.
+Pour obtenir une nouvelle configuration, contactez votre administrateur.
+
+`
+
+var mailFrHTML = template.Must(template.New("mailFrHTML").Parse(`<html>
+<body>
+<p>Accès VPN :</p>
+<p>Scannez ce QR code avec votre téléphone pour télécharger le fichier de configuration de votre accès vpn personnel,
+ou <a href="{{ .Url }}">suivez ce lien</a>.</p>
+<p><img src="cid:{{ .ContentId }}" alt="QR code du profil vpn"></p>
+<p>Ne partagez ces informations avec personne, collègues compris.<br>
+Ces identifiants vous sont propres et ne doivent en aucun cas être divulgués.<br>
+Ce lien expirera dans {{ .Ttl }}</p>
+</body>
+</html>
+`))
+
+var totpFrHTML = template.Must(template.New("totpFrHTML").Parse(`<html>
+<body>
+<p>Second facteur VPN :</p>
+<p>Scannez ce QR code avec votre application d'authentification, ou saisissez la clé {{ .Secret }}.
+Votre client vpn demande un nom d'utilisateur et un mot de passe à la connexion : saisissez un nom quelconque et le code à 6 chiffres de l'application comme mot de passe.</p>
+<p><img src="cid:{{ .ContentId }}" alt="QR code du second facteur vpn"></p>
+<p>Ne partagez ces informations avec personne, collègues compris.</p>
+</body>
+</html>
+`))
+
+// userMessages returns the mails in the language of the user, english by default.
+func userMessages(user User) *messages {
+	if m, ok := locales[user.Locale]; ok {
+		return m
+	}
+	return locales[defaultLocale]
+}
+
+func (m *messages) formatDuration(d time.Duration) string {
+	switch {
+	case d >= time.Hour && d%time.Hour == 0:
+		return fmt.Sprintf(m.hours, d/time.Hour)
+	case d >= time.Minute && d%time.Minute == 0:
+		return fmt.Sprintf(m.minutes, d/time.Minute)
+	}
+	return d.String()
+}
@@ -0,0 +1,25 @@
+package awssdk
+
+import (
+	"testing"
+	"time"
+)
+
+func TestUserMessages(t *testing.T) {
+	for locale, m := range locales {
+		if m.subject == "" || m.updateSubject == "" || m.passphraseSubject == "" || m.totpSubject == "" || m.dormantSubject == "" || m.expirySubject == "" || m.certificateSubject == "" ||
+			m.mail == "" || m.mailUpdate == "" || m.passphrase == "" || m.totp == "" || m.dormant == "" || m.expiry == "" || m.certificate == "" ||
+			m.mailHTML == nil || m.totpHTML == nil {
+			t.Errorf("locale %s misses messages", locale)
+		}
+	}
+	if got := userMessages(User{Locale: "fr"}).formatDuration(2 * time.Hour); got != "2 heure(s)" {
+		t.Errorf("got %q, wanted the french duration", got)
+	}
+	if got := userMessages(User{}).formatDuration(90 * time.Second); got != "1m30s" {
+		t.Errorf("got %q, wanted the default duration", got)
+	}
+	if userMessages(User{Locale: "de"}) != locales[defaultLocale] {
+		t.Error("got another locale, wanted english for unknown locales")
+	}
+}
@@ -0,0 +1,149 @@
+package awssdk
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/textproto"
+
+	"github.com/aws/aws-sdk-go-v2/service/ses"
+	"github.com/aws/aws-sdk-go-v2/service/ses/types"
+	"github.com/skip2/go-qrcode"
+)
+
+// qrContentId references the QR code image from the html body.
+const qrContentId string = "profile-qr"
+
+// qrModuleSize is the size in pixels of a QR code module, presigned urls need large QR codes
+// and a fixed module size keeps them readable by phone cameras.
+const qrModuleSize int = -4
+
+var mailHTML = template.Must(template.New("mailHTML").Parse(`<html>
+<body>
+<p>VPN access :</p>
+<p>Scan this QR code with your phone to download your configuration file for your personal vpn access,
+or <a href="{{ .Url }}">follow this link</a>.</p>
+<p><img src="cid:{{ .ContentId }}" alt="vpn profile QR code"></p>
+<p>Do not share this information with anyone, including colleagues.<br>
+Those credentials are unique to you and must not be disclosed under any circumstances.<br>
+This link will be invalidated in {{ .Ttl }}</p>
+</body>
+</html>
+`))
+
+var totpHTML = template.Must(template.New("totpHTML").Parse(`<html>
+<body>
+<p>VPN second factor :</p>
+<p>Scan this QR code with your authenticator app, or enter the key {{ .Secret }}.
+Your vpn client asks for a username and a password when connecting: enter any username and the 6 digits code of the app as password.</p>
+<p><img src="cid:{{ .ContentId }}" alt="vpn second factor QR code"></p>
+<p>Do not share this information with anyone, including colleagues.</p>
+</body>
+</html>
+`))
+
+// sendQrMail sends the text message along with an html alternative, rendered from htmlTemplate with
+// data and ContentId, embedding qrContent as a QR code.
+func (awsSdkCfg *AwsSdkConfig) sendQrMail(ctx context.Context, user User, sender string, subject string, text string,
+	htmlTemplate *template.Template, data map[string]string, qrContent string) error {
+	recipient, err := user.Recipient()
+	if err != nil {
+		return err
+	}
+
+	png, err := qrcode.Encode(qrContent, qrcode.Low, qrModuleSize)
+	if err != nil {
+		return err
+	}
+	var html bytes.Buffer
+	data["ContentId"] = qrContentId
+	err = htmlTemplate.Execute(&html, data)
+	if err != nil {
+		return err
+	}
+	raw, err := buildQrMail(sender, recipient, subject, text, html.String(), png)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+
+	sesClient := ses.NewFromConfig(awsSdkCfg.SdkConfig)
+	_, err = sesClient.SendRawEmail(ctx, &ses.SendRawEmailInput{RawMessage: &types.RawMessage{Data: raw}})
+	return err
+}
+
+// buildQrMail renders a multipart/related message: the text and html alternatives, and the png
+// referenced by the html body through its content id.
+func buildQrMail(sender string, recipient string, subject string, text string, html string, png []byte) ([]byte, error) {
+	var bodies bytes.Buffer
+	alternative := multipart.NewWriter(&bodies)
+	for _, body := range []struct{ contentType, content string }{{"text/plain", text}, {"text/html", html}} {
+		part, err := alternative.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {body.contentType + "; charset=utf-8"},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		w := quotedprintable.NewWriter(part)
+		_, err = w.Write([]byte(body.content))
+		if err != nil {
+			return nil, err
+		}
+		err = w.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	err := alternative.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	var message bytes.Buffer
+	related := multipart.NewWriter(&message)
+	fmt.Fprintf(&message, "From: %s\r\n", sender)
+	fmt.Fprintf(&message, "To: %s\r\n", recipient)
+	fmt.Fprintf(&message, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&message, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&message, "Content-Type: multipart/related; boundary=%s\r\n\r\n", related.Boundary())
+
+	part, err := related.CreatePart(textproto.MIMEHeader{
+		"Content-Type": {"multipart/alternative; boundary=" + alternative.Boundary()},
+	})
+	if err != nil {
+		return nil, err
+	}
+	_, err = part.Write(bodies.Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	image, err := related.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {"image/png"},
+		"Content-Transfer-Encoding": {"base64"},
+		"Content-Id":                {"<" + qrContentId + ">"},
+		"Content-Disposition":       {"inline; filename=\"vpn-profile.png\""},
+	})
+	if err != nil {
+		return nil, err
+	}
+	encoded := base64.StdEncoding.EncodeToString(png)
+	for len(encoded) > 76 {
+		fmt.Fprintf(image, "%s\r\n", encoded[:76])
+		encoded = encoded[76:]
+	}
+	fmt.Fprintf(image, "%s\r\n", encoded)
+	err = related.Close()
+	if err != nil {
+		return nil, err
+	}
+	return message.Bytes(), nil
+}
@@ -0,0 +1,89 @@
+package awssdk
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image/png"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"strings"
+	"testing"
+
+	"github.com/skip2/go-qrcode"
+)
+
+func TestBuildQrMail(t *testing.T) {
+	url := "https://bucket.s3.amazonaws.com/sandbox/johndoe.ovpn?X-Amz-Signature=" + strings.Repeat("a", 900)
+	qr, err := qrcode.Encode("openvpn://import-profile/"+url, qrcode.Low, qrModuleSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	raw, err := buildQrMail("vpn@example.com", "john.doe@example.com", "Your VPN access to sandbox", "text "+url, "<html></html>", qr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	message, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if message.Header.Get("To") != "john.doe@example.com" {
+		t.Errorf("got recipient %q", message.Header.Get("To"))
+	}
+	mediaType, params, err := mime.ParseMediaType(message.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/related" {
+		t.Fatalf("got %s %v, wanted multipart/related", mediaType, err)
+	}
+	related := multipart.NewReader(message.Body, params["boundary"])
+
+	part, err := related.NextPart()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mediaType, altParams, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+	if mediaType != "multipart/alternative" {
+		t.Fatalf("got first part %s, wanted multipart/alternative", mediaType)
+	}
+	alternative := multipart.NewReader(part, altParams["boundary"])
+	var types []string
+	for {
+		body, err := alternative.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, _ := io.ReadAll(body)
+		if strings.HasPrefix(body.Header.Get("Content-Type"), "text/plain") && string(content) != "text "+url {
+			t.Errorf("got text body %q", content)
+		}
+		types = append(types, strings.Split(body.Header.Get("Content-Type"), ";")[0])
+	}
+	if strings.Join(types, ",") != "text/plain,text/html" {
+		t.Errorf("got alternatives %v, wanted text/plain and text/html", types)
+	}
+
+	image, err := related.NextPart()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if image.Header.Get("Content-Id") != "<"+qrContentId+">" {
+		t.Errorf("got content id %q", image.Header.Get("Content-Id"))
+	}
+	if _, err = png.Decode(base64.NewDecoder(base64.StdEncoding, image)); err != nil {
+		t.Errorf("got %v, wanted a png QR code", err)
+	}
+}
+
+func TestUserRecipient(t *testing.T) {
+	if got, err := (User{Name: "john", Email: "john.doe@example.com"}).Recipient(); err != nil || got != "john.doe@example.com" {
+		t.Errorf("got %q %v, wanted the cached email", got, err)
+	}
+	if _, err := (User{Name: "jane"}).Recipient(); err == nil {
+		t.Error("got no error, wanted one without email tag")
+	}
+}
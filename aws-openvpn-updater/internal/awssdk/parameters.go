@@ -0,0 +1,126 @@
+package awssdk
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/FinalCAD/vpn-stack/aws-openvpn-updater/internal/settings"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/rs/zerolog/log"
+)
+
+type cachedParameter struct {
+	value   string
+	expires time.Time
+}
+
+// ParameterResolver resolves ssm: and secretsmanager: references, caching values for the configured ttl.
+type ParameterResolver struct {
+	ssmClient     *ssm.Client
+	secretsClient *secretsmanager.Client
+	ttl           time.Duration
+	mutex         sync.Mutex
+	cache         map[string]cachedParameter
+}
+
+func (awsSdkCfg *AwsSdkConfig) CreateParameterResolver() *ParameterResolver {
+	endpoint := awsSdkCfg.AwsConfig.ParameterEndpoint
+	return &ParameterResolver{
+		ssmClient: ssm.NewFromConfig(awsSdkCfg.SdkConfig, func(o *ssm.Options) {
+			if endpoint != "" {
+				o.BaseEndpoint = aws.String(endpoint)
+			}
+		}),
+		secretsClient: secretsmanager.NewFromConfig(awsSdkCfg.SdkConfig, func(o *secretsmanager.Options) {
+			if endpoint != "" {
+				o.BaseEndpoint = aws.String(endpoint)
+			}
+		}),
+		ttl:   time.Duration(awsSdkCfg.AwsConfig.ParameterCacheTtl) * time.Second,
+		cache: map[string]cachedParameter{},
+	}
+}
+
+// Resolve returns the value of a reference written as ssm:/path/to/param, secretsmanager:secret-id
+// or secretsmanager:secret-id#json-key.
+func (r *ParameterResolver) Resolve(ctx context.Context, reference string) (string, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if cached, ok := r.cache[reference]; ok && time.Now().Before(cached.expires) {
+		return cached.value, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+
+	var value string
+	var err error
+	switch {
+	case strings.HasPrefix(reference, settings.SsmPrefix):
+		value, err = r.getParameter(ctx, strings.TrimPrefix(reference, settings.SsmPrefix))
+	case strings.HasPrefix(reference, settings.SecretsManagerPrefix):
+		value, err = r.getSecret(ctx, strings.TrimPrefix(reference, settings.SecretsManagerPrefix))
+	default:
+		err = fmt.Errorf("unsupported reference: %s", reference)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	log.Debug().Msgf("Reference resolved: %s", reference)
+	r.cache[reference] = cachedParameter{value: value, expires: time.Now().Add(r.ttl)}
+	return value, nil
+}
+
+// Flush empties the cache so the next resolutions fetch fresh values.
+func (r *ParameterResolver) Flush() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.cache = map[string]cachedParameter{}
+}
+
+func (r *ParameterResolver) getParameter(ctx context.Context, name string) (string, error) {
+	resp, err := r.ssmClient.GetParameter(ctx, &ssm.GetParameterInput{
+		Name:           &name,
+		WithDecryption: aws.Bool(true),
+	})
+	if err != nil {
+		return "", err
+	}
+	if resp.Parameter == nil || resp.Parameter.Value == nil {
+		return "", fmt.Errorf("parameter %s has no value", name)
+	}
+	return *resp.Parameter.Value, nil
+}
+
+func (r *ParameterResolver) getSecret(ctx context.Context, id string) (string, error) {
+	id, jsonKey, hasKey := strings.Cut(id, "#")
+	resp, err := r.secretsClient.GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{
+		SecretId: &id,
+	})
+	if err != nil {
+		return "", err
+	}
+	if resp.SecretString == nil {
+		return "", fmt.Errorf("secret %s has no string value", id)
+	}
+	if !hasKey {
+		return *resp.SecretString, nil
+	}
+
+	var content map[string]interface{}
+	if err = json.Unmarshal([]byte(*resp.SecretString), &content); err != nil {
+		return "", fmt.Errorf("secret %s is not a json object: %w", id, err)
+	}
+	value, ok := content[jsonKey]
+	if !ok {
+		return "", fmt.Errorf("key %s not found in secret %s", jsonKey, id)
+	}
+	return fmt.Sprintf("%v", value), nil
+}
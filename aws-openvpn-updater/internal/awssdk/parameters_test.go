@@ -0,0 +1,94 @@
+package awssdk
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/FinalCAD/vpn-stack/aws-openvpn-updater/internal/settings"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+)
+
+// fakeParameterEndpoint answers the SSM GetParameter and Secrets Manager GetSecretValue json apis.
+func fakeParameterEndpoint(t *testing.T, calls *int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*calls++
+		var input map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+			t.Error(err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+		switch r.Header.Get("X-Amz-Target") {
+		case "AmazonSSM.GetParameter":
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"Parameter": map[string]interface{}{"Name": input["Name"], "Type": "String", "Value": "vpn@example.com"},
+			})
+		case "secretsmanager.GetSecretValue":
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"Name": input["SecretId"], "SecretString": `{"webhook":"https://hooks.example.com/x"}`,
+			})
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+}
+
+func testSdkConfig(endpoint string) *AwsSdkConfig {
+	return &AwsSdkConfig{
+		AwsConfig: &settings.Aws{Region: "eu-central-1", ParameterEndpoint: endpoint, ParameterCacheTtl: 300},
+		SdkConfig: aws.Config{
+			Region:      "eu-central-1",
+			Credentials: credentials.NewStaticCredentialsProvider("key", "secret", ""),
+		},
+	}
+}
+
+func TestParameterResolver(t *testing.T) {
+	var calls int
+	server := fakeParameterEndpoint(t, &calls)
+	defer server.Close()
+	resolver := testSdkConfig(server.URL).CreateParameterResolver()
+
+	tests := map[string]string{
+		"ssm:/vpn/sandbox/sender":            "vpn@example.com",
+		"secretsmanager:vpn/sandbox#webhook": "https://hooks.example.com/x",
+	}
+	for reference, want := range tests {
+		got, err := resolver.Resolve(context.Background(), reference)
+		if err != nil {
+			t.Fatalf("%s: %v", reference, err)
+		}
+		if got != want {
+			t.Errorf("%s: got %q, wanted %q", reference, got, want)
+		}
+	}
+}
+
+func TestParameterResolverCache(t *testing.T) {
+	var calls int
+	server := fakeParameterEndpoint(t, &calls)
+	defer server.Close()
+	resolver := testSdkConfig(server.URL).CreateParameterResolver()
+
+	for i := 0; i < 3; i++ {
+		if _, err := resolver.Resolve(context.Background(), "ssm:/vpn/sandbox/sender"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("got %d calls, wanted 1 cached call", calls)
+	}
+
+	resolver.Flush()
+	if _, err := resolver.Resolve(context.Background(), "ssm:/vpn/sandbox/sender"); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 2 {
+		t.Errorf("got %d calls, wanted a fresh call after flush", calls)
+	}
+}
@@ -0,0 +1,58 @@
+package awssdk
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"path/filepath"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+var contentTypes = map[string]string{
+	".ovpn": "application/x-openvpn-profile",
+	".p12":  "application/x-pkcs12",
+	".zip":  "application/zip",
+	".png":  "image/png",
+}
+
+func contentType(filePath string) string {
+	if contentType, ok := contentTypes[filepath.Ext(filePath)]; ok {
+		return contentType
+	}
+	return "application/octet-stream"
+}
+
+// profileObjectInput describes the upload of a client profile: encrypted with the configured kms key
+// (S3 managed key otherwise), tagged with environment, user, issuance and expiry dates, and downloaded
+// under an explicit filename. Expires and the expires tag match the presigned url lifetime.
+func (awsSdkCfg *AwsSdkConfig) profileObjectInput(env string, user string, key string, filePath string, body io.Reader,
+	issued time.Time, expires time.Time) *s3.PutObjectInput {
+	tags := url.Values{}
+	tags.Set("environment", env)
+	tags.Set("user", user)
+	tags.Set("issued", issued.UTC().Format(time.DateOnly))
+	tags.Set("expires", expires.UTC().Format(time.RFC3339))
+
+	input := &s3.PutObjectInput{
+		Bucket:             &awsSdkCfg.AwsConfig.BucketName,
+		Key:                &key,
+		Body:               body,
+		ContentType:        aws.String(contentType(filePath)),
+		ContentDisposition: aws.String(fmt.Sprintf("attachment; filename=\"%s-%s%s\"", env, user, filepath.Ext(filePath))),
+		Expires:            aws.Time(expires),
+		Tagging:            aws.String(tags.Encode()),
+		Metadata:           map[string]string{"environment": env, "user": user},
+	}
+	if awsSdkCfg.AwsConfig.KmsKeyId != "" {
+		input.ServerSideEncryption = types.ServerSideEncryptionAwsKms
+		input.SSEKMSKeyId = aws.String(awsSdkCfg.AwsConfig.KmsKeyId)
+		input.BucketKeyEnabled = true
+	} else {
+		input.ServerSideEncryption = types.ServerSideEncryptionAes256
+	}
+	return input
+}
@@ -0,0 +1,54 @@
+package awssdk
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+func TestProfileObjectInput(t *testing.T) {
+	awsSdkCfg := testSdkConfig("")
+	issued := time.Date(2026, 10, 19, 8, 0, 0, 0, time.UTC)
+	expires := issued.Add(6 * time.Hour)
+
+	input := awsSdkCfg.profileObjectInput("sandbox", "johndoe", "sandbox/johndoe.ovpn", "/tmp/johndoe.ovpn", strings.NewReader(""), issued, expires)
+
+	if input.ServerSideEncryption != types.ServerSideEncryptionAes256 || input.SSEKMSKeyId != nil {
+		t.Errorf("got %s with key %v, wanted AES256 without kms key", input.ServerSideEncryption, input.SSEKMSKeyId)
+	}
+	if got := aws.ToString(input.ContentType); got != "application/x-openvpn-profile" {
+		t.Errorf("got content type %s, wanted application/x-openvpn-profile", got)
+	}
+	if got := aws.ToString(input.ContentDisposition); got != `attachment; filename="sandbox-johndoe.ovpn"` {
+		t.Errorf("got content disposition %s", got)
+	}
+	if !aws.ToTime(input.Expires).Equal(expires) {
+		t.Errorf("got expires %v, wanted %v", input.Expires, expires)
+	}
+	tags, err := url.ParseQuery(aws.ToString(input.Tagging))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tags.Get("environment") != "sandbox" || tags.Get("user") != "johndoe" || tags.Get("issued") != "2026-10-19" ||
+		tags.Get("expires") != "2026-10-19T14:00:00Z" {
+		t.Errorf("got tags %v", tags)
+	}
+}
+
+func TestProfileObjectInputKms(t *testing.T) {
+	awsSdkCfg := testSdkConfig("")
+	awsSdkCfg.AwsConfig.KmsKeyId = "alias/vpn"
+
+	input := awsSdkCfg.profileObjectInput("sandbox", "johndoe", "sandbox/johndoe.p12", "/tmp/johndoe.p12", strings.NewReader(""), time.Now(), time.Now())
+
+	if input.ServerSideEncryption != types.ServerSideEncryptionAwsKms || aws.ToString(input.SSEKMSKeyId) != "alias/vpn" {
+		t.Errorf("got %s with key %v, wanted aws:kms with alias/vpn", input.ServerSideEncryption, input.SSEKMSKeyId)
+	}
+	if got := aws.ToString(input.ContentType); got != "application/x-pkcs12" {
+		t.Errorf("got content type %s, wanted application/x-pkcs12", got)
+	}
+}
@@ -8,14 +8,30 @@ import (
 	"github.com/rs/zerolog/log"
 )
 
+const (
+	CommandRun       string = "run"
+	CommandValidate  string = "validate"
+	CommandConfig    string = "config"
+	CommandInventory string = "inventory"
+	CommandDownloads string = "downloads"
+	CommandPlan      string = "plan"
+	CommandRotation  string = "ca-rotation"
+	CommandVerify    string = "verify"
+	CommandConnect   string = "client-connect"
+	CommandHistory   string = "connections"
+	CommandTlsVerify string = "tls-crypt-v2-verify"
+)
+
 type Config struct {
 	Debug       bool
 	ConfigFile  string
 	Environment string
+	Command     string
+	Args        []string
 }
 
 func (c Config) String() string {
-	return fmt.Sprintf("[ Debug: %v, ConfigFile: %v, Environment: %v ]", c.Debug, c.ConfigFile, c.Environment)
+	return fmt.Sprintf("[ Debug: %v, ConfigFile: %v, Environment: %v, Command: %v ]", c.Debug, c.ConfigFile, c.Environment, c.Command)
 }
 
 func InitApp() *Config {
@@ -24,7 +40,11 @@ func InitApp() *Config {
 	environment := flag.String("env", "", "environment")
 	configFile := flag.String("config", "config.toml", "toml configuration file")
 	flag.Parse()
-	cfg := &Config{Debug: *debug, ConfigFile: *configFile, Environment: *environment}
+	cfg := &Config{Debug: *debug, ConfigFile: *configFile, Environment: *environment, Command: CommandRun}
+	if flag.NArg() > 0 {
+		cfg.Command = flag.Arg(0)
+		cfg.Args = flag.Args()[1:]
+	}
 	// Logger
 	zerolog.TimeFieldFormat = zerolog.TimeFormatUnix
 	zerolog.SetGlobalLevel(zerolog.InfoLevel)
@@ -0,0 +1,117 @@
+package membership
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Members is the desired set of users cached by the daemon for the client-connect hook.
+type Members struct {
+	Updated time.Time `json:"updated"`
+	// Seen is the last lookup each member of the vpn group was seen in
+	Seen map[string]time.Time `json:"seen"`
+}
+
+func (m Members) String() string {
+	return fmt.Sprintf("[ Updated: %s, Members: %d ]", m.Updated, len(m.Seen))
+}
+
+// Write replaces the cache with the members of the vpn group and the lookup each was last seen in,
+// updated at now. The file is readable by the user running the openvpn scripts.
+func Write(path string, seen map[string]time.Time, now time.Time) error {
+	members := Members{Updated: now, Seen: seen}
+	content, err := json.MarshalIndent(members, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	err = os.WriteFile(tmp, content, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Read loads the cache written by the daemon.
+func Read(path string) (*Members, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	members := &Members{}
+	err = json.Unmarshal(content, members)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return members, nil
+}
+
+// Reasons of a decision.
+const (
+	ReasonMember  string = "member"
+	ReasonMissing string = "not a member"
+	ReasonStale   string = "stale membership"
+	ReasonNoCache string = "no membership cache"
+)
+
+// Decision is the outcome of a connection check, appended to the audit log.
+type Decision struct {
+	Time    time.Time `json:"time"`
+	User    string    `json:"user"`
+	Address string    `json:"address,omitempty"`
+	Allowed bool      `json:"allowed"`
+	Reason  string    `json:"reason"`
+	// Seen is when the user was last seen in the vpn group
+	Seen time.Time `json:"seen,omitempty"`
+}
+
+func (d Decision) String() string {
+	return fmt.Sprintf("[ User: %s, Address: %s, Allowed: %v, Reason: %s ]", d.User, d.Address, d.Allowed, d.Reason)
+}
+
+// Check allows the user when the cache saw it in the vpn group less than maxAge before now.
+func Check(members *Members, user string, now time.Time, maxAge time.Duration) Decision {
+	decision := Decision{Time: now, User: user}
+	if members == nil {
+		decision.Reason = ReasonNoCache
+		return decision
+	}
+	seen, ok := members.Seen[user]
+	if !ok {
+		decision.Reason = ReasonMissing
+		return decision
+	}
+	decision.Seen = seen
+	if now.Sub(seen) > maxAge {
+		decision.Reason = ReasonStale
+		return decision
+	}
+	decision.Allowed = true
+	decision.Reason = ReasonMember
+	return decision
+}
+
+// Audit appends the decision as a json line. The directory of path is not created, the openvpn
+// scripts run unprivileged.
+func Audit(path string, decision Decision) error {
+	line, err := json.Marshal(decision)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0640)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(append(line, '\n'))
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,65 @@
+package membership
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheck(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "members.json")
+	updated := time.Date(2026, 10, 19, 8, 0, 0, 0, time.UTC)
+	seen := map[string]time.Time{"john": updated, "jack": updated.Add(-20 * time.Minute)}
+	if err := Write(path, seen, updated); err != nil {
+		t.Fatal(err)
+	}
+	members, err := Read(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		user    string
+		now     time.Time
+		allowed bool
+		reason  string
+	}{
+		{"john", updated.Add(time.Minute), true, ReasonMember},
+		{"jane", updated.Add(time.Minute), false, ReasonMissing},
+		{"john", updated.Add(time.Hour), false, ReasonStale},
+		{"jack", updated.Add(time.Minute), false, ReasonStale},
+	}
+	for _, tt := range tests {
+		got := Check(members, tt.user, tt.now, 15*time.Minute)
+		if got.Allowed != tt.allowed || got.Reason != tt.reason {
+			t.Errorf("got %v for %s, wanted allowed %v because %s", got, tt.user, tt.allowed, tt.reason)
+		}
+	}
+	if got := Check(nil, "john", updated, time.Hour); got.Allowed || got.Reason != ReasonNoCache {
+		t.Errorf("got %v, wanted refused without cache", got)
+	}
+}
+
+func TestAudit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "connect.log")
+	for _, user := range []string{"john", "jane"} {
+		if err := Audit(path, Decision{User: user, Address: "192.0.2.1", Reason: ReasonMissing}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %q, wanted one line per decision", content)
+	}
+	var decision Decision
+	if err = json.Unmarshal([]byte(lines[1]), &decision); err != nil || decision.User != "jane" {
+		t.Errorf("got %v %v, wanted the second decision", decision, err)
+	}
+}
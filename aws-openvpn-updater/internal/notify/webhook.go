@@ -0,0 +1,55 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// requestTimeout bounds every single webhook call.
+const requestTimeout time.Duration = time.Duration(30) * time.Second
+
+// Message is posted as json to the webhook, the chat side uses Email to find whom to send Text to.
+type Message struct {
+	Email string `json:"email"`
+	User  string `json:"user"`
+	Text  string `json:"text"`
+}
+
+func (m Message) String() string {
+	return fmt.Sprintf("[ Email: %v, User: %v ]", m.Email, m.User)
+}
+
+// Webhook posts messages to a chat integration, ie a Slack workflow sending a direct message.
+type Webhook struct {
+	Url    string
+	client *http.Client
+}
+
+func CreateWebhook(url string) *Webhook {
+	return &Webhook{Url: url, client: &http.Client{Timeout: requestTimeout}}
+}
+
+func (w *Webhook) Send(ctx context.Context, message Message) error {
+	body, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.Url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook answered %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWebhookSend(t *testing.T) {
+	var received Message
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&received)
+	}))
+	defer server.Close()
+
+	message := Message{Email: "john.doe@example.com", User: "johndoe", Text: "hello"}
+	err := CreateWebhook(server.URL).Send(context.Background(), message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if received != message {
+		t.Errorf("got %+v, wanted %+v", received, message)
+	}
+}
+
+func TestWebhookSendError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	err := CreateWebhook(server.URL).Send(context.Background(), Message{})
+	if err == nil {
+		t.Error("got no error, wanted the webhook status")
+	}
+}
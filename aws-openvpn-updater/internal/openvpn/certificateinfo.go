@@ -2,7 +2,7 @@ package openvpn
 
 import (
 	"fmt"
-	"strings"
+	"time"
 )
 
 type CertificateInfo struct {
@@ -10,28 +10,40 @@ type CertificateInfo struct {
 	Date  string
 	Hash  string
 	Name  string
+	// Issue date and key and signature algorithms of the issued certificate
+	Issued    time.Time
+	Algorithm string
+	Signature string
 }
 
 func (c CertificateInfo) String() string {
-	return fmt.Sprintf("[ Name: %s ]", c.Name)
+	return fmt.Sprintf("[ Name: %s, Algorithm: %s ]", c.Name, c.Algorithm)
 }
 
+// Expiry returns the expiry date of the certificate read from index.txt.
+func (c CertificateInfo) Expiry() (time.Time, error) {
+	return parseIndexTime(c.Date)
+}
+
+// CreateCertificateInfo returns the client certificate of a valid index.txt line, nil otherwise.
 func CreateCertificateInfo(line string) *CertificateInfo {
-	fields := strings.Fields(line)
-	if len(fields) != 5 {
+	entry, err := ParseIndexLine(line)
+	if err != nil {
 		return nil
 	}
+	return createCertificateInfoFromEntry(*entry)
+}
 
-	name := strings.TrimPrefix(fields[4], "/CN=")
-
-	if name == "server" || name == "client" || fields[0] != "V" {
+func createCertificateInfoFromEntry(entry IndexEntry) *CertificateInfo {
+	name := entry.CommonName
+	if name == "server" || name == "client" || entry.State != StateValid {
 		return nil
 	}
 
 	return &CertificateInfo{
-		State: fields[0],
-		Date:  fields[1],
-		Hash:  fields[2],
+		State: entry.State,
+		Date:  formatIndexTime(entry.Expiry),
+		Hash:  entry.Serial,
 		Name:  name,
 	}
 }
@@ -3,6 +3,7 @@ package openvpn
 import (
 	"reflect"
 	"testing"
+	"time"
 )
 
 func TestCreateCertificateInfo(t *testing.T) {
@@ -19,6 +20,10 @@ func TestCreateCertificateInfo(t *testing.T) {
 	if !reflect.DeepEqual(got, want) {
 		t.Errorf("got %q, wanted %q", got, want)
 	}
+	expiry, err := got.Expiry()
+	if err != nil || !expiry.Equal(time.Date(2033, 7, 29, 11, 18, 15, 0, time.UTC)) {
+		t.Errorf("got expiry %s %v, wanted 2033-07-29 11:18:15", expiry, err)
+	}
 }
 
 func TestCreateCertificateInfoServer(t *testing.T) {
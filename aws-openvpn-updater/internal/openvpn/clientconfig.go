@@ -0,0 +1,85 @@
+package openvpn
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// clientConfigHeader marks the files of the client-config-dir written by the updater, others are left alone.
+const clientConfigHeader string = "# Written by aws-openvpn-updater from the IAM tags of the user, do not edit\n"
+
+// ErrUnmanagedClientConfig is returned for client-config-dir files not written by the updater.
+var ErrUnmanagedClientConfig = errors.New("client config not written by the updater")
+
+// ClientConfig renders the client-config-dir file of a user, the static address is pushed for topology subnet.
+func ClientConfig(routes []netip.Prefix, staticIp netip.Prefix) string {
+	var content strings.Builder
+	content.WriteString(clientConfigHeader)
+	if staticIp.IsValid() {
+		fmt.Fprintf(&content, "ifconfig-push %s %s\n", staticIp.Addr(), netmask(staticIp))
+	}
+	for _, route := range routes {
+		fmt.Fprintf(&content, "push \"route %s %s\"\n", route.Addr(), netmask(route))
+	}
+	return content.String()
+}
+
+func netmask(prefix netip.Prefix) string {
+	return net.IP(net.CIDRMask(prefix.Bits(), 32)).String()
+}
+
+// WriteClientConfig writes the client-config-dir file of the user and returns whether it changed.
+func WriteClientConfig(dir string, user string, content string) (bool, error) {
+	path := filepath.Join(dir, user)
+	current, err := os.ReadFile(path)
+	switch {
+	case err == nil && string(current) == content:
+		return false, nil
+	case err == nil && !strings.HasPrefix(string(current), clientConfigHeader):
+		return false, fmt.Errorf("%s: %w", path, ErrUnmanagedClientConfig)
+	case err != nil && !os.IsNotExist(err):
+		return false, err
+	}
+	tmp := path + ".tmp"
+	err = os.WriteFile(tmp, []byte(content), 0644)
+	if err != nil {
+		return false, err
+	}
+	return true, os.Rename(tmp, path)
+}
+
+// ClientConfigs returns the users with a client-config-dir file written by the updater.
+func ClientConfigs(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var users []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		content, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		if strings.HasPrefix(string(content), clientConfigHeader) {
+			users = append(users, entry.Name())
+		}
+	}
+	return users, nil
+}
+
+// RemoveClientConfig removes the client-config-dir file of the user.
+func RemoveClientConfig(dir string, user string) error {
+	err := os.Remove(filepath.Join(dir, user))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
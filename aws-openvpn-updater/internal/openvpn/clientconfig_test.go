@@ -0,0 +1,44 @@
+package openvpn
+
+import (
+	"errors"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestClientConfig(t *testing.T) {
+	got := ClientConfig([]netip.Prefix{netip.MustParsePrefix("10.0.0.0/16")}, netip.MustParsePrefix("10.8.0.50/24"))
+	want := clientConfigHeader + "ifconfig-push 10.8.0.50 255.255.255.0\npush \"route 10.0.0.0 255.255.0.0\"\n"
+	if got != want {
+		t.Errorf("got %q, wanted %q", got, want)
+	}
+}
+
+func TestWriteClientConfig(t *testing.T) {
+	dir := t.TempDir()
+	content := ClientConfig([]netip.Prefix{netip.MustParsePrefix("10.0.0.0/16")}, netip.Prefix{})
+	if changed, err := WriteClientConfig(dir, "john", content); err != nil || !changed {
+		t.Fatalf("got changed %v %v, wanted the file written", changed, err)
+	}
+	if changed, err := WriteClientConfig(dir, "john", content); err != nil || changed {
+		t.Errorf("got changed %v %v, wanted the file unchanged", changed, err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "jane"), []byte("ifconfig-push 10.8.0.9 255.255.255.0\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := WriteClientConfig(dir, "jane", content); !errors.Is(err, ErrUnmanagedClientConfig) {
+		t.Errorf("got %v, wanted the file of the administrator kept", err)
+	}
+	users, err := ClientConfigs(dir)
+	if err != nil || len(users) != 1 || users[0] != "john" {
+		t.Errorf("got %v %v, wanted only the managed file", users, err)
+	}
+	if err = RemoveClientConfig(dir, "john"); err != nil {
+		t.Fatal(err)
+	}
+	if err = RemoveClientConfig(dir, "john"); err != nil {
+		t.Errorf("got %v, wanted removing a missing file to succeed", err)
+	}
+}
@@ -7,11 +7,13 @@ import (
 )
 
 type ConfigUser struct {
+	Name           string
 	ClientCommon   string
 	ClientCa       string
 	ClientCert     string
 	ClientKey      string
 	ClientTlsCrypt string
+	TlsCryptV2     bool
 }
 
 func (c ConfigUser) String() string {
@@ -22,7 +24,7 @@ func (c ConfigUser) String() string {
 func CreateConfigUser(user string, clientCommonPath string, caPath string, easyRsaKeyDirectoryPath string,
 	clientTlsCryptPath string) (*ConfigUser, error) {
 	var err error
-	configUser := &ConfigUser{}
+	configUser := &ConfigUser{Name: user}
 	configUser.ClientCommon, err = utils.ReadContentFromFile(clientCommonPath)
 	if err != nil {
 		return nil, err
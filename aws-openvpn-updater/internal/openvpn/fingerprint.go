@@ -0,0 +1,55 @@
+package openvpn
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"sort"
+)
+
+// Server side inputs of every client profile.
+const (
+	FingerprintCa           string = "ca"
+	FingerprintTlsCrypt     string = "tls-crypt"
+	FingerprintClientCommon string = "client-common"
+)
+
+// Fingerprints returns the sha256 of ca.crt, tc.key, or tc-v2.key with tls-crypt-v2, and client-common.txt, a change in any of them
+// makes the profiles written before stale.
+func (o *OpenVpnConfig) Fingerprints() (map[string]string, error) {
+	fingerprints := map[string]string{}
+	for name, path := range map[string]string{
+		FingerprintCa:           o.CaPath,
+		FingerprintTlsCrypt:     o.profileTlsCryptPath(),
+		FingerprintClientCommon: o.ClientCommonPath,
+	} {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		sum := sha256.Sum256(content)
+		fingerprints[name] = hex.EncodeToString(sum[:])
+	}
+	return fingerprints, nil
+}
+
+// ChangedFingerprints returns the sorted names of the inputs whose fingerprint differs.
+func ChangedFingerprints(previous map[string]string, current map[string]string) []string {
+	var changed []string
+	for name, fingerprint := range current {
+		if previous[name] != fingerprint {
+			changed = append(changed, name)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// RemoveP12 removes the exported PKCS#12 bundle of the user so that the next one includes the current ca.
+func (o *OpenVpnConfig) RemoveP12(user string) error {
+	err := os.Remove(o.p12Path(user))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
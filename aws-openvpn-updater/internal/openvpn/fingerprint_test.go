@@ -0,0 +1,30 @@
+package openvpn
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFingerprints(t *testing.T) {
+	o := testProfileTree(t)
+	before, err := o.Fingerprints()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(before) != 3 {
+		t.Fatalf("got %v, wanted ca, tls-crypt and client-common fingerprints", before)
+	}
+
+	writeTestFile(t, o.ClientCommonPath, []byte("client\nremote 10.0.0.1 443\n"))
+	after, err := o.Fingerprints()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := ChangedFingerprints(before, after)
+	if !reflect.DeepEqual(got, []string{FingerprintClientCommon}) {
+		t.Errorf("got %v, wanted client-common changed", got)
+	}
+	if got = ChangedFingerprints(nil, after); len(got) != 3 {
+		t.Errorf("got %v, wanted every fingerprint changed from none", got)
+	}
+}
@@ -0,0 +1,212 @@
+package openvpn
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const (
+	StateValid   string = "V"
+	StateRevoked string = "R"
+	StateExpired string = "E"
+
+	utcTimeLayout         string = "060102150405Z"
+	generalizedTimeLayout string = "20060102150405Z"
+)
+
+// IndexEntry is a line of the OpenSSL index.txt database.
+type IndexEntry struct {
+	State      string
+	Expiry     time.Time
+	Revocation time.Time
+	Reason     string
+	Serial     string
+	Filename   string
+	Subject    string
+	CommonName string
+}
+
+func (e IndexEntry) String() string {
+	return fmt.Sprintf("[ State: %s, Expiry: %s, Serial: %s, Subject: %s ]", e.State, e.Expiry.Format(time.RFC3339), e.Serial, e.Subject)
+}
+
+// Line formats the entry as an index.txt line.
+func (e IndexEntry) Line() string {
+	revocation := ""
+	if !e.Revocation.IsZero() {
+		revocation = formatIndexTime(e.Revocation)
+		if e.Reason != "" {
+			revocation += "," + e.Reason
+		}
+	}
+	return strings.Join([]string{e.State, formatIndexTime(e.Expiry), revocation, e.Serial, e.Filename, e.Subject}, "\t")
+}
+
+// Index is the parsed content of index.txt, entries are kept in file order.
+type Index struct {
+	Entries []IndexEntry
+}
+
+// ParseIndex reads an index.txt database. Malformed lines are skipped and reported in the returned error.
+func ParseIndex(r io.Reader) (*Index, error) {
+	var errs []error
+	index := &Index{}
+	scanner := bufio.NewScanner(r)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		entry, err := ParseIndexLine(line)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("line %d: %w", lineNumber, err))
+			continue
+		}
+		index.Entries = append(index.Entries, *entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return index, errors.Join(errs...)
+}
+
+// History returns every entry issued for a common name, oldest first.
+func (i *Index) History(cn string) []IndexEntry {
+	var entries []IndexEntry
+	for _, entry := range i.Entries {
+		if entry.CommonName == cn {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// ParseIndexLine parses a tab separated index.txt line. Lines whose fields have been separated
+// with spaces are also accepted, the subject being the remaining of the line.
+func ParseIndexLine(line string) (*IndexEntry, error) {
+	var state, expiry, revocation, serial, filename, subject string
+	if strings.Count(line, "\t") >= 5 && len(strings.Split(line, "\t")) == 6 {
+		fields := strings.Split(line, "\t")
+		state, expiry, revocation, serial, filename, subject = fields[0], fields[1], fields[2], fields[3], fields[4], fields[5]
+	} else {
+		var ok bool
+		rest := line
+		state, rest, _ = cutField(rest)
+		expiry, rest, _ = cutField(rest)
+		if state == StateRevoked {
+			revocation, rest, _ = cutField(rest)
+		}
+		serial, rest, _ = cutField(rest)
+		filename, rest, ok = cutField(rest)
+		if !ok {
+			return nil, fmt.Errorf("missing fields in %q", line)
+		}
+		subject = rest
+	}
+	subject = strings.TrimSpace(subject)
+
+	entry := &IndexEntry{State: state, Serial: serial, Filename: filename, Subject: subject}
+	var err error
+	if state != StateValid && state != StateRevoked && state != StateExpired {
+		return nil, fmt.Errorf("unknown state %q", state)
+	}
+	if entry.Expiry, err = parseIndexTime(expiry); err != nil {
+		return nil, fmt.Errorf("expiry date: %w", err)
+	}
+	if revocation != "" {
+		date, reason, _ := strings.Cut(revocation, ",")
+		if entry.Revocation, err = parseIndexTime(date); err != nil {
+			return nil, fmt.Errorf("revocation date: %w", err)
+		}
+		entry.Reason = reason
+	} else if state == StateRevoked {
+		return nil, errors.New("missing revocation date")
+	}
+	if serial == "" || strings.TrimLeft(strings.ToUpper(serial), "0123456789ABCDEF") != "" {
+		return nil, fmt.Errorf("invalid serial %q", serial)
+	}
+	if filename == "" || strings.ContainsAny(filename, "\t") {
+		return nil, fmt.Errorf("invalid filename %q", filename)
+	}
+	if entry.CommonName, err = commonName(subject); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// cutField returns the first whitespace separated field of s and the remaining of s.
+func cutField(s string) (string, string, bool) {
+	s = strings.TrimLeftFunc(s, unicode.IsSpace)
+	end := strings.IndexFunc(s, unicode.IsSpace)
+	if end < 0 {
+		return s, "", s != ""
+	}
+	return s[:end], s[end:], true
+}
+
+// commonName extracts the CN of a /K=V/K=V subject. A slash not followed by an attribute
+// name is considered part of the previous value.
+func commonName(subject string) (string, error) {
+	if !strings.HasPrefix(subject, "/") {
+		return "", fmt.Errorf("invalid subject %q", subject)
+	}
+	var attributes []string
+	for _, part := range strings.Split(subject[1:], "/") {
+		if key, _, ok := strings.Cut(part, "="); (ok && isAttributeName(key)) || len(attributes) == 0 {
+			attributes = append(attributes, part)
+		} else {
+			attributes[len(attributes)-1] += "/" + part
+		}
+	}
+	cn := ""
+	for _, attribute := range attributes {
+		if value, ok := strings.CutPrefix(attribute, "CN="); ok {
+			cn = value
+		}
+	}
+	if cn == "" {
+		return "", fmt.Errorf("no common name in subject %q", subject)
+	}
+	return cn, nil
+}
+
+func isAttributeName(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if !(r == '.' || r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z') {
+			return false
+		}
+	}
+	return true
+}
+
+// parseIndexTime parses an ASN.1 UTCTime or GeneralizedTime, two digits years map to 1950-2049.
+func parseIndexTime(s string) (time.Time, error) {
+	switch len(s) {
+	case len(utcTimeLayout):
+		century := "20"
+		if s[0] >= '5' {
+			century = "19"
+		}
+		return time.Parse(generalizedTimeLayout, century+s)
+	case len(generalizedTimeLayout):
+		return time.Parse(generalizedTimeLayout, s)
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q", s)
+}
+
+func formatIndexTime(t time.Time) string {
+	if t.Year() >= 1950 && t.Year() < 2050 {
+		return t.UTC().Format(utcTimeLayout)
+	}
+	return t.UTC().Format(generalizedTimeLayout)
+}
@@ -0,0 +1,110 @@
+package openvpn
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testIndex string = "V\t330729111815Z\t\t612D9DE2717A9D139908E09C243F9ADA\tunknown\t/CN=server\n" +
+	"R\t290429132915Z\t200525133920Z,keyCompromise\t0C\tunknown\t/CN=john\n" +
+	"E\t200101000000Z\t\t0D\tunknown\t/C=FR/O=FinalCAD/CN=jane\n" +
+	"V\t20510101000000Z\t\t0E\tunknown\t/CN=john\n" +
+	"garbage\n"
+
+func TestParseIndexLine(t *testing.T) {
+	tests := []struct {
+		line string
+		want IndexEntry
+	}{
+		{
+			"R\t290429132915Z\t200525133920Z,keyCompromise\t0C\tunknown\t/CN=test",
+			IndexEntry{State: "R", Expiry: time.Date(2029, 4, 29, 13, 29, 15, 0, time.UTC),
+				Revocation: time.Date(2020, 5, 25, 13, 39, 20, 0, time.UTC), Reason: "keyCompromise",
+				Serial: "0C", Filename: "unknown", Subject: "/CN=test", CommonName: "test"},
+		},
+		{
+			"R       290429132915Z   200525133920Z   0C                                      unknown /CN=test",
+			IndexEntry{State: "R", Expiry: time.Date(2029, 4, 29, 13, 29, 15, 0, time.UTC),
+				Revocation: time.Date(2020, 5, 25, 13, 39, 20, 0, time.UTC),
+				Serial:     "0C", Filename: "unknown", Subject: "/CN=test", CommonName: "test"},
+		},
+		{
+			"V\t20510101000000Z\t\t0E\tunknown\t/C=FR/O=Final CAD/CN=team/ops/emailAddress=ops@example.com",
+			IndexEntry{State: "V", Expiry: time.Date(2051, 1, 1, 0, 0, 0, 0, time.UTC),
+				Serial: "0E", Filename: "unknown", Subject: "/C=FR/O=Final CAD/CN=team/ops/emailAddress=ops@example.com",
+				CommonName: "team/ops"},
+		},
+	}
+	for _, test := range tests {
+		got, err := ParseIndexLine(test.line)
+		if err != nil {
+			t.Errorf("%q: %v", test.line, err)
+			continue
+		}
+		if !reflect.DeepEqual(*got, test.want) {
+			t.Errorf("got %+v, wanted %+v", *got, test.want)
+		}
+	}
+}
+
+func TestParseIndexLineInvalid(t *testing.T) {
+	lines := []string{
+		"",
+		"X\t330729111815Z\t\t0C\tunknown\t/CN=test",
+		"V\t3307291118Z\t\t0C\tunknown\t/CN=test",
+		"R\t290429132915Z\t\t0C\tunknown\t/CN=test",
+		"V\t330729111815Z\t\tZZ\tunknown\t/CN=test",
+		"V\t330729111815Z\t\t0C\tunknown\tCN=test",
+		"V\t330729111815Z\t\t0C\tunknown\t/O=FinalCAD",
+	}
+	for _, line := range lines {
+		if got, err := ParseIndexLine(line); err == nil {
+			t.Errorf("%q: got %v, wanted an error", line, got)
+		}
+	}
+}
+
+func TestParseIndex(t *testing.T) {
+	index, err := ParseIndex(strings.NewReader(testIndex))
+
+	if err == nil || !strings.Contains(err.Error(), "line 5") {
+		t.Errorf("got %v, wanted an error on line 5", err)
+	}
+	if len(index.Entries) != 4 {
+		t.Fatalf("got %d entries, wanted 4", len(index.Entries))
+	}
+
+	history := index.History("john")
+	if len(history) != 2 || history[0].Serial != "0C" || history[1].Serial != "0E" {
+		t.Errorf("got %v, wanted serials 0C then 0E", history)
+	}
+	if history := index.History("jane"); len(history) != 1 || history[0].State != StateExpired {
+		t.Errorf("got %v, wanted one expired entry", history)
+	}
+}
+
+func FuzzParseIndexLine(f *testing.F) {
+	for _, line := range strings.Split(testIndex, "\n") {
+		f.Add(line)
+	}
+	f.Add("V       330729111815Z                   612D9DE2717A9D139908E09C243F9ADA        unknown /CN=test")
+	f.Add("R\t290429132915Z\t200525133920Z,keyCompromise\t0C\tunknown\t/CN=a/b/OU=c")
+	f.Fuzz(func(t *testing.T, line string) {
+		entry, err := ParseIndexLine(line)
+		if err != nil {
+			return
+		}
+		if entry.CommonName == "" {
+			t.Errorf("%q: empty common name", line)
+		}
+		again, err := ParseIndexLine(entry.Line())
+		if err != nil {
+			t.Fatalf("%q: formatted line %q does not parse: %v", line, entry.Line(), err)
+		}
+		if !reflect.DeepEqual(entry, again) {
+			t.Errorf("%q: got %+v after formatting, wanted %+v", line, again, entry)
+		}
+	})
+}
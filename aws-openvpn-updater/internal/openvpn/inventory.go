@@ -0,0 +1,118 @@
+package openvpn
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Inventory lists the files left on disk that do not match the index.
+type Inventory struct {
+	// client_configs profiles of users without a valid certificate
+	OrphanedConfigs []string
+	// issued/*.crt whose serial is not in index.txt
+	UnindexedCerts []string
+	// private/*.key of names without a valid certificate
+	LeftoverKeys []string
+}
+
+func (i Inventory) String() string {
+	return fmt.Sprintf("[ OrphanedConfigs: %v, UnindexedCerts: %v, LeftoverKeys: %v ]", i.OrphanedConfigs, i.UnindexedCerts, i.LeftoverKeys)
+}
+
+func (i Inventory) Files() []string {
+	var files []string
+	files = append(files, i.OrphanedConfigs...)
+	files = append(files, i.UnindexedCerts...)
+	return append(files, i.LeftoverKeys...)
+}
+
+// ScanInventory compares client configs, issued certificates and private keys with the last read index.
+func (o *OpenVpnConfig) ScanInventory() (*Inventory, error) {
+	if o.Index == nil {
+		return nil, fmt.Errorf("index not loaded: %s", o.IndexPah)
+	}
+	clients := map[string]bool{}
+	for _, certInfo := range o.CertificateInfos {
+		clients[certInfo.Name] = true
+	}
+	valid := map[string]bool{"ca": true}
+	serials := map[string]bool{}
+	for _, entry := range o.Index.Entries {
+		if entry.State == StateValid {
+			valid[entry.CommonName] = true
+		}
+		serials[normalizeSerial(entry.Serial)] = true
+	}
+
+	inventory := &Inventory{}
+	var configs []string
+	for _, ext := range ProfileExtensions() {
+		files, err := filepath.Glob(fmt.Sprintf("%s/client_configs/*%s", o.EasyRsaPath, ext))
+		if err != nil {
+			return nil, err
+		}
+		configs = append(configs, files...)
+	}
+	for _, config := range configs {
+		if !clients[baseName(config)] {
+			inventory.OrphanedConfigs = append(inventory.OrphanedConfigs, config)
+		}
+	}
+
+	certs, err := filepath.Glob(fmt.Sprintf("%s/issued/*.crt", o.EasyRsaKeyDirectoryPath))
+	if err != nil {
+		return nil, err
+	}
+	for _, cert := range certs {
+		serial, err := certificateSerial(cert)
+		if err != nil {
+			log.Warn().Err(err).Msgf("Unable to read certificate: %s", cert)
+			continue
+		}
+		if !serials[serial] {
+			inventory.UnindexedCerts = append(inventory.UnindexedCerts, cert)
+		}
+	}
+
+	keys, err := filepath.Glob(fmt.Sprintf("%s/private/*.key", o.EasyRsaKeyDirectoryPath))
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range keys {
+		if !valid[baseName(key)] {
+			inventory.LeftoverKeys = append(inventory.LeftoverKeys, key)
+		}
+	}
+	return inventory, nil
+}
+
+// RemoveInventory deletes every file reported by the inventory.
+func (o *OpenVpnConfig) RemoveInventory(inventory *Inventory) error {
+	for _, file := range inventory.Files() {
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		log.Info().Msgf("Removed stray file: %s", file)
+	}
+	return nil
+}
+
+func baseName(path string) string {
+	return strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+}
+
+func certificateSerial(path string) (string, error) {
+	cert, err := readCertificate(path)
+	if err != nil {
+		return "", err
+	}
+	return normalizeSerial(fmt.Sprintf("%X", cert.SerialNumber)), nil
+}
+
+func normalizeSerial(serial string) string {
+	return strings.TrimLeft(strings.ToUpper(serial), "0")
+}
@@ -0,0 +1,88 @@
+package openvpn
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/FinalCAD/vpn-stack/aws-openvpn-updater/internal/settings"
+)
+
+func writeTestFile(t *testing.T, path string, content []byte) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, content, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testCertificate(t *testing.T, cn string, serial int64) []byte {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return append([]byte("Certificate:\n    Data: ...\n"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})...)
+}
+
+func TestScanInventory(t *testing.T) {
+	dir := t.TempDir()
+	o := CreateOpenVpnConfig(&settings.OpenVpn{EasyRsaPath: dir, EasyRsaKeyDirectory: "pki", OpenVpnServerPath: dir})
+	writeTestFile(t, o.IndexPah, []byte("V\t330729111815Z\t\t01\tunknown\t/CN=server\n"+
+		"V\t330729111815Z\t\t0A\tunknown\t/CN=john\n"+
+		"R\t330729111815Z\t230101000000Z\t0B\tunknown\t/CN=jane\n"))
+	writeTestFile(t, filepath.Join(dir, "client_configs", "john.ovpn"), []byte("john"))
+	writeTestFile(t, filepath.Join(dir, "client_configs", "jane.ovpn"), []byte("jane"))
+	writeTestFile(t, filepath.Join(dir, "client_configs", "jane.zip"), []byte("jane"))
+	writeTestFile(t, filepath.Join(dir, "pki", "issued", "server.crt"), testCertificate(t, "server", 1))
+	writeTestFile(t, filepath.Join(dir, "pki", "issued", "john.crt"), testCertificate(t, "john", 10))
+	writeTestFile(t, filepath.Join(dir, "pki", "issued", "ghost.crt"), testCertificate(t, "ghost", 12))
+	for _, name := range []string{"ca", "server", "john", "jane"} {
+		writeTestFile(t, filepath.Join(dir, "pki", "private", name+".key"), []byte(name))
+	}
+	if err := o.GetUser(); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := o.ScanInventory()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := &Inventory{
+		OrphanedConfigs: []string{filepath.Join(dir, "client_configs", "jane.ovpn"), filepath.Join(dir, "client_configs", "jane.zip")},
+		UnindexedCerts:  []string{filepath.Join(dir, "pki", "issued", "ghost.crt")},
+		LeftoverKeys:    []string{filepath.Join(dir, "pki", "private", "jane.key")},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, wanted %v", got, want)
+	}
+
+	if err = o.RemoveInventory(got); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(filepath.Join(dir, "pki", "private", "jane.key")); !os.IsNotExist(err) {
+		t.Errorf("got %v, wanted removed key", err)
+	}
+}
@@ -0,0 +1,117 @@
+package openvpn
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/FinalCAD/vpn-stack/aws-openvpn-updater/internal/settings"
+)
+
+// UnknownAlgorithm is reported for certificates that cannot be read.
+const UnknownAlgorithm string = "unknown"
+
+// Go names of the curves of settings.ecCurves.
+var curveNames = map[string]string{
+	"prime256v1": "P-256",
+	"secp384r1":  "P-384",
+	"secp521r1":  "P-521",
+}
+
+// KeyOptions selects the algorithm of the keys issued by easyrsa, empty values keep those of its vars file.
+type KeyOptions struct {
+	Algo    string
+	KeySize int
+	Curve   string
+	Digest  string
+}
+
+func (k KeyOptions) String() string {
+	return fmt.Sprintf("[ Algo: %v, KeySize: %v, Curve: %v, Digest: %v ]", k.Algo, k.KeySize, k.Curve, k.Digest)
+}
+
+func CreateKeyOptions(config *settings.OpenVpn) KeyOptions {
+	curve := config.KeyCurve
+	if config.KeyAlgo == settings.KeyAlgoEd && curve == "" {
+		curve = "ed25519"
+	}
+	return KeyOptions{Algo: config.KeyAlgo, KeySize: config.KeySize, Curve: curve, Digest: config.Digest}
+}
+
+// args returns the easyrsa options, each preceded by a space. Values are checked by Settings.Validate.
+func (k KeyOptions) args() string {
+	var args strings.Builder
+	if k.Algo != "" {
+		fmt.Fprintf(&args, " --use-algo=%s", k.Algo)
+	}
+	if k.KeySize != 0 {
+		fmt.Fprintf(&args, " --keysize=%d", k.KeySize)
+	}
+	if k.Curve != "" {
+		fmt.Fprintf(&args, " --curve=%s", k.Curve)
+	}
+	if k.Digest != "" {
+		fmt.Fprintf(&args, " --digest=%s", k.Digest)
+	}
+	return args.String()
+}
+
+// Algorithm returns the key algorithm of the certificates issued with the options as reported by
+// CertificateAlgorithm, empty when it depends on the easyrsa vars file.
+func (k KeyOptions) Algorithm() string {
+	switch k.Algo {
+	case settings.KeyAlgoRsa:
+		if k.KeySize != 0 {
+			return fmt.Sprintf("RSA-%d", k.KeySize)
+		}
+	case settings.KeyAlgoEc:
+		if name, ok := curveNames[k.Curve]; ok {
+			return "ECDSA-" + name
+		}
+	case settings.KeyAlgoEd:
+		if k.Curve == "ed25519" {
+			return "Ed25519"
+		}
+	}
+	return ""
+}
+
+// CertificateAlgorithm returns the key algorithm of a pem certificate, ie RSA-2048, ECDSA-P-256 or Ed25519,
+// and its signature algorithm.
+func CertificateAlgorithm(path string) (string, string, error) {
+	cert, err := readCertificate(path)
+	if err != nil {
+		return "", "", err
+	}
+	algorithm, signature := certificateAlgorithm(cert)
+	return algorithm, signature, nil
+}
+
+func certificateAlgorithm(cert *x509.Certificate) (string, string) {
+	switch key := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		return fmt.Sprintf("RSA-%d", key.N.BitLen()), cert.SignatureAlgorithm.String()
+	case *ecdsa.PublicKey:
+		return "ECDSA-" + key.Curve.Params().Name, cert.SignatureAlgorithm.String()
+	case ed25519.PublicKey:
+		return "Ed25519", cert.SignatureAlgorithm.String()
+	}
+	return cert.PublicKeyAlgorithm.String(), cert.SignatureAlgorithm.String()
+}
+
+func readCertificate(path string) (*x509.Certificate, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(content)
+	if block == nil {
+		return nil, fmt.Errorf("no pem certificate in %s", path)
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
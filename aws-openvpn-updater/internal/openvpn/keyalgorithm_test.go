@@ -0,0 +1,78 @@
+package openvpn
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/FinalCAD/vpn-stack/aws-openvpn-updater/internal/settings"
+)
+
+func testKeyCertificate(t *testing.T, key crypto.Signer) []byte {
+	t.Helper()
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "john"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func TestCertificateAlgorithm(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		key       crypto.Signer
+		options   KeyOptions
+		algorithm string
+		signature string
+	}{
+		{rsaKey, KeyOptions{Algo: settings.KeyAlgoRsa, KeySize: 2048}, "RSA-2048", "SHA256-RSA"},
+		{ecKey, KeyOptions{Algo: settings.KeyAlgoEc, Curve: "secp384r1"}, "ECDSA-P-384", "ECDSA-SHA384"},
+		{edKey, CreateKeyOptions(&settings.OpenVpn{KeyAlgo: settings.KeyAlgoEd}), "Ed25519", "Ed25519"},
+	}
+	dir := t.TempDir()
+	for _, tt := range tests {
+		path := filepath.Join(dir, "john.crt")
+		writeTestFile(t, path, testKeyCertificate(t, tt.key))
+
+		algorithm, signature, err := CertificateAlgorithm(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if algorithm != tt.algorithm || signature != tt.signature {
+			t.Errorf("got %s signed with %s, wanted %s signed with %s", algorithm, signature, tt.algorithm, tt.signature)
+		}
+		if got := tt.options.Algorithm(); got != tt.algorithm {
+			t.Errorf("got %s for %v, wanted %s", got, tt.options, tt.algorithm)
+		}
+	}
+	if got := (KeyOptions{Algo: settings.KeyAlgoRsa}).Algorithm(); got != "" {
+		t.Errorf("got %s, wanted no algorithm without key size", got)
+	}
+}
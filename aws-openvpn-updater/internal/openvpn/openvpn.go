@@ -1,11 +1,10 @@
 package openvpn
 
 import (
-	"bufio"
+	"context"
 	_ "embed"
 	"fmt"
 	"os"
-	"regexp"
 	"text/template"
 	"time"
 
@@ -20,6 +19,7 @@ var templateConfig string
 type OpenVpnConfig struct {
 	SavedLastModifiedTime   time.Time
 	CertificateInfos        []CertificateInfo
+	Index                   *Index
 	IndexPah                string
 	ClientCommonPath        string
 	CaPath                  string
@@ -27,13 +27,21 @@ type OpenVpnConfig struct {
 	EasyRsaKeyDirectoryPath string
 	ClientTlsCryptPath      string
 	CrlPath                 string
+	ServerCaPath            string
+	ServerCertPath          string
+	ServerKeyPath           string
+	// TlsCryptV2 embeds a tls-crypt-v2 key per client instead of the shared tc.key
+	TlsCryptV2            bool
+	ServerTlsCryptV2Path  string
+	TlsCryptV2RevokedPath string
+	KeyOptions            KeyOptions
 }
 
 func (o OpenVpnConfig) String() string {
 	return fmt.Sprintf("[ IndexPah: %v, ClientCommonPath: %v, CaPath: %v,"+
-		"EasyRsaPath: %v, EasyRsaKeyDirectoryPath: %v, ClientTlsCryptPath: %v, CrlPath: %v ]",
+		"EasyRsaPath: %v, EasyRsaKeyDirectoryPath: %v, ClientTlsCryptPath: %v, CrlPath: %v, TlsCryptV2: %v ]",
 		o.IndexPah, o.ClientCommonPath, o.CaPath, o.EasyRsaPath,
-		o.EasyRsaKeyDirectoryPath, o.ClientTlsCryptPath, o.CrlPath)
+		o.EasyRsaKeyDirectoryPath, o.ClientTlsCryptPath, o.CrlPath, o.TlsCryptV2)
 }
 
 func CreateOpenVpnConfig(config *settings.OpenVpn) *OpenVpnConfig {
@@ -51,6 +59,13 @@ func CreateOpenVpnConfig(config *settings.OpenVpn) *OpenVpnConfig {
 		EasyRsaKeyDirectoryPath: easyRsaKeyDirectoryPath,
 		ClientTlsCryptPath:      clientTlsCryptPath,
 		CrlPath:                 crlPath,
+		ServerCaPath:            fmt.Sprintf("%s/ca.crt", config.OpenVpnServerPath),
+		ServerCertPath:          fmt.Sprintf("%s/server.crt", config.OpenVpnServerPath),
+		ServerKeyPath:           fmt.Sprintf("%s/server.key", config.OpenVpnServerPath),
+		TlsCryptV2:              config.TlsCrypt == settings.TlsCryptV2,
+		ServerTlsCryptV2Path:    fmt.Sprintf("%s/tc-v2.key", config.OpenVpnServerPath),
+		TlsCryptV2RevokedPath:   fmt.Sprintf("%s/tc-v2-revoked.txt", config.OpenVpnServerPath),
+		KeyOptions:              CreateKeyOptions(config),
 	}
 }
 
@@ -68,60 +83,117 @@ func (o *OpenVpnConfig) GetUser() error {
 		return nil
 	}
 
-	o.SavedLastModifiedTime = lastModifiedTime
 	file, err := os.Open(o.IndexPah)
 	if err != nil {
 		return err
 	}
 	defer file.Close()
 
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		line := scanner.Text()
-		certInfo := CreateCertificateInfo(line)
+	index, err := ParseIndex(file)
+	if index == nil {
+		return err
+	}
+	if err != nil {
+		log.Warn().Err(err).Msg("Malformed lines in openvpn index file")
+	}
+	for _, entry := range index.Entries {
+		certInfo := createCertificateInfoFromEntry(entry)
 		if certInfo != nil {
+			o.readIssued(certInfo)
 			certArray = append(certArray, *certInfo)
 		}
 	}
+	o.SavedLastModifiedTime = lastModifiedTime
+	o.Index = index
 	o.CertificateInfos = certArray
 	log.Debug().Msgf("List of valid certificate: %s", o.CertificateInfos)
 	return nil
 }
 
-func (o *OpenVpnConfig) CreateUser(user string, usefqdn bool) (string, error) {
+// readIssued sets the issue date and the key and signature algorithms of the issued certificate.
+func (o *OpenVpnConfig) readIssued(certInfo *CertificateInfo) {
+	path := fmt.Sprintf("%s/issued/%s.crt", o.EasyRsaKeyDirectoryPath, certInfo.Name)
+	cert, err := readCertificate(path)
+	if err != nil {
+		log.Debug().Err(err).Msgf("Unable to read %s", path)
+		certInfo.Algorithm, certInfo.Signature = UnknownAlgorithm, UnknownAlgorithm
+		return
+	}
+	certInfo.Issued = cert.NotBefore
+	certInfo.Algorithm, certInfo.Signature = certificateAlgorithm(cert)
+}
+
+// History returns every certificate issued for a common name, oldest first.
+func (o *OpenVpnConfig) History(cn string) []IndexEntry {
+	if o.Index == nil {
+		return nil
+	}
+	return o.Index.History(cn)
+}
+
+// CreateUser issues the certificate of the user and writes its client profile, the private key
+// is encrypted with the profile passphrase unless empty.
+func (o *OpenVpnConfig) CreateUser(ctx context.Context, user string, profile Profile) (string, error) {
 	log.Debug().Msgf("Creating config for user: %s", user)
 	var err error
 
-	err = cmdNewUser(user, o.EasyRsaPath)
+	days := profile.ValidityDays
+	if days == 0 {
+		days = defaultValidityDays
+	}
+	err = cmdNewUser(ctx, user, o.EasyRsaPath, o.EasyRsaKeyDirectoryPath, profile.Passphrase, o.KeyOptions, days)
 	if err != nil {
 		return "", err
 	}
 	log.Debug().Msgf("Easyrsa command succesfull for user: %s", user)
 
-	outputFileName := fmt.Sprintf("%s/client_configs/%s.ovpn", o.EasyRsaPath, user)
+	return o.WriteProfile(ctx, user, profile)
+}
 
-	outputFile, err := utils.CreateFile(outputFileName)
+// ConfigFilePath returns the path of the inline client config of a user.
+func (o *OpenVpnConfig) ConfigFilePath(user string) string {
+	return o.profileFilePath(user, ".ovpn")
+}
+
+func (o *OpenVpnConfig) profileFilePath(user string, ext string) string {
+	return fmt.Sprintf("%s/client_configs/%s%s", o.EasyRsaPath, user, ext)
+}
+
+// configUser reads the certificates and keys of a user and the client-common.txt settings,
+// with the remotes of the profile.
+func (o *OpenVpnConfig) configUser(user string, profile Profile) (*ConfigUser, error) {
+	configUser, err := CreateConfigUser(user, o.ClientCommonPath, o.CaPath, o.EasyRsaKeyDirectoryPath, o.clientTlsCryptPath(user))
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	defer outputFile.Close()
-	log.Debug().Msgf("Client config file: %s", outputFileName)
+	configUser.TlsCryptV2 = o.TlsCryptV2
+	remotes, err := profileRemotes(configUser.ClientCommon, profile)
+	if err != nil {
+		return nil, err
+	}
+	if remotes != nil {
+		configUser.ClientCommon = rewriteRemotes(configUser.ClientCommon, remotes, profile.RemoteRandom)
+	}
+	log.Debug().Msgf("Client config infos: %s", configUser)
+	return configUser, nil
+}
+
+// WriteConfig renders the inline client config of a user from its issued certificate and key.
+func (o *OpenVpnConfig) WriteConfig(user string, profile Profile) (string, error) {
+	var err error
+	outputFileName := o.ConfigFilePath(user)
 
-	configUser, err := CreateConfigUser(user, o.ClientCommonPath, o.CaPath, o.EasyRsaKeyDirectoryPath, o.ClientTlsCryptPath)
+	configUser, err := o.configUser(user, profile)
 	if err != nil {
 		return "", err
 	}
-	if usefqdn {
-		hostname, err := os.Hostname()
-		if err != nil {
-			return "", err
-		}
-		log.Debug().Msgf("Hostname: %s", hostname)
-		remotePattern := `remote\s+(\d+\.\d+\.\d+\.\d+)\s+(\d+)`
-		re := regexp.MustCompile(remotePattern)
-		configUser.ClientCommon = re.ReplaceAllString(configUser.ClientCommon, fmt.Sprintf("remote %s $2", hostname))
+
+	outputFile, err := utils.CreateFile(outputFileName)
+	if err != nil {
+		return "", err
 	}
-	log.Debug().Msgf("Client config infos: %s", configUser)
+	defer outputFile.Close()
+	log.Debug().Msgf("Client config file: %s", outputFileName)
 
 	tmpl := template.Must(template.New("configTemplate").Parse(templateConfig))
 	err = tmpl.Execute(outputFile, configUser)
@@ -132,19 +204,23 @@ func (o *OpenVpnConfig) CreateUser(user string, usefqdn bool) (string, error) {
 	return outputFileName, nil
 }
 
-func (o *OpenVpnConfig) DeleteUser(user string) error {
+func (o *OpenVpnConfig) DeleteUser(ctx context.Context, user string) error {
 	log.Debug().Msgf("Revoking config for user: %s", user)
 	var err error
 
-	err = cmdRevokeUser(user, o.CrlPath, o.EasyRsaPath, o.EasyRsaKeyDirectoryPath)
+	err = cmdRevokeUser(ctx, user, o.CrlPath, o.EasyRsaPath, o.EasyRsaKeyDirectoryPath)
+	if err != nil {
+		return err
+	}
+
+	err = o.retireTlsCryptV2Key(user)
 	if err != nil {
 		return err
 	}
 
-	configFileName := fmt.Sprintf("%s/client_configs/%s.ovpn", o.EasyRsaPath, user)
-	if _, err = os.Stat(configFileName); err == nil {
-		err := os.Remove(configFileName)
-		if err != nil {
+	for _, fileName := range o.profileFiles(user) {
+		err = os.Remove(fileName)
+		if err != nil && !os.IsNotExist(err) {
 			return err
 		}
 	}
@@ -1,46 +1,85 @@
 package openvpn
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"os/exec"
+	"time"
 )
 
+// Passphrases are read by openssl from the environment so that they do not show in the process list.
 const (
-	newUserCmd    string = "cd %s && ./easyrsa --batch --days=3650 build-client-full \"%s\" nopass > /dev/null 2>&1"
-	revokeUserCmd string = "cd %s && ./easyrsa --batch revoke \"%s\" > /dev/null 2>&1 && ./easyrsa --batch --days=3650 gen-crl > /dev/null 2>&1"
-	updateCrtCmd  string = "rm -f %[1]s > /dev/null 2>&1 && cp %s/crl.pem %[1]s> /dev/null 2>&1 && chown nobody:nogroup %[1]s > /dev/null 2>&1"
+	newUserCmd       string = "cd %s && ./easyrsa --batch%s --days=%d build-client-full \"%s\" nopass > /dev/null 2>&1"
+	newUserPassCmd   string = "cd %s && ./easyrsa --batch%s --days=%d --passout=env:" + passphraseEnv + " build-client-full \"%s\" > /dev/null 2>&1"
+	exportP12Cmd     string = "cd %s && ./easyrsa --batch export-p12 \"%s\" nopass > /dev/null 2>&1"
+	exportP12PassCmd string = "cd %s && ./easyrsa --batch --passin=env:" + passphraseEnv + " --passout=env:" + passphraseEnv + " export-p12 \"%s\" > /dev/null 2>&1"
+	revokeUserCmd    string = "cd %s && ./easyrsa --batch revoke \"%s\" > /dev/null 2>&1 && ./easyrsa --batch --days=3650 gen-crl > /dev/null 2>&1"
+	initPkiCmd       string = "cd %s && ./easyrsa --batch init-pki > /dev/null 2>&1 && ./easyrsa --batch%[2]s --req-cn=\"%[3]s\" build-ca nopass > /dev/null 2>&1 && ./easyrsa --batch%[2]s --days=3650 build-server-full server nopass > /dev/null 2>&1 && ./easyrsa --batch --days=3650 gen-crl > /dev/null 2>&1"
+	newTlsCryptV2Cmd string = "openvpn --tls-crypt-v2 \"%s\" --genkey tls-crypt-v2-client \"%s\" %s > /dev/null 2>&1"
+	updateCrtCmd     string = "rm -f %[1]s > /dev/null 2>&1 && cp %s/crl.pem %[1]s> /dev/null 2>&1 && chown nobody:nogroup %[1]s > /dev/null 2>&1"
 )
 
-func cmdNewUser(user string, path string) error {
-	cmd := exec.Command("bash", "-c", fmt.Sprintf(newUserCmd, path, user))
+const passphraseEnv string = "VPN_CLIENT_PASSPHRASE"
+
+// defaultValidityDays is the lifetime of client certificates without vpn-validity-days tag.
+const defaultValidityDays int = 3650
+
+// commandTimeout bounds every single easyrsa invocation.
+const commandTimeout time.Duration = time.Duration(2) * time.Minute
+
+// runCmd runs the command in bash, env entries are added to the environment of the updater.
+func runCmd(ctx context.Context, command string, env ...string) error {
+	ctx, cancel := context.WithTimeout(ctx, commandTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "bash", "-c", command)
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
-
-	err := cmd.Run()
-	if err != nil {
-		return err
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
 	}
-	return nil
+
+	return cmd.Run()
 }
 
-func cmdRevokeUser(user string, crlPath string, easyRsaPath string, easyRsaKeyDirectoryPath string) error {
-	cmd := exec.Command("bash", "-c", fmt.Sprintf(revokeUserCmd, easyRsaPath, user))
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+// pkiEnv points easyrsa at the pki of key directory, the current one or the next one during a ca rotation.
+func pkiEnv(pkiPath string) string {
+	return "EASYRSA_PKI=" + pkiPath
+}
 
-	err := cmd.Run()
-	if err != nil {
-		return err
+// cmdNewUser builds the client certificate valid for days and its key, the key is encrypted when
+// passphrase is not empty.
+func cmdNewUser(ctx context.Context, user string, path string, pkiPath string, passphrase string, keyOptions KeyOptions, days int) error {
+	if passphrase == "" {
+		return runCmd(ctx, fmt.Sprintf(newUserCmd, path, keyOptions.args(), days, user), pkiEnv(pkiPath))
 	}
+	return runCmd(ctx, fmt.Sprintf(newUserPassCmd, path, keyOptions.args(), days, user), pkiEnv(pkiPath), passphraseEnv+"="+passphrase)
+}
 
-	cmd = exec.Command("bash", "-c", fmt.Sprintf(updateCrtCmd, crlPath, easyRsaKeyDirectoryPath))
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+// cmdExportP12 bundles the client certificate, key and ca, protected by passphrase unless empty.
+func cmdExportP12(ctx context.Context, user string, path string, pkiPath string, passphrase string) error {
+	if passphrase == "" {
+		return runCmd(ctx, fmt.Sprintf(exportP12Cmd, path, user), pkiEnv(pkiPath))
+	}
+	return runCmd(ctx, fmt.Sprintf(exportP12PassCmd, path, user), pkiEnv(pkiPath), passphraseEnv+"="+passphrase)
+}
 
-	err = cmd.Run()
+func cmdRevokeUser(ctx context.Context, user string, crlPath string, easyRsaPath string, easyRsaKeyDirectoryPath string) error {
+	err := runCmd(ctx, fmt.Sprintf(revokeUserCmd, easyRsaPath, user), pkiEnv(easyRsaKeyDirectoryPath))
 	if err != nil {
 		return err
 	}
-	return nil
+
+	return runCmd(ctx, fmt.Sprintf(updateCrtCmd, crlPath, easyRsaKeyDirectoryPath))
+}
+
+// cmdNewTlsCryptV2Key wraps a new client key with the tls-crypt-v2 server key, metadata is base64 encoded.
+func cmdNewTlsCryptV2Key(ctx context.Context, serverKeyPath string, keyPath string, metadata string) error {
+	return runCmd(ctx, fmt.Sprintf(newTlsCryptV2Cmd, serverKeyPath, keyPath, metadata))
+}
+
+// cmdInitPki creates a pki with its ca, the server certificate and an empty crl.
+func cmdInitPki(ctx context.Context, path string, pkiPath string, commonName string, keyOptions KeyOptions) error {
+	return runCmd(ctx, fmt.Sprintf(initPkiCmd, path, keyOptions.args(), commonName), pkiEnv(pkiPath))
 }
@@ -0,0 +1,33 @@
+package openvpn
+
+import (
+	"crypto/rand"
+	"math/big"
+	"strings"
+)
+
+// passphraseAlphabet leaves out characters easily mistaken for one another when typed from a message.
+const passphraseAlphabet string = "abcdefghijkmnpqrstuvwxyzACDEFGHJKLMNPQRTUVWXY345679"
+
+const (
+	passphraseGroups    int = 4
+	passphraseGroupSize int = 5
+)
+
+// GeneratePassphrase returns a random passphrase made of dash separated groups of characters.
+func GeneratePassphrase() (string, error) {
+	max := big.NewInt(int64(len(passphraseAlphabet)))
+	groups := make([]string, passphraseGroups)
+	for i := range groups {
+		group := make([]byte, passphraseGroupSize)
+		for j := range group {
+			n, err := rand.Int(rand.Reader, max)
+			if err != nil {
+				return "", err
+			}
+			group[j] = passphraseAlphabet[n.Int64()]
+		}
+		groups[i] = string(group)
+	}
+	return strings.Join(groups, "-"), nil
+}
@@ -0,0 +1,61 @@
+package openvpn
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestGeneratePassphrase(t *testing.T) {
+	format := regexp.MustCompile(`^[` + passphraseAlphabet + `]{5}(-[` + passphraseAlphabet + `]{5}){3}$`)
+	first, err := GeneratePassphrase()
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := GeneratePassphrase()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !format.MatchString(first) {
+		t.Errorf("got passphrase %q, wanted 4 groups of 5 characters", first)
+	}
+	if first == second {
+		t.Errorf("got the same passphrase twice: %q", first)
+	}
+}
+
+func TestCmdNewUserPassphrase(t *testing.T) {
+	dir := t.TempDir()
+	script := "#!/bin/bash\necho \"$@ $" + passphraseEnv + "\" > calls\n"
+	if err := os.WriteFile(filepath.Join(dir, "easyrsa"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		passphrase string
+		keyOptions KeyOptions
+		days       int
+		want       string
+	}{
+		{"", KeyOptions{}, defaultValidityDays, "--batch --days=3650 build-client-full johndoe nopass \n"},
+		{"abcde-fghij", KeyOptions{}, defaultValidityDays, "--batch --days=3650 --passout=env:" + passphraseEnv + " build-client-full johndoe abcde-fghij\n"},
+		{"", KeyOptions{Algo: "ec", Curve: "prime256v1", Digest: "sha384"}, defaultValidityDays,
+			"--batch --use-algo=ec --curve=prime256v1 --digest=sha384 --days=3650 build-client-full johndoe nopass \n"},
+		{"", KeyOptions{}, 30, "--batch --days=30 build-client-full johndoe nopass \n"},
+	}
+	for _, tt := range tests {
+		if err := cmdNewUser(context.Background(), "johndoe", dir, filepath.Join(dir, "pki"), tt.passphrase, tt.keyOptions, tt.days); err != nil {
+			t.Fatal(err)
+		}
+		calls, err := os.ReadFile(filepath.Join(dir, "calls"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(calls) != tt.want {
+			t.Errorf("got easyrsa call %q, wanted %q", strings.TrimSpace(string(calls)), strings.TrimSpace(tt.want))
+		}
+	}
+}
@@ -0,0 +1,231 @@
+package openvpn
+
+import (
+	"archive/zip"
+	"context"
+	_ "embed"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"text/template"
+
+	"github.com/FinalCAD/vpn-stack/aws-openvpn-updater/internal/settings"
+	"github.com/FinalCAD/vpn-stack/aws-openvpn-updater/internal/utils"
+	"github.com/rs/zerolog/log"
+)
+
+//go:embed split.tmpl
+var templateSplit string
+
+//go:embed p12.tmpl
+var templateP12 string
+
+// ErrPassphraseRequired is returned when the PKCS#12 bundle of an encrypted key must be exported without its
+// passphrase, only known when the certificate is issued.
+var ErrPassphraseRequired = errors.New("the key is encrypted, its PKCS#12 bundle needs the passphrase of a new certificate")
+
+// profileExtensions are the extensions of the files written in client_configs, one per format.
+var profileExtensions = map[string]string{
+	settings.ProfileFormatInline: ".ovpn",
+	settings.ProfileFormatP12:    ".zip",
+	settings.ProfileFormatSplit:  ".zip",
+}
+
+// Profile describes how the client profile of a user is rendered.
+type Profile struct {
+	// UseFqdn replaces the host of client-common.txt remotes with the hostname
+	UseFqdn bool
+	// Remotes replace the remotes of client-common.txt, tried in order unless RemoteRandom
+	Remotes      []settings.Remote
+	RemoteRandom bool
+	// Format is one of settings.ProfileFormats
+	Format string
+	// Passphrase encrypting the private key, empty for an unencrypted key
+	Passphrase string
+	// ValidityDays is the lifetime of the certificate issued by CreateUser, 0 for the default
+	ValidityDays int
+}
+
+func (p Profile) String() string {
+	return fmt.Sprintf("[ UseFqdn: %v, Remotes: %v, RemoteRandom: %v, Format: %v, ValidityDays: %v ]", p.UseFqdn, p.Remotes, p.RemoteRandom, p.Format, p.ValidityDays)
+}
+
+// WriteProfile writes the client profile of a user in its format and returns its path: an inline .ovpn,
+// or a zip bundle of a minimal config and the files it references, separate pem files or a PKCS#12 bundle.
+func (o *OpenVpnConfig) WriteProfile(ctx context.Context, user string, profile Profile) (string, error) {
+	err := o.ensureTlsCryptV2Key(ctx, user)
+	if err != nil {
+		return "", err
+	}
+	switch profile.Format {
+	case settings.ProfileFormatP12:
+		err = o.exportP12(ctx, user, profile.Passphrase)
+		if err != nil {
+			return "", err
+		}
+		return o.writeBundle(user, profile, templateP12)
+	case settings.ProfileFormatSplit:
+		return o.writeBundle(user, profile, templateSplit)
+	}
+	return o.WriteConfig(user, profile)
+}
+
+func (o *OpenVpnConfig) p12Path(user string) string {
+	return fmt.Sprintf("%s/private/%s.p12", o.EasyRsaKeyDirectoryPath, user)
+}
+
+// exportP12 builds the PKCS#12 bundle with easyrsa unless exported after the certificate and the ca. The bundle
+// of an encrypted key is protected by the same passphrase, which is only known when the certificate is issued.
+func (o *OpenVpnConfig) exportP12(ctx context.Context, user string, passphrase string) error {
+	fresh, err := o.p12Fresh(user)
+	if err != nil || fresh {
+		return err
+	}
+	if passphrase == "" {
+		encrypted, err := o.KeyEncrypted(user)
+		if err != nil {
+			return err
+		}
+		if encrypted {
+			return fmt.Errorf("%s: %w", user, ErrPassphraseRequired)
+		}
+	}
+	return cmdExportP12(ctx, user, o.EasyRsaPath, o.EasyRsaKeyDirectoryPath, passphrase)
+}
+
+// p12Fresh returns whether the PKCS#12 bundle of the user exists and is newer than its certificate and the ca.
+func (o *OpenVpnConfig) p12Fresh(user string) (bool, error) {
+	p12, err := os.Stat(o.p12Path(user))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	for _, path := range []string{o.certPath(user), o.CaPath} {
+		info, err := os.Stat(path)
+		if err != nil {
+			return false, err
+		}
+		if p12.ModTime().Before(info.ModTime()) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func (o *OpenVpnConfig) certPath(user string) string {
+	return fmt.Sprintf("%s/issued/%s.crt", o.EasyRsaKeyDirectoryPath, user)
+}
+
+func (o *OpenVpnConfig) keyPath(user string) string {
+	return fmt.Sprintf("%s/private/%s.key", o.EasyRsaKeyDirectoryPath, user)
+}
+
+// KeyEncrypted returns whether the private key of the user is protected by a passphrase.
+func (o *OpenVpnConfig) KeyEncrypted(user string) (bool, error) {
+	content, err := os.ReadFile(o.keyPath(user))
+	if err != nil {
+		return false, err
+	}
+	// PKCS#8 "ENCRYPTED PRIVATE KEY" or legacy "Proc-Type: 4,ENCRYPTED" pem
+	return strings.Contains(string(content), "ENCRYPTED"), nil
+}
+
+func (o *OpenVpnConfig) writeBundle(user string, profile Profile, configTemplate string) (string, error) {
+	configUser, err := o.configUser(user, profile)
+	if err != nil {
+		return "", err
+	}
+	var config strings.Builder
+	err = template.Must(template.New("bundleTemplate").Parse(configTemplate)).Execute(&config, configUser)
+	if err != nil {
+		return "", err
+	}
+
+	files := []bundleFile{{user + ".ovpn", config.String()}, {"ta.key", configUser.ClientTlsCrypt}}
+	if profile.Format == settings.ProfileFormatP12 {
+		p12, err := os.ReadFile(o.p12Path(user))
+		if err != nil {
+			return "", err
+		}
+		files = append(files, bundleFile{user + ".p12", string(p12)})
+	} else {
+		files = append(files, bundleFile{"ca.crt", configUser.ClientCa}, bundleFile{user + ".crt", configUser.ClientCert},
+			bundleFile{user + ".key", configUser.ClientKey})
+	}
+
+	outputFileName := o.profileFilePath(user, profileExtensions[profile.Format])
+	err = writeZip(outputFileName, files)
+	if err != nil {
+		return "", err
+	}
+	log.Debug().Msgf("Client %s bundle generated succesfully for: %s", profile.Format, user)
+	return outputFileName, nil
+}
+
+type bundleFile struct {
+	name    string
+	content string
+}
+
+func writeZip(fileName string, files []bundleFile) error {
+	outputFile, err := utils.CreateFile(fileName)
+	if err != nil {
+		return err
+	}
+	defer outputFile.Close()
+
+	archive := zip.NewWriter(outputFile)
+	for _, file := range files {
+		w, err := archive.Create(file.name)
+		if err != nil {
+			return err
+		}
+		content := file.content
+		if !strings.HasSuffix(content, "\n") && !strings.HasSuffix(file.name, ".p12") {
+			content += "\n"
+		}
+		_, err = w.Write([]byte(content))
+		if err != nil {
+			return err
+		}
+	}
+	return archive.Close()
+}
+
+// profileFiles lists every file a profile of the user may have been written to.
+func (o *OpenVpnConfig) profileFiles(user string) []string {
+	files := []string{o.p12Path(user)}
+	for _, ext := range ProfileExtensions() {
+		files = append(files, o.profileFilePath(user, ext))
+	}
+	return files
+}
+
+// ProfileUser returns the user of an uploaded profile key, user names may contain dots.
+func ProfileUser(key string) string {
+	name := path.Base(key)
+	for _, ext := range ProfileExtensions() {
+		if strings.HasSuffix(name, ext) {
+			return strings.TrimSuffix(name, ext)
+		}
+	}
+	return baseName(name)
+}
+
+// ProfileExtensions returns the distinct extensions of the profiles, in format order.
+func ProfileExtensions() []string {
+	var extensions []string
+	seen := map[string]bool{}
+	for _, format := range settings.ProfileFormats {
+		ext := profileExtensions[format]
+		if !seen[ext] {
+			seen[ext] = true
+			extensions = append(extensions, ext)
+		}
+	}
+	return extensions
+}
@@ -0,0 +1,84 @@
+package openvpn
+
+import (
+	"os"
+	"strings"
+
+	"github.com/FinalCAD/vpn-stack/aws-openvpn-updater/internal/settings"
+)
+
+// clientRemotes returns the remote directives of client-common.txt.
+func clientRemotes(clientCommon string) []settings.Remote {
+	var remotes []settings.Remote
+	for _, line := range strings.Split(clientCommon, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || fields[0] != "remote" {
+			continue
+		}
+		remote := settings.Remote{Host: fields[1]}
+		if len(fields) > 2 {
+			remote.Port = fields[2]
+		}
+		if len(fields) > 3 {
+			remote.Proto = fields[3]
+		}
+		remotes = append(remotes, remote)
+	}
+	return remotes
+}
+
+// profileRemotes returns the remotes written in the profile: the configured ones, or with use-fqdn those
+// of client-common.txt with the hostname as host. Nil keeps client-common.txt remotes.
+func profileRemotes(clientCommon string, profile Profile) ([]settings.Remote, error) {
+	if len(profile.Remotes) > 0 {
+		return profile.Remotes, nil
+	}
+	if !profile.UseFqdn {
+		if profile.RemoteRandom {
+			return clientRemotes(clientCommon), nil
+		}
+		return nil, nil
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+	remotes := clientRemotes(clientCommon)
+	if len(remotes) == 0 {
+		return []settings.Remote{{Host: hostname}}, nil
+	}
+	for i := range remotes {
+		remotes[i].Host = hostname
+	}
+	return remotes, nil
+}
+
+// rewriteRemotes replaces the remote and remote-random directives of client-common.txt, in place
+// of the first one, or at the end when there was none. Remotes are tried in order unless random.
+func rewriteRemotes(clientCommon string, remotes []settings.Remote, random bool) string {
+	var directives []string
+	for _, remote := range remotes {
+		directives = append(directives, remote.Line())
+	}
+	if random {
+		directives = append(directives, "remote-random")
+	}
+
+	var lines []string
+	inserted := false
+	for _, line := range strings.Split(clientCommon, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) > 0 && (fields[0] == "remote" || fields[0] == "remote-random") {
+			if !inserted {
+				lines = append(lines, directives...)
+				inserted = true
+			}
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if !inserted {
+		lines = append(lines, directives...)
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,46 @@
+package openvpn
+
+import (
+	"os"
+	"testing"
+
+	"github.com/FinalCAD/vpn-stack/aws-openvpn-updater/internal/settings"
+)
+
+const testClientCommon string = "client\ndev tun\nproto udp\nremote 203.0.113.10 1194\nremote-random\nremote 203.0.113.11 443 tcp\nnobind"
+
+func TestRewriteRemotes(t *testing.T) {
+	remotes := []settings.Remote{{Host: "vpn1.example.com", Port: "1194", Proto: "udp"}, {Host: "2001:db8::1", Port: "443", Proto: "tcp"}}
+
+	got := rewriteRemotes(testClientCommon, remotes, false)
+	want := "client\ndev tun\nproto udp\nremote vpn1.example.com 1194 udp\nremote 2001:db8::1 443 tcp\nnobind"
+	if got != want {
+		t.Errorf("got %q, wanted %q", got, want)
+	}
+
+	got = rewriteRemotes("client\nnobind", remotes[:1], true)
+	want = "client\nnobind\nremote vpn1.example.com 1194 udp\nremote-random"
+	if got != want {
+		t.Errorf("got %q, wanted %q", got, want)
+	}
+}
+
+func TestProfileRemotesFqdn(t *testing.T) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := profileRemotes(testClientCommon, Profile{UseFqdn: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0].Line() != "remote "+hostname+" 1194" || got[1].Line() != "remote "+hostname+" 443 tcp" {
+		t.Errorf("got %v, wanted client-common.txt remotes on %s", got, hostname)
+	}
+
+	got, err = profileRemotes(testClientCommon, Profile{})
+	if err != nil || got != nil {
+		t.Errorf("got %v %v, wanted client-common.txt kept", got, err)
+	}
+}
@@ -0,0 +1,160 @@
+package openvpn
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// nextPkiSuffix names the pki of the new ca next to the current one during a ca rotation
+	nextPkiSuffix string = "-next"
+	// caBundleName holds the new and the current ca, trusted by the server and the reissued profiles
+	caBundleName string = "ca-bundle.crt"
+)
+
+// NextConfig returns the config of the pki clients are reissued under during a ca rotation. Its
+// profiles embed the ca bundle so that they accept the server certificate of either ca.
+func (o *OpenVpnConfig) NextConfig() *OpenVpnConfig {
+	easyRsaKeyDirectoryPath := o.EasyRsaKeyDirectoryPath + nextPkiSuffix
+	return &OpenVpnConfig{
+		IndexPah:                fmt.Sprintf("%s/index.txt", easyRsaKeyDirectoryPath),
+		ClientCommonPath:        o.ClientCommonPath,
+		CaPath:                  fmt.Sprintf("%s/%s", easyRsaKeyDirectoryPath, caBundleName),
+		EasyRsaPath:             o.EasyRsaPath,
+		EasyRsaKeyDirectoryPath: easyRsaKeyDirectoryPath,
+		ClientTlsCryptPath:      o.ClientTlsCryptPath,
+		CrlPath:                 o.CrlPath,
+		ServerCaPath:            o.ServerCaPath,
+		ServerCertPath:          o.ServerCertPath,
+		ServerKeyPath:           o.ServerKeyPath,
+		TlsCryptV2:              o.TlsCryptV2,
+		ServerTlsCryptV2Path:    o.ServerTlsCryptV2Path,
+		TlsCryptV2RevokedPath:   o.TlsCryptV2RevokedPath,
+		KeyOptions:              o.KeyOptions,
+	}
+}
+
+// InitNextPki creates the pki of the new ca with a server certificate, and the bundle of both cas.
+func (o *OpenVpnConfig) InitNextPki(ctx context.Context, commonName string) (*OpenVpnConfig, error) {
+	next := o.NextConfig()
+	if _, err := os.Stat(next.EasyRsaKeyDirectoryPath); err == nil {
+		return nil, fmt.Errorf("next pki %s already exists", next.EasyRsaKeyDirectoryPath)
+	}
+	err := cmdInitPki(ctx, o.EasyRsaPath, next.EasyRsaKeyDirectoryPath, commonName, o.KeyOptions)
+	if err != nil {
+		return nil, err
+	}
+	err = concatFiles(next.CaPath, 0644, fmt.Sprintf("%s/ca.crt", next.EasyRsaKeyDirectoryPath), o.CaPath)
+	if err != nil {
+		return nil, err
+	}
+	log.Info().Msgf("New ca created in %s", next.EasyRsaKeyDirectoryPath)
+	return next, nil
+}
+
+// PublishNext makes the server trust both cas: the ca bundle and the crls of both pkis. Published again
+// after each revocation during the rotation, as revoking writes the crl of a single pki.
+func (o *OpenVpnConfig) PublishNext(next *OpenVpnConfig) error {
+	err := concatFiles(o.ServerCaPath, 0644, next.CaPath)
+	if err != nil {
+		return err
+	}
+	return concatFiles(o.CrlPath, 0644, fmt.Sprintf("%s/crl.pem", o.EasyRsaKeyDirectoryPath),
+		fmt.Sprintf("%s/crl.pem", next.EasyRsaKeyDirectoryPath))
+}
+
+// RetirePki replaces the current pki with the next one, kept aside with a timestamp, and installs the new ca,
+// crl and server certificate on the server. Returns where the retired pki was moved. On failure the pkis
+// and the server files are put back as they were.
+func (o *OpenVpnConfig) RetirePki(now time.Time) (string, error) {
+	next := o.NextConfig()
+	retired := fmt.Sprintf("%s-retired-%s", o.EasyRsaKeyDirectoryPath, now.UTC().Format("20060102150405"))
+	err := os.Rename(o.EasyRsaKeyDirectoryPath, retired)
+	if err != nil {
+		return "", err
+	}
+	err = os.Rename(next.EasyRsaKeyDirectoryPath, o.EasyRsaKeyDirectoryPath)
+	if err != nil {
+		return "", rollback(err, func() error { return os.Rename(retired, o.EasyRsaKeyDirectoryPath) })
+	}
+	files := []struct {
+		source string
+		target string
+		mode   os.FileMode
+	}{
+		{o.CaPath, o.ServerCaPath, 0644},
+		{fmt.Sprintf("%s/crl.pem", o.EasyRsaKeyDirectoryPath), o.CrlPath, 0644},
+		{fmt.Sprintf("%s/issued/server.crt", o.EasyRsaKeyDirectoryPath), o.ServerCertPath, 0644},
+		{fmt.Sprintf("%s/private/server.key", o.EasyRsaKeyDirectoryPath), o.ServerKeyPath, 0600},
+	}
+	var restores []func() error
+	for _, file := range files {
+		restores = append(restores, backupFile(file.target, file.mode))
+		err = concatFiles(file.target, file.mode, file.source)
+		if err != nil {
+			restores = append(restores,
+				func() error { return os.Rename(o.EasyRsaKeyDirectoryPath, next.EasyRsaKeyDirectoryPath) },
+				func() error { return os.Rename(retired, o.EasyRsaKeyDirectoryPath) })
+			return "", rollback(err, restores...)
+		}
+	}
+	// Client keys of the old pki are not revoked one by one, profiles embedding them must be refused
+	err = o.retireTlsCryptV2Keys(retired)
+	if err != nil {
+		log.Error().Err(err).Msgf("Error revoking the tls-crypt-v2 keys of the retired pki %s", retired)
+	}
+	log.Info().Msgf("Ca retired to %s", retired)
+	return retired, nil
+}
+
+// backupFile returns the function writing back the current content of path, or removing it when missing.
+func backupFile(path string, mode os.FileMode) func() error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return func() error {
+			err := os.Remove(path)
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+	}
+	return func() error { return os.WriteFile(path, content, mode) }
+}
+
+// rollback runs the restores in order and returns err, with the restores that failed.
+func rollback(err error, restores ...func() error) error {
+	errs := []error{err}
+	for _, restore := range restores {
+		if restoreErr := restore(); restoreErr != nil {
+			errs = append(errs, fmt.Errorf("rollback: %w", restoreErr))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// concatFiles atomically replaces target with the concatenation of the sources.
+func concatFiles(target string, mode os.FileMode, sources ...string) error {
+	var content []byte
+	for _, source := range sources {
+		c, err := os.ReadFile(source)
+		if err != nil {
+			return err
+		}
+		if len(c) > 0 && c[len(c)-1] != '\n' {
+			c = append(c, '\n')
+		}
+		content = append(content, c...)
+	}
+	tmp := target + ".tmp"
+	err := os.WriteFile(tmp, content, mode)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, target)
+}
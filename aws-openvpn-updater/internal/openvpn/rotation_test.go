@@ -0,0 +1,107 @@
+package openvpn
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/FinalCAD/vpn-stack/aws-openvpn-updater/internal/settings"
+)
+
+// fakeEasyRsa stands in for the easyrsa commands of a ca rotation.
+const fakeEasyRsa string = `#!/bin/bash
+for arg in "$@"; do
+	case $arg in
+	init-pki) mkdir -p "$EASYRSA_PKI/issued" "$EASYRSA_PKI/private" ;;
+	build-ca) echo new-ca > "$EASYRSA_PKI/ca.crt" ;;
+	build-server-full) echo new-server > "$EASYRSA_PKI/issued/server.crt"; echo new-key > "$EASYRSA_PKI/private/server.key" ;;
+	gen-crl) echo new-crl > "$EASYRSA_PKI/crl.pem" ;;
+	esac
+done
+`
+
+func readTestFile(t *testing.T, path string) string {
+	t.Helper()
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(content)
+}
+
+func TestCaRotation(t *testing.T) {
+	dir := t.TempDir()
+	o := CreateOpenVpnConfig(&settings.OpenVpn{EasyRsaPath: dir, EasyRsaKeyDirectory: "pki", OpenVpnServerPath: filepath.Join(dir, "server")})
+	writeTestFile(t, filepath.Join(dir, "easyrsa"), []byte(fakeEasyRsa))
+	if err := os.Chmod(filepath.Join(dir, "easyrsa"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, o.CaPath, []byte("old-ca\n"))
+	writeTestFile(t, filepath.Join(dir, "pki", "crl.pem"), []byte("old-crl\n"))
+	writeTestFile(t, o.ServerCaPath, []byte("old-ca\n"))
+	writeTestFile(t, filepath.Join(dir, "pki", "tls-crypt-v2", "john.metadata"), []byte("john/1760860800\n"))
+
+	next, err := o.InitNextPki(context.Background(), "test-ca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readTestFile(t, next.CaPath); got != "new-ca\nold-ca\n" {
+		t.Errorf("got bundle %q, wanted the new and old ca", got)
+	}
+	if _, err = o.InitNextPki(context.Background(), "test-ca"); err == nil {
+		t.Error("got no error, wanted the existing next pki refused")
+	}
+
+	if err = o.PublishNext(next); err != nil {
+		t.Fatal(err)
+	}
+	if got := readTestFile(t, o.ServerCaPath); got != "new-ca\nold-ca\n" {
+		t.Errorf("got server ca %q, wanted the bundle", got)
+	}
+	if got := readTestFile(t, o.CrlPath); got != "old-crl\nnew-crl\n" {
+		t.Errorf("got crl %q, wanted both crls", got)
+	}
+
+	retired, err := o.RetirePki(time.Date(2026, 10, 19, 8, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if retired != filepath.Join(dir, "pki-retired-20261019080000") || readTestFile(t, filepath.Join(retired, "ca.crt")) != "old-ca\n" {
+		t.Errorf("got retired pki %s, wanted the old ca kept aside", retired)
+	}
+	for path, want := range map[string]string{o.CaPath: "new-ca\n", o.ServerCaPath: "new-ca\n", o.CrlPath: "new-crl\n",
+		o.ServerCertPath: "new-server\n", o.ServerKeyPath: "new-key\n", o.TlsCryptV2RevokedPath: "john/1760860800\n"} {
+		if got := readTestFile(t, path); got != want {
+			t.Errorf("got %q in %s, wanted %q", got, path, want)
+		}
+	}
+}
+
+func TestRetirePkiRollback(t *testing.T) {
+	dir := t.TempDir()
+	o := CreateOpenVpnConfig(&settings.OpenVpn{EasyRsaPath: dir, EasyRsaKeyDirectory: "pki", OpenVpnServerPath: filepath.Join(dir, "server")})
+	writeTestFile(t, o.CaPath, []byte("old-ca\n"))
+	writeTestFile(t, filepath.Join(dir, "pki", "crl.pem"), []byte("old-crl\n"))
+	writeTestFile(t, o.ServerCaPath, []byte("new-ca\nold-ca\n"))
+	writeTestFile(t, o.CrlPath, []byte("old-crl\nnew-crl\n"))
+	next := o.NextConfig()
+	writeTestFile(t, filepath.Join(next.EasyRsaKeyDirectoryPath, "ca.crt"), []byte("new-ca\n"))
+	writeTestFile(t, filepath.Join(next.EasyRsaKeyDirectoryPath, "crl.pem"), []byte("new-crl\n"))
+	// The next pki misses its server certificate
+
+	if _, err := o.RetirePki(time.Date(2026, 10, 19, 8, 0, 0, 0, time.UTC)); err == nil {
+		t.Fatal("got no error, wanted the missing server certificate reported")
+	}
+
+	for path, want := range map[string]string{o.CaPath: "old-ca\n", filepath.Join(next.EasyRsaKeyDirectoryPath, "ca.crt"): "new-ca\n",
+		o.ServerCaPath: "new-ca\nold-ca\n", o.CrlPath: "old-crl\nnew-crl\n"} {
+		if got := readTestFile(t, path); got != want {
+			t.Errorf("got %q in %s, wanted %q", got, path, want)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "pki-retired-20261019080000")); !os.IsNotExist(err) {
+		t.Errorf("got %v, wanted the retired pki moved back", err)
+	}
+}
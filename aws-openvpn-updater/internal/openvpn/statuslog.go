@@ -0,0 +1,140 @@
+package openvpn
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Layouts of the dates of the status file, ctime before openvpn 2.6.
+var statusTimeLayouts = []string{"Mon Jan _2 15:04:05 2006", "2006-01-02 15:04:05"}
+
+// ClientStatus is a connected client of the status file.
+type ClientStatus struct {
+	CommonName     string
+	RealAddress    string
+	BytesReceived  int64
+	BytesSent      int64
+	ConnectedSince time.Time
+}
+
+func (c ClientStatus) String() string {
+	return fmt.Sprintf("[ CommonName: %s, RealAddress: %s, ConnectedSince: %s ]", c.CommonName, c.RealAddress, c.ConnectedSince)
+}
+
+// StatusLog is the content of the openvpn status file.
+type StatusLog struct {
+	Version int
+	Updated time.Time
+	Clients []ClientStatus
+}
+
+// ParseStatusLog reads a status file of any version: version 1 lists the clients under a
+// "Common Name,..." header, versions 2 and 3 prefix each line with its kind and separate fields
+// with commas and tabs. Columns are found by name in the headers.
+func ParseStatusLog(r io.Reader) (*StatusLog, error) {
+	status := &StatusLog{}
+	var header map[string]int
+	clients := false
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if status.Version == 0 {
+			switch {
+			case strings.HasPrefix(line, "OpenVPN CLIENT LIST"):
+				status.Version = 1
+				continue
+			case strings.HasPrefix(line, "TITLE,"):
+				status.Version = 2
+			case strings.HasPrefix(line, "TITLE\t"):
+				status.Version = 3
+			default:
+				return nil, fmt.Errorf("unknown status file format: %q", line)
+			}
+		}
+		separator := ","
+		if status.Version == 3 {
+			separator = "\t"
+		}
+		fields := strings.Split(line, separator)
+
+		if status.Version == 1 {
+			switch {
+			case fields[0] == "Updated" && len(fields) > 1:
+				status.Updated, _ = parseStatusTime(fields[1])
+			case fields[0] == "Common Name":
+				header, clients = columns(fields), true
+			case fields[0] == "ROUTING TABLE" || fields[0] == "GLOBAL STATS" || fields[0] == "END":
+				clients = false
+			case clients:
+				status.Clients = append(status.Clients, clientStatus(header, fields))
+			}
+			continue
+		}
+		switch fields[0] {
+		case "TIME":
+			if len(fields) > 2 {
+				if unix, err := strconv.ParseInt(fields[2], 10, 64); err == nil {
+					status.Updated = time.Unix(unix, 0)
+				}
+			}
+		case "HEADER":
+			if len(fields) > 1 && fields[1] == "CLIENT_LIST" {
+				header = columns(fields[1:])
+			}
+		case "CLIENT_LIST":
+			status.Clients = append(status.Clients, clientStatus(header, fields))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if status.Version == 0 {
+		return nil, fmt.Errorf("empty status file")
+	}
+	return status, nil
+}
+
+// columns indexes the column names of a header.
+func columns(fields []string) map[string]int {
+	header := map[string]int{}
+	for i, name := range fields {
+		header[name] = i
+	}
+	return header
+}
+
+func clientStatus(header map[string]int, fields []string) ClientStatus {
+	field := func(name string) string {
+		i, ok := header[name]
+		if !ok || i >= len(fields) {
+			return ""
+		}
+		return fields[i]
+	}
+	client := ClientStatus{CommonName: field("Common Name"), RealAddress: field("Real Address")}
+	client.BytesReceived, _ = strconv.ParseInt(field("Bytes Received"), 10, 64)
+	client.BytesSent, _ = strconv.ParseInt(field("Bytes Sent"), 10, 64)
+	if unix, err := strconv.ParseInt(field("Connected Since (time_t)"), 10, 64); err == nil {
+		client.ConnectedSince = time.Unix(unix, 0)
+	} else {
+		client.ConnectedSince, _ = parseStatusTime(field("Connected Since"))
+	}
+	return client
+}
+
+// parseStatusTime reads the dates of version 1, written in the local time of the server.
+func parseStatusTime(value string) (time.Time, error) {
+	var err error
+	for _, layout := range statusTimeLayouts {
+		var t time.Time
+		t, err = time.ParseInLocation(layout, value, time.Local)
+		if err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, err
+}
@@ -0,0 +1,60 @@
+package openvpn
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+const statusLogV1 string = `OpenVPN CLIENT LIST
+Updated,2026-10-19 08:00:00
+Common Name,Real Address,Bytes Received,Bytes Sent,Connected Since
+john,192.0.2.1:51234,1234,5678,2026-10-19 07:00:00
+ROUTING TABLE
+Virtual Address,Common Name,Real Address,Last Ref
+10.8.0.2,john,192.0.2.1:51234,2026-10-19 07:59:00
+GLOBAL STATS
+Max bcast/mcast queue length,0
+END
+`
+
+const statusLogV2 string = `TITLE,OpenVPN 2.5.9 x86_64-pc-linux-gnu
+TIME,Mon Oct 19 08:00:00 2026,1792396800
+HEADER,CLIENT_LIST,Common Name,Real Address,Virtual Address,Virtual IPv6 Address,Bytes Received,Bytes Sent,Connected Since,Connected Since (time_t),Username,Client ID,Peer ID,Data Channel Cipher
+CLIENT_LIST,john,192.0.2.1:51234,10.8.0.2,,1234,5678,Mon Oct 19 07:00:00 2026,1792393200,UNDEF,0,0,AES-256-GCM
+HEADER,ROUTING_TABLE,Virtual Address,Common Name,Real Address,Last Ref,Last Ref (time_t)
+ROUTING_TABLE,10.8.0.2,john,192.0.2.1:51234,Mon Oct 19 07:59:00 2026,1792396740
+GLOBAL_STATS,Max bcast/mcast queue length,0
+END
+`
+
+func TestParseStatusLog(t *testing.T) {
+	tests := []struct {
+		content string
+		version int
+	}{
+		{statusLogV1, 1},
+		{statusLogV2, 2},
+		{strings.ReplaceAll(statusLogV2, ",", "\t"), 3},
+	}
+	for _, tt := range tests {
+		status, err := ParseStatusLog(strings.NewReader(tt.content))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status.Version != tt.version || status.Updated.IsZero() {
+			t.Errorf("got version %d updated %s, wanted version %d", status.Version, status.Updated, tt.version)
+		}
+		if len(status.Clients) != 1 {
+			t.Fatalf("version %d: got %v, wanted one client", tt.version, status.Clients)
+		}
+		client := status.Clients[0]
+		if client.CommonName != "john" || client.RealAddress != "192.0.2.1:51234" || client.BytesReceived != 1234 ||
+			client.BytesSent != 5678 || status.Updated.Sub(client.ConnectedSince) != time.Hour {
+			t.Errorf("version %d: got %+v updated %s", tt.version, client, status.Updated)
+		}
+	}
+	if _, err := ParseStatusLog(strings.NewReader("garbage\n")); err == nil {
+		t.Error("got no error, wanted an unknown format")
+	}
+}
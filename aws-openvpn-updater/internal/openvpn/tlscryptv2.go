@@ -0,0 +1,164 @@
+package openvpn
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// ErrTlsCryptV2Refused is returned for client keys without metadata or with revoked metadata.
+var ErrTlsCryptV2Refused = errors.New("tls-crypt-v2 client key refused")
+
+// tlsCryptV2MetadataUser is the metadata_type openvpn passes for keys generated with user metadata.
+const tlsCryptV2MetadataUser string = "0"
+
+// tlsCryptV2Directory holds the client keys in the pki, so that they follow it through a ca rotation.
+const tlsCryptV2Directory string = "tls-crypt-v2"
+
+// clientTlsCryptPath returns the tls-crypt key embedded in the profile of the user, the shared
+// server key or the tls-crypt-v2 key of the user.
+func (o *OpenVpnConfig) clientTlsCryptPath(user string) string {
+	if !o.TlsCryptV2 {
+		return o.ClientTlsCryptPath
+	}
+	return o.tlsCryptV2KeyPath(user)
+}
+
+// profileTlsCryptPath returns the server side tls-crypt input of every profile.
+func (o *OpenVpnConfig) profileTlsCryptPath() string {
+	if !o.TlsCryptV2 {
+		return o.ClientTlsCryptPath
+	}
+	return o.ServerTlsCryptV2Path
+}
+
+func (o *OpenVpnConfig) tlsCryptV2KeyPath(user string) string {
+	return fmt.Sprintf("%s/%s/%s.key", o.EasyRsaKeyDirectoryPath, tlsCryptV2Directory, user)
+}
+
+func (o *OpenVpnConfig) tlsCryptV2MetadataPath(user string) string {
+	return fmt.Sprintf("%s/%s/%s.metadata", o.EasyRsaKeyDirectoryPath, tlsCryptV2Directory, user)
+}
+
+// tlsCryptV2Metadata identifies a client key to the server, unique per key so that a user added
+// again after a revocation is not refused.
+func tlsCryptV2Metadata(user string, now time.Time) string {
+	return fmt.Sprintf("%s/%d", user, now.Unix())
+}
+
+// ensureTlsCryptV2Key generates the tls-crypt-v2 key of the user from the server key unless it exists.
+func (o *OpenVpnConfig) ensureTlsCryptV2Key(ctx context.Context, user string) error {
+	if !o.TlsCryptV2 {
+		return nil
+	}
+	if _, err := os.Stat(o.tlsCryptV2KeyPath(user)); err == nil {
+		return nil
+	}
+	err := os.MkdirAll(fmt.Sprintf("%s/%s", o.EasyRsaKeyDirectoryPath, tlsCryptV2Directory), 0700)
+	if err != nil {
+		return err
+	}
+	metadata := tlsCryptV2Metadata(user, time.Now())
+	err = cmdNewTlsCryptV2Key(ctx, o.ServerTlsCryptV2Path, o.tlsCryptV2KeyPath(user),
+		base64.StdEncoding.EncodeToString([]byte(metadata)))
+	if err != nil {
+		return err
+	}
+	log.Debug().Msgf("Tls-crypt-v2 key generated for user: %s", user)
+	return os.WriteFile(o.tlsCryptV2MetadataPath(user), []byte(metadata+"\n"), 0600)
+}
+
+// RemoveTlsCryptV2Key removes the tls-crypt-v2 key of the user so that the next profile gets one
+// wrapped by the current server key.
+func (o *OpenVpnConfig) RemoveTlsCryptV2Key(user string) error {
+	for _, fileName := range []string{o.tlsCryptV2KeyPath(user), o.tlsCryptV2MetadataPath(user)} {
+		err := os.Remove(fileName)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// retireTlsCryptV2Key adds the metadata of the key of the user to the revoked list read by the
+// tls-crypt-v2-verify command, then removes the key.
+func (o *OpenVpnConfig) retireTlsCryptV2Key(user string) error {
+	metadata, err := os.ReadFile(o.tlsCryptV2MetadataPath(user))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	err = o.appendTlsCryptV2Revoked(strings.TrimSpace(string(metadata)))
+	if err != nil {
+		return err
+	}
+	log.Debug().Msgf("Tls-crypt-v2 key retired for user: %s", user)
+	return o.RemoveTlsCryptV2Key(user)
+}
+
+// retireTlsCryptV2Keys adds the metadata of every client key of a retired pki to the revoked list.
+func (o *OpenVpnConfig) retireTlsCryptV2Keys(pkiPath string) error {
+	files, err := filepath.Glob(fmt.Sprintf("%s/%s/*.metadata", pkiPath, tlsCryptV2Directory))
+	if err != nil {
+		return err
+	}
+	var metadata []string
+	for _, file := range files {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		metadata = append(metadata, strings.TrimSpace(string(content)))
+	}
+	return o.appendTlsCryptV2Revoked(metadata...)
+}
+
+func (o *OpenVpnConfig) appendTlsCryptV2Revoked(metadata ...string) error {
+	if len(metadata) == 0 {
+		return nil
+	}
+	revoked, err := os.OpenFile(o.TlsCryptV2RevokedPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = revoked.WriteString(strings.Join(metadata, "\n") + "\n")
+	if closeErr := revoked.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// VerifyTlsCryptV2 checks the client key metadata openvpn passes to its tls-crypt-v2-verify command in
+// metadata_type and metadata_file. Keys without user metadata were not generated by the updater.
+func VerifyTlsCryptV2(revokedPath string, metadataType string, metadataFile string) error {
+	if metadataType != tlsCryptV2MetadataUser {
+		return fmt.Errorf("%w: no metadata", ErrTlsCryptV2Refused)
+	}
+	content, err := os.ReadFile(metadataFile)
+	if err != nil {
+		return err
+	}
+	metadata := strings.TrimSpace(string(content))
+	revoked, err := os.ReadFile(revokedPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, line := range strings.Split(string(revoked), "\n") {
+		if strings.TrimSpace(line) == metadata {
+			return fmt.Errorf("%w: %s revoked", ErrTlsCryptV2Refused, metadata)
+		}
+	}
+	return nil
+}
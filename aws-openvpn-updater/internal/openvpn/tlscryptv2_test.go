@@ -0,0 +1,106 @@
+package openvpn
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeOpenVpn stands in for openvpn --genkey tls-crypt-v2-client, writing the metadata in the key.
+// It refuses relative key paths so that nothing is written outside of the test directories.
+const fakeOpenVpn string = `#!/bin/bash
+while [ $# -gt 0 ]; do
+	if [ "$1" = "--genkey" ]; then
+		key="$3"
+		metadata="$4"
+		shift 3
+	fi
+	shift
+done
+case "$key" in
+/*) ;;
+*) echo "relative key path: $key" >&2; exit 1 ;;
+esac
+echo "-----BEGIN OpenVPN tls-crypt-v2 client key-----" > "$key"
+echo "$metadata" >> "$key"
+echo "-----END OpenVPN tls-crypt-v2 client key-----" >> "$key"
+`
+
+func TestTlsCryptV2(t *testing.T) {
+	o := testProfileTree(t)
+	bin := t.TempDir()
+	writeTestFile(t, filepath.Join(bin, "openvpn"), []byte(fakeOpenVpn))
+	if err := os.Chmod(filepath.Join(bin, "openvpn"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	o.TlsCryptV2 = true
+	writeTestFile(t, o.ServerTlsCryptV2Path, []byte("-----BEGIN OpenVPN tls-crypt-v2 server key-----\nabcd\n-----END OpenVPN tls-crypt-v2 server key-----\n"))
+
+	path, err := o.WriteProfile(context.Background(), "john", Profile{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	metadata := strings.TrimSpace(readTestFile(t, o.tlsCryptV2MetadataPath("john")))
+	if !strings.HasPrefix(metadata, "john/") {
+		t.Errorf("got metadata %q, wanted the user and a timestamp", metadata)
+	}
+	config := readTestFile(t, path)
+	encoded := base64.StdEncoding.EncodeToString([]byte(metadata))
+	if !strings.Contains(config, "<tls-crypt-v2>\n-----BEGIN OpenVPN tls-crypt-v2 client key-----\n"+encoded+"\n") ||
+		strings.Contains(config, "<tls-crypt>") {
+		t.Errorf("got config %q, wanted the client key of the user", config)
+	}
+
+	if err = o.retireTlsCryptV2Key("john"); err != nil {
+		t.Fatal(err)
+	}
+	if got := readTestFile(t, o.TlsCryptV2RevokedPath); got != metadata+"\n" {
+		t.Errorf("got revoked list %q, wanted %q", got, metadata)
+	}
+	if _, err = os.Stat(o.tlsCryptV2KeyPath("john")); !os.IsNotExist(err) {
+		t.Errorf("got %v, wanted the client key removed", err)
+	}
+	if err = o.retireTlsCryptV2Key("john"); err != nil {
+		t.Errorf("got %v, wanted no error without key", err)
+	}
+}
+
+func TestVerifyTlsCryptV2(t *testing.T) {
+	dir := t.TempDir()
+	revoked := filepath.Join(dir, "tc-v2-revoked.txt")
+	metadata := filepath.Join(dir, "metadata")
+	writeTestFile(t, metadata, []byte("john/1760860800"))
+
+	if err := VerifyTlsCryptV2(revoked, "0", metadata); err != nil {
+		t.Errorf("got %v, wanted the key allowed without revoked list", err)
+	}
+	writeTestFile(t, revoked, []byte("jane/1760860800\njohn/1760860800\n"))
+	if err := VerifyTlsCryptV2(revoked, "0", metadata); !errors.Is(err, ErrTlsCryptV2Refused) {
+		t.Errorf("got %v, wanted the revoked key refused", err)
+	}
+	writeTestFile(t, metadata, []byte("john/1760947200"))
+	if err := VerifyTlsCryptV2(revoked, "0", metadata); err != nil {
+		t.Errorf("got %v, wanted the new key of the user allowed", err)
+	}
+	if err := VerifyTlsCryptV2(revoked, "1", metadata); !errors.Is(err, ErrTlsCryptV2Refused) {
+		t.Errorf("got %v, wanted a key without metadata refused", err)
+	}
+}
+
+func TestTlsCryptShared(t *testing.T) {
+	o := testProfileTree(t)
+
+	path, err := o.WriteProfile(context.Background(), "john", Profile{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := readTestFile(t, path)
+	if !strings.HasSuffix(config, "<tls-crypt>\n-----BEGIN OpenVPN Static key V1-----\nabcd\n-----END OpenVPN Static key V1-----\n</tls-crypt>\n") {
+		t.Errorf("got config %q, wanted the shared key", config)
+	}
+}
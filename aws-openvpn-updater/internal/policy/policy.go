@@ -0,0 +1,201 @@
+package policy
+
+import (
+	"fmt"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/FinalCAD/vpn-stack/aws-openvpn-updater/internal/settings"
+)
+
+// IAM user tags read by the updater.
+const (
+	EmailTag        string = "email"
+	ValidityDaysTag string = "vpn-validity-days"
+	FormatTag       string = "vpn-format"
+	RoutesTag       string = "vpn-routes"
+	StaticIpTag     string = "vpn-static-ip"
+	LocaleTag       string = "vpn-locale"
+	DisabledTag     string = "vpn-disabled"
+	KeepTag         string = "vpn-keep"
+	ExpiresTag      string = "vpn-expires"
+)
+
+// MaxValidityDays bounds vpn-validity-days to the lifetime of the ca.
+const MaxValidityDays int = 3650
+
+// RenewalDays is the remaining lifetime under which the certificates of vpn-validity-days are issued again,
+// a third of their lifetime when shorter.
+const RenewalDays int = 7
+
+const day = 24 * time.Hour
+
+// Locales are the languages of the mails sent to users.
+var Locales = []string{"en", "fr"}
+
+// Policy is how a member of the vpn group is served, read from its IAM user tags. Zero values keep the settings.
+type Policy struct {
+	Email string
+	// ValidityDays is the lifetime of the certificates issued to the user
+	ValidityDays int
+	// Format is one of settings.ProfileFormats
+	Format string
+	// Routes are pushed to the client and StaticIp assigned to it through the client-config-dir
+	Routes   []netip.Prefix
+	StaticIp netip.Prefix
+	Locale   string
+	// Disabled users are handled as if they left the vpn group
+	Disabled bool
+	// Keep exempts the user from the dormant policy
+	Keep bool
+	// Expires ends the access of the user, zero without end
+	Expires time.Time
+}
+
+func (p Policy) String() string {
+	return fmt.Sprintf("[ Email: %v, ValidityDays: %v, Format: %v, Routes: %v, StaticIp: %v, Locale: %v, Disabled: %v, Keep: %v, Expires: %v ]",
+		p.Email, p.ValidityDays, p.Format, p.Routes, p.StaticIp, p.Locale, p.Disabled, p.Keep, p.Expires)
+}
+
+// Expired returns whether the access of the user ended at now.
+func (p Policy) Expired(now time.Time) bool {
+	return !p.Expires.IsZero() && !now.Before(p.Expires)
+}
+
+// CertificateDays returns the lifetime of a certificate issued at now, ending on the last day of access
+// when it comes before ValidityDays, at least a day. Zero keeps the default lifetime.
+func (p Policy) CertificateDays(now time.Time) int {
+	if p.Expires.IsZero() {
+		return p.ValidityDays
+	}
+	days := int(p.Expires.Sub(now) / day)
+	if days < 1 {
+		days = 1
+	}
+	if days > MaxValidityDays {
+		days = MaxValidityDays
+	}
+	if p.ValidityDays != 0 && p.ValidityDays < days {
+		return p.ValidityDays
+	}
+	return days
+}
+
+// Renew returns whether the certificate issued at issued and expiring at expiry is issued again at now: the
+// certificates of vpn-validity-days close to their expiry, unless they end with the access of the user, and
+// those ended by an access extended since their issue.
+func (p Policy) Renew(issued time.Time, expiry time.Time, now time.Time) bool {
+	if p.Expired(now) {
+		return false
+	}
+	if p.extended(issued, expiry) {
+		return true
+	}
+	if p.ValidityDays == 0 {
+		return false
+	}
+	if !p.Expires.IsZero() && p.Expires.Sub(expiry) < day {
+		return false
+	}
+	window := time.Duration(RenewalDays) * day
+	if third := expiry.Sub(issued) / 3; !issued.IsZero() && third < window {
+		window = third
+	}
+	return expiry.Sub(now) <= window
+}
+
+// extended returns whether the certificate was issued shorter than its lifetime, ie ending with the access,
+// and the access now ends at least a day after it.
+func (p Policy) extended(issued time.Time, expiry time.Time) bool {
+	if issued.IsZero() {
+		return false
+	}
+	if p.Expires.IsZero() && p.ValidityDays != 0 {
+		// Renewed at its expiry
+		return false
+	}
+	if !p.Expires.IsZero() && p.Expires.Sub(expiry) < day {
+		return false
+	}
+	lifetime := p.ValidityDays
+	if lifetime == 0 {
+		lifetime = MaxValidityDays
+	}
+	return expiry.Sub(issued) < time.Duration(lifetime-1)*day
+}
+
+// HasClientConfig returns whether the user needs a file in the client-config-dir.
+func (p Policy) HasClientConfig() bool {
+	return len(p.Routes) > 0 || p.StaticIp.IsValid()
+}
+
+// Parse reads the policy from the tags of a user. Invalid tags are ignored and reported as errors.
+func Parse(tags map[string]string) (Policy, []error) {
+	var errs []error
+	invalid := func(tag string, value string, reason string) {
+		errs = append(errs, fmt.Errorf("invalid %s tag %q: %s", tag, value, reason))
+	}
+	p := Policy{Email: tags[EmailTag]}
+
+	if value, ok := tags[ValidityDaysTag]; ok {
+		days, err := strconv.Atoi(value)
+		if err != nil || days < 1 || days > MaxValidityDays {
+			invalid(ValidityDaysTag, value, fmt.Sprintf("expected days between 1 and %d", MaxValidityDays))
+		} else {
+			p.ValidityDays = days
+		}
+	}
+	if value, ok := tags[FormatTag]; ok {
+		if settings.IsProfileFormat(value) {
+			p.Format = value
+		} else {
+			invalid(FormatTag, value, fmt.Sprintf("expected one of %v", settings.ProfileFormats))
+		}
+	}
+	// Commas are not allowed in tag values
+	for _, value := range strings.Fields(tags[RoutesTag]) {
+		route, err := netip.ParsePrefix(value)
+		if err != nil || !route.Addr().Is4() || route != route.Masked() {
+			invalid(RoutesTag, value, "expected ipv4 networks separated by spaces, ie 10.0.0.0/16")
+			continue
+		}
+		p.Routes = append(p.Routes, route)
+	}
+	if value, ok := tags[StaticIpTag]; ok {
+		address, err := netip.ParsePrefix(value)
+		if err != nil || !address.Addr().Is4() || address.Bits() > 30 || address == address.Masked() {
+			invalid(StaticIpTag, value, "expected an ipv4 address with the prefix length of the vpn network, ie 10.8.0.50/24")
+		} else {
+			p.StaticIp = address
+		}
+	}
+	if value, ok := tags[LocaleTag]; ok {
+		if contains(Locales, value) {
+			p.Locale = value
+		} else {
+			invalid(LocaleTag, value, fmt.Sprintf("expected one of %v", Locales))
+		}
+	}
+	if value, ok := tags[ExpiresTag]; ok {
+		expires, err := settings.ParseExpiry(value)
+		if err != nil {
+			invalid(ExpiresTag, value, "expected the last day of access, ie 2026-12-31")
+		} else {
+			p.Expires = expires
+		}
+	}
+	p.Disabled = tags[DisabledTag] == "true"
+	p.Keep = tags[KeepTag] == "true"
+	return p, errs
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
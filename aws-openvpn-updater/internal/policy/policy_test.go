@@ -0,0 +1,125 @@
+package policy
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	p, errs := Parse(map[string]string{
+		EmailTag:        "john@example.com",
+		ValidityDaysTag: "90",
+		FormatTag:       "p12",
+		RoutesTag:       "10.0.0.0/16  10.1.2.0/24",
+		StaticIpTag:     "10.8.0.50/24",
+		LocaleTag:       "fr",
+		DisabledTag:     "true",
+		KeepTag:         "false",
+	})
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	if p.Email != "john@example.com" || p.ValidityDays != 90 || p.Format != "p12" || p.Locale != "fr" || !p.Disabled || p.Keep {
+		t.Errorf("got %v", p)
+	}
+	if len(p.Routes) != 2 || p.Routes[1] != netip.MustParsePrefix("10.1.2.0/24") || p.StaticIp != netip.MustParsePrefix("10.8.0.50/24") {
+		t.Errorf("got routes %v and static ip %v", p.Routes, p.StaticIp)
+	}
+	if !p.HasClientConfig() {
+		t.Error("got no client config, wanted one for routes and static ip")
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	tests := []map[string]string{
+		{ValidityDaysTag: "0"},
+		{ValidityDaysTag: "3651"},
+		{ValidityDaysTag: "soon"},
+		{FormatTag: "pem"},
+		{RoutesTag: "10.0.0.1/16"},
+		{RoutesTag: "10.0.0.0/16,10.1.0.0/16"},
+		{StaticIpTag: "10.8.0.50"},
+		{StaticIpTag: "10.8.0.0/24"},
+		{LocaleTag: "de"},
+	}
+	for _, tags := range tests {
+		p, errs := Parse(tags)
+		if len(errs) != 1 {
+			t.Errorf("got errors %v for %v, wanted one", errs, tags)
+		}
+		if p.ValidityDays != 0 || p.Format != "" || len(p.Routes) != 0 || p.StaticIp.IsValid() || p.Locale != "" {
+			t.Errorf("got %v for %v, wanted the invalid tag ignored", p, tags)
+		}
+	}
+	if p, errs := Parse(nil); len(errs) > 0 || p.HasClientConfig() || p.Disabled {
+		t.Errorf("got %v %v without tags, wanted the defaults", p, errs)
+	}
+}
+
+func TestExpires(t *testing.T) {
+	p, errs := Parse(map[string]string{ExpiresTag: "2026-12-31"})
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	end := time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)
+	if !p.Expires.Equal(end) || p.Expired(end.Add(-time.Second)) || !p.Expired(end) {
+		t.Errorf("got expires %s, wanted the end of 2026-12-31", p.Expires)
+	}
+
+	now := time.Date(2026, 10, 19, 8, 0, 0, 0, time.UTC)
+	tests := []struct {
+		policy Policy
+		now    time.Time
+		want   int
+	}{
+		{Policy{}, now, 0},
+		{Policy{ValidityDays: 90}, now, 90},
+		// Issued on the 19th at 08:00, the certificate ends on 2026-12-31 at 08:00
+		{Policy{Expires: end}, now, 73},
+		{Policy{Expires: end, ValidityDays: 30}, now, 30},
+		{Policy{Expires: end}, end.Add(-time.Hour), 1},
+		{Policy{Expires: end.AddDate(20, 0, 0)}, now, MaxValidityDays},
+	}
+	for _, tt := range tests {
+		if got := tt.policy.CertificateDays(tt.now); got != tt.want {
+			t.Errorf("got %d days for %v, wanted %d", got, tt.policy, tt.want)
+		}
+	}
+	if _, errs = Parse(map[string]string{ExpiresTag: "31/12/2026"}); len(errs) != 1 {
+		t.Errorf("got %v, wanted an invalid date", errs)
+	}
+}
+
+func TestRenew(t *testing.T) {
+	issued := time.Date(2026, 10, 1, 8, 0, 0, 0, time.UTC)
+	end := time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		policy Policy
+		expiry time.Time
+		now    time.Time
+		want   bool
+	}{
+		{"default lifetime", Policy{}, issued.AddDate(0, 0, 3650), issued.AddDate(0, 0, 3649), false},
+		{"far from expiry", Policy{ValidityDays: 30}, issued.AddDate(0, 0, 30), issued.AddDate(0, 0, 22), false},
+		{"within renewal days", Policy{ValidityDays: 30}, issued.AddDate(0, 0, 30), issued.AddDate(0, 0, 23), true},
+		{"expired", Policy{ValidityDays: 30}, issued.AddDate(0, 0, 30), issued.AddDate(0, 0, 31), true},
+		{"short lifetime", Policy{ValidityDays: 3}, issued.AddDate(0, 0, 3), issued.AddDate(0, 0, 1), false},
+		{"last third of short lifetime", Policy{ValidityDays: 3}, issued.AddDate(0, 0, 3), issued.AddDate(0, 0, 2), true},
+		{"ends with the access", Policy{ValidityDays: 120, Expires: end}, end.Add(-16 * time.Hour), end.AddDate(0, 0, -2), false},
+		{"access ended", Policy{ValidityDays: 30, Expires: end}, end.AddDate(0, 0, -10), end, false},
+		{"ends before the access", Policy{ValidityDays: 30, Expires: end}, issued.AddDate(0, 0, 30), issued.AddDate(0, 0, 25), true},
+		// Issued on 2026-10-01 at 08:00 for an access ending on 2026-11-30
+		{"access unchanged", Policy{Expires: end.AddDate(0, -1, 0)}, end.AddDate(0, -1, 0).Add(-16 * time.Hour), issued.AddDate(0, 0, 1), false},
+		{"access extended", Policy{Expires: end}, end.AddDate(0, -1, 0).Add(-16 * time.Hour), issued.AddDate(0, 0, 1), true},
+		{"access tag removed", Policy{}, end.AddDate(0, -1, 0).Add(-16 * time.Hour), issued.AddDate(0, 0, 1), true},
+		{"access extended within a day", Policy{Expires: end.AddDate(0, -1, 0).Add(4 * time.Hour)}, end.AddDate(0, -1, 0).Add(-16 * time.Hour), issued.AddDate(0, 0, 1), false},
+		{"validity shorter than the access", Policy{ValidityDays: 30, Expires: end}, issued.AddDate(0, 0, 30), issued.AddDate(0, 0, 1), false},
+	}
+	for _, tt := range tests {
+		if got := tt.policy.Renew(issued, tt.expiry, tt.now); got != tt.want {
+			t.Errorf("%s: got %v, wanted %v", tt.name, got, tt.want)
+		}
+	}
+}
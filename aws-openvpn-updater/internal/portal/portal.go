@@ -0,0 +1,272 @@
+package portal
+
+import (
+	"context"
+	"crypto/rand"
+	_ "embed"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/oauth2"
+)
+
+const (
+	Path         string = "/portal/"
+	CallbackPath string = Path + "callback"
+)
+
+//go:embed portal.tmpl
+var templatePortal string
+
+var pageTemplate = template.Must(template.New("portalTemplate").Parse(templatePortal))
+
+// ErrUnknownUser is returned by Profiles when the authenticated user is not a vpn user.
+var ErrUnknownUser = errors.New("unknown vpn user")
+
+// Profiles gives access to the profile of the vpn user an identity maps to.
+type Profiles interface {
+	// LookupUser returns the vpn user whose claim has value
+	LookupUser(ctx context.Context, claim string, value string) (string, error)
+	// DownloadProfile returns a presigned url to a freshly uploaded profile
+	DownloadProfile(ctx context.Context, user string) (string, error)
+	// ReissueProfile revokes the certificate of the user, issues a new one and returns a presigned url to it
+	ReissueProfile(ctx context.Context, user string) (string, error)
+}
+
+type Config struct {
+	Environment  string
+	Issuer       string
+	ClientId     string
+	ClientSecret string
+	RedirectUrl  string
+	UserClaim    string
+	SessionTtl   time.Duration
+}
+
+func (c Config) String() string {
+	return fmt.Sprintf("[ Issuer: %v, ClientId: %v, RedirectUrl: %v, UserClaim: %v, SessionTtl: %v ]",
+		c.Issuer, c.ClientId, c.RedirectUrl, c.UserClaim, c.SessionTtl)
+}
+
+// Portal lets users authenticated by an OIDC provider download or reissue their own profile.
+type Portal struct {
+	config   Config
+	profiles Profiles
+	key      []byte
+	mutex    sync.Mutex
+	oauth2   *oauth2.Config
+	verifier *oidc.IDTokenVerifier
+}
+
+type page struct {
+	Environment string
+	User        string
+	Csrf        string
+	Message     string
+	Link        string
+}
+
+// CreatePortal returns a portal signing its cookies with a random key, sessions do not survive a restart.
+func CreatePortal(config Config, profiles Profiles) (*Portal, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return &Portal{config: config, profiles: profiles, key: key}, nil
+}
+
+// provider discovers the OIDC provider on first use, so the portal starts even if it is unreachable.
+func (p *Portal) provider(ctx context.Context) (*oauth2.Config, *oidc.IDTokenVerifier, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.oauth2 != nil {
+		return p.oauth2, p.verifier, nil
+	}
+	provider, err := oidc.NewProvider(ctx, p.config.Issuer)
+	if err != nil {
+		return nil, nil, err
+	}
+	p.oauth2 = &oauth2.Config{
+		ClientID:     p.config.ClientId,
+		ClientSecret: p.config.ClientSecret,
+		RedirectURL:  p.config.RedirectUrl,
+		Endpoint:     provider.Endpoint(),
+		Scopes:       []string{oidc.ScopeOpenID, "email", "profile"},
+	}
+	p.verifier = provider.Verifier(&oidc.Config{ClientID: p.config.ClientId})
+	return p.oauth2, p.verifier, nil
+}
+
+func (p *Portal) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(Path, p.index)
+	mux.HandleFunc(CallbackPath, p.callback)
+	mux.HandleFunc(Path+"download", p.download)
+	mux.HandleFunc(Path+"reissue", p.reissue)
+	return mux
+}
+
+func (p *Portal) index(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != Path {
+		http.NotFound(w, r)
+		return
+	}
+	s := p.currentSession(r)
+	if s == nil {
+		p.login(w, r)
+		return
+	}
+	p.render(w, s, "", "")
+}
+
+func (p *Portal) login(w http.ResponseWriter, r *http.Request) {
+	oauth2Config, _, err := p.provider(r.Context())
+	if err != nil {
+		p.fail(w, err, "Error contacting the identity provider")
+		return
+	}
+	state, err := randomString()
+	if err != nil {
+		p.fail(w, err, "Error starting authentication")
+		return
+	}
+	nonce, err := randomString()
+	if err != nil {
+		p.fail(w, err, "Error starting authentication")
+		return
+	}
+	expires := time.Now().Add(authTtl)
+	if err = p.setSigned(w, authCookie, authRequest{State: state, Nonce: nonce, Expires: expires}, expires); err != nil {
+		p.fail(w, err, "Error starting authentication")
+		return
+	}
+	http.Redirect(w, r, oauth2Config.AuthCodeURL(state, oidc.Nonce(nonce)), http.StatusFound)
+}
+
+func (p *Portal) callback(w http.ResponseWriter, r *http.Request) {
+	var auth authRequest
+	if err := p.getSigned(r, authCookie, &auth); err != nil || time.Now().After(auth.Expires) ||
+		r.URL.Query().Get("state") != auth.State {
+		http.Error(w, "Invalid authentication request, please retry.", http.StatusBadRequest)
+		return
+	}
+	clearCookie(w, authCookie)
+	oauth2Config, verifier, err := p.provider(r.Context())
+	if err != nil {
+		p.fail(w, err, "Error contacting the identity provider")
+		return
+	}
+	token, err := oauth2Config.Exchange(r.Context(), r.URL.Query().Get("code"))
+	if err != nil {
+		p.fail(w, err, "Error authenticating")
+		return
+	}
+	rawIdToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		p.fail(w, errors.New("no id_token in token response"), "Error authenticating")
+		return
+	}
+	idToken, err := verifier.Verify(r.Context(), rawIdToken)
+	if err != nil || idToken.Nonce != auth.Nonce {
+		p.fail(w, err, "Error authenticating")
+		return
+	}
+	var claims map[string]interface{}
+	if err = idToken.Claims(&claims); err != nil {
+		p.fail(w, err, "Error authenticating")
+		return
+	}
+	value, _ := claims[p.config.UserClaim].(string)
+	if verified, ok := claims["email_verified"].(bool); p.config.UserClaim == "email" && ok && !verified {
+		value = ""
+	}
+	if value == "" {
+		http.Error(w, fmt.Sprintf("Your identity has no verified %s.", p.config.UserClaim), http.StatusForbidden)
+		return
+	}
+
+	user, err := p.profiles.LookupUser(r.Context(), p.config.UserClaim, value)
+	if errors.Is(err, ErrUnknownUser) {
+		log.Warn().Msgf("Portal: refused identity %s=%s, not a vpn user", p.config.UserClaim, value)
+		http.Error(w, "You are not allowed to use the VPN.", http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		p.fail(w, err, "Error looking up your VPN account")
+		return
+	}
+	log.Info().Msgf("Portal: %s=%s signed in as %s", p.config.UserClaim, value, user)
+	expires := time.Now().Add(p.config.SessionTtl)
+	if err = p.setSigned(w, sessionCookie, session{User: user, Expires: expires}, expires); err != nil {
+		p.fail(w, err, "Error creating session")
+		return
+	}
+	http.Redirect(w, r, Path, http.StatusFound)
+}
+
+// action checks the session and csrf token of a form submission.
+func (p *Portal) action(w http.ResponseWriter, r *http.Request) *session {
+	if r.Method != http.MethodPost {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return nil
+	}
+	s := p.currentSession(r)
+	if s == nil {
+		http.Redirect(w, r, Path, http.StatusSeeOther)
+		return nil
+	}
+	if r.PostFormValue("csrf") != p.csrfToken(s) {
+		http.Error(w, "Invalid form, please reload the page.", http.StatusForbidden)
+		return nil
+	}
+	return s
+}
+
+func (p *Portal) download(w http.ResponseWriter, r *http.Request) {
+	s := p.action(w, r)
+	if s == nil {
+		return
+	}
+	url, err := p.profiles.DownloadProfile(r.Context(), s.User)
+	if err != nil {
+		p.fail(w, err, "Error preparing your profile")
+		return
+	}
+	log.Info().Msgf("Portal: profile downloaded by %s", s.User)
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, url, http.StatusSeeOther)
+}
+
+func (p *Portal) reissue(w http.ResponseWriter, r *http.Request) {
+	s := p.action(w, r)
+	if s == nil {
+		return
+	}
+	url, err := p.profiles.ReissueProfile(r.Context(), s.User)
+	if err != nil {
+		p.fail(w, err, "Error reissuing your profile")
+		return
+	}
+	log.Info().Msgf("Portal: profile reissued by %s", s.User)
+	p.render(w, s, "Your profile has been reissued, the previous one is revoked.", url)
+}
+
+func (p *Portal) render(w http.ResponseWriter, s *session, message string, link string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	err := pageTemplate.Execute(w, page{Environment: p.config.Environment, User: s.User, Csrf: p.csrfToken(s), Message: message, Link: link})
+	if err != nil {
+		log.Error().Err(err).Msg("Portal: error rendering page")
+	}
+}
+
+func (p *Portal) fail(w http.ResponseWriter, err error, message string) {
+	log.Error().Err(err).Msgf("Portal: %s", message)
+	http.Error(w, message, http.StatusInternalServerError)
+}
@@ -0,0 +1,193 @@
+package portal
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// mockIdp is a minimal OIDC provider issuing RS256 id tokens for a fixed email.
+type mockIdp struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+	email  string
+	nonces map[string]string
+}
+
+func newMockIdp(t *testing.T, email string) *mockIdp {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp := &mockIdp{key: key, email: email, nonces: map[string]string{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"issuer":                                idp.server.URL,
+			"authorization_endpoint":                idp.server.URL + "/authorize",
+			"token_endpoint":                        idp.server.URL + "/token",
+			"jwks_uri":                              idp.server.URL + "/jwks",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kty": "RSA", "kid": "test", "alg": "RS256", "use": "sig",
+			"n": base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e": base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/authorize", func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		code := "code-" + query.Get("state")
+		idp.nonces[code] = query.Get("nonce")
+		http.Redirect(w, r, query.Get("redirect_uri")+"?code="+url.QueryEscape(code)+"&state="+url.QueryEscape(query.Get("state")), http.StatusFound)
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		nonce, ok := idp.nonces[r.PostForm.Get("code")]
+		if !ok {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": "access", "token_type": "Bearer", "expires_in": 3600,
+			"id_token": idp.idToken(t, nonce),
+		})
+	})
+	idp.server = httptest.NewServer(mux)
+	return idp
+}
+
+func (idp *mockIdp) idToken(t *testing.T, nonce string) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "test", "typ": "JWT"})
+	payload, _ := json.Marshal(map[string]interface{}{
+		"iss": idp.server.URL, "sub": "1234", "aud": "vpn-portal", "nonce": nonce,
+		"iat": time.Now().Unix(), "exp": time.Now().Add(time.Hour).Unix(),
+		"email": idp.email, "email_verified": true,
+	})
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, idp.key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+type fakeProfiles struct {
+	downloads int
+}
+
+func (f *fakeProfiles) LookupUser(ctx context.Context, claim string, value string) (string, error) {
+	if claim == "email" && value == "john.doe@example.com" {
+		return "johndoe", nil
+	}
+	return "", ErrUnknownUser
+}
+
+func (f *fakeProfiles) DownloadProfile(ctx context.Context, user string) (string, error) {
+	f.downloads++
+	return "https://s3.example.com/sandbox/" + user + ".ovpn", nil
+}
+
+func (f *fakeProfiles) ReissueProfile(ctx context.Context, user string) (string, error) {
+	return "https://s3.example.com/sandbox/" + user + ".ovpn", nil
+}
+
+func startPortal(t *testing.T, idp *mockIdp, profiles Profiles) (*httptest.Server, *http.Client) {
+	var portal *Portal
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		portal.Handler().ServeHTTP(w, r)
+	}))
+	var err error
+	portal, err = CreatePortal(Config{
+		Environment: "sandbox", Issuer: idp.server.URL, ClientId: "vpn-portal", ClientSecret: "secret",
+		RedirectUrl: server.URL + CallbackPath, UserClaim: "email", SessionTtl: time.Hour,
+	}, profiles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jar, _ := cookiejar.New(nil)
+	client := &http.Client{Jar: jar, CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if req.URL.Host == "s3.example.com" {
+			return http.ErrUseLastResponse
+		}
+		return nil
+	}}
+	return server, client
+}
+
+func TestPortalDownload(t *testing.T) {
+	idp := newMockIdp(t, "john.doe@example.com")
+	defer idp.server.Close()
+	profiles := &fakeProfiles{}
+	server, client := startPortal(t, idp, profiles)
+	defer server.Close()
+
+	resp, err := client.Get(server.URL + Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(body), "johndoe") {
+		t.Fatalf("got %d %s, wanted the portal page of johndoe", resp.StatusCode, body)
+	}
+	csrf := regexp.MustCompile(`name="csrf" value="([0-9a-f]+)"`).FindStringSubmatch(string(body))
+	if csrf == nil {
+		t.Fatal("no csrf token in page")
+	}
+
+	resp, err = client.PostForm(server.URL+Path+"download", url.Values{"csrf": {"forged"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("got %d with a forged csrf token, wanted 403", resp.StatusCode)
+	}
+
+	resp, err = client.PostForm(server.URL+Path+"download", url.Values{"csrf": {csrf[1]}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusSeeOther || resp.Header.Get("Location") != "https://s3.example.com/sandbox/johndoe.ovpn" {
+		t.Errorf("got %d to %s, wanted a redirect to the profile", resp.StatusCode, resp.Header.Get("Location"))
+	}
+	if profiles.downloads != 1 {
+		t.Errorf("got %d downloads, wanted 1", profiles.downloads)
+	}
+}
+
+func TestPortalUnknownUser(t *testing.T) {
+	idp := newMockIdp(t, "intruder@example.com")
+	defer idp.server.Close()
+	server, client := startPortal(t, idp, &fakeProfiles{})
+	defer server.Close()
+
+	resp, err := client.Get(server.URL + Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("got %d, wanted 403", resp.StatusCode)
+	}
+}
@@ -0,0 +1,108 @@
+package portal
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	sessionCookie string        = "portal_session"
+	authCookie    string        = "portal_auth"
+	authTtl       time.Duration = time.Duration(10) * time.Minute
+)
+
+// session is the signed content of the session cookie.
+type session struct {
+	User    string    `json:"user"`
+	Expires time.Time `json:"expires"`
+}
+
+// authRequest is the signed content of the cookie kept during the authentication round trip.
+type authRequest struct {
+	State   string    `json:"state"`
+	Nonce   string    `json:"nonce"`
+	Expires time.Time `json:"expires"`
+}
+
+var errInvalidCookie = errors.New("invalid or expired cookie")
+
+func randomString() (string, error) {
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(random), nil
+}
+
+func (p *Portal) mac(value string) []byte {
+	h := hmac.New(sha256.New, p.key)
+	h.Write([]byte(value))
+	return h.Sum(nil)
+}
+
+// setSigned stores v json encoded and signed in a cookie.
+func (p *Portal) setSigned(w http.ResponseWriter, name string, v interface{}, expires time.Time) error {
+	content, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	value := base64.RawURLEncoding.EncodeToString(content)
+	value += "." + base64.RawURLEncoding.EncodeToString(p.mac(value))
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     Path,
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(p.config.RedirectUrl, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	})
+	return nil
+}
+
+// getSigned decodes into v a cookie stored by setSigned, checking its signature.
+func (p *Portal) getSigned(r *http.Request, name string, v interface{}) error {
+	cookie, err := r.Cookie(name)
+	if err != nil {
+		return errInvalidCookie
+	}
+	value, signature, ok := strings.Cut(cookie.Value, ".")
+	if !ok {
+		return errInvalidCookie
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(mac, p.mac(value)) {
+		return errInvalidCookie
+	}
+	content, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return errInvalidCookie
+	}
+	return json.Unmarshal(content, v)
+}
+
+func clearCookie(w http.ResponseWriter, name string) {
+	http.SetCookie(w, &http.Cookie{Name: name, Value: "", Path: Path, MaxAge: -1})
+}
+
+// currentSession returns the session of a request, nil if not authenticated.
+func (p *Portal) currentSession(r *http.Request) *session {
+	var s session
+	if err := p.getSigned(r, sessionCookie, &s); err != nil || time.Now().After(s.Expires) {
+		return nil
+	}
+	return &s
+}
+
+// csrfToken binds the forms of a page to the session.
+func (p *Portal) csrfToken(s *session) string {
+	return hex.EncodeToString(p.mac("csrf:" + s.User + ":" + s.Expires.String()))
+}
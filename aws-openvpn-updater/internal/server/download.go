@@ -0,0 +1,98 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+const DownloadPath string = "/download/"
+
+var (
+	ErrNotFound = errors.New("link not found")
+	ErrGone     = errors.New("link expired or already used")
+)
+
+// confirmPage asks the user to confirm the download, so that mail scanners following the link do not use it.
+const confirmPage string = `<html>
+<body>
+<p>Your VPN configuration is ready. This link can only be used once.</p>
+<form method="post"><button type="submit">Download</button></form>
+</body>
+</html>
+`
+
+// Downloader redeems one-time download tokens for a short-lived url.
+type Downloader interface {
+	// Check returns whether the token can still be redeemed, without using it
+	Check(token string) error
+	Redeem(ctx context.Context, token string, remoteAddr string, userAgent string) (string, error)
+}
+
+// DownloadHandler shows a confirmation page on GET /download/<token> and redirects the confirming POST
+// to the url returned by the downloader. X-Forwarded-For is only honoured from trusted proxies.
+func DownloadHandler(downloader Downloader, trustedProxies []netip.Prefix) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.URL.Path, DownloadPath)
+		var url string
+		var err error
+		switch r.Method {
+		case http.MethodGet:
+			err = downloader.Check(token)
+		case http.MethodPost:
+			url, err = downloader.Redeem(r.Context(), token, remoteAddr(r, trustedProxies), r.UserAgent())
+		default:
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		switch {
+		case errors.Is(err, ErrNotFound):
+			http.NotFound(w, r)
+		case errors.Is(err, ErrGone):
+			http.Error(w, "This link has expired or has already been used, please contact your administrator.", http.StatusGone)
+		case err != nil:
+			log.Error().Err(err).Msg("Error redeeming download link")
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		case r.Method == http.MethodGet:
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Write([]byte(confirmPage))
+		default:
+			http.Redirect(w, r, url, http.StatusSeeOther)
+		}
+	})
+}
+
+// remoteAddr returns the client address. Behind trusted proxies, it is the last X-Forwarded-For address
+// not added by one of them.
+func remoteAddr(r *http.Request, trustedProxies []netip.Prefix) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	forwarded := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+	for i := len(forwarded) - 1; i >= 0 && trusted(host, trustedProxies); i-- {
+		if address := strings.TrimSpace(forwarded[i]); address != "" {
+			host = address
+		}
+	}
+	return host
+}
+
+func trusted(host string, trustedProxies []netip.Prefix) bool {
+	address, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+	for _, proxy := range trustedProxies {
+		if proxy.Contains(address.Unmap()) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,77 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+type fakeDownloader map[string]error
+
+func (f fakeDownloader) Check(token string) error {
+	err, ok := f[token]
+	if !ok {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (f fakeDownloader) Redeem(ctx context.Context, token string, remoteAddr string, userAgent string) (string, error) {
+	err, ok := f[token]
+	if !ok {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	return "https://bucket.s3.amazonaws.com/sandbox/johndoe.ovpn?X-Amz-Signature=x", nil
+}
+
+func TestDownloadHandler(t *testing.T) {
+	handler := DownloadHandler(fakeDownloader{"valid": nil, "used": ErrGone}, nil)
+	tests := []struct {
+		method string
+		path   string
+		want   int
+	}{
+		{http.MethodGet, "/download/valid", http.StatusOK},
+		{http.MethodPost, "/download/valid", http.StatusSeeOther},
+		{http.MethodGet, "/download/used", http.StatusGone},
+		{http.MethodPost, "/download/used", http.StatusGone},
+		{http.MethodPost, "/download/unknown", http.StatusNotFound},
+		{http.MethodPut, "/download/valid", http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(tt.method, tt.path, nil))
+		if recorder.Code != tt.want {
+			t.Errorf("%s %s: got %d, wanted %d", tt.method, tt.path, recorder.Code, tt.want)
+		}
+	}
+}
+
+func TestRemoteAddr(t *testing.T) {
+	proxies := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+	tests := []struct {
+		remoteAddr string
+		forwarded  string
+		want       string
+	}{
+		{"10.0.0.1:4242", "", "10.0.0.1"},
+		{"10.0.0.1:4242", "203.0.113.7", "203.0.113.7"},
+		{"10.0.0.1:4242", "198.51.100.1, 203.0.113.7, 10.0.0.2", "203.0.113.7"},
+		{"203.0.113.9:4242", "198.51.100.1", "203.0.113.9"},
+	}
+	for _, tt := range tests {
+		request := httptest.NewRequest(http.MethodPost, "/download/x", nil)
+		request.RemoteAddr = tt.remoteAddr
+		if tt.forwarded != "" {
+			request.Header.Set("X-Forwarded-For", tt.forwarded)
+		}
+		if got := remoteAddr(request, proxies); got != tt.want {
+			t.Errorf("%s %q: got %s, wanted %s", tt.remoteAddr, tt.forwarded, got, tt.want)
+		}
+	}
+}
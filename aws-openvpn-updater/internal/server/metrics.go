@@ -0,0 +1,90 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+const MetricsPath string = "/metrics"
+
+// Metric is a sample of the prometheus text format, samples of a name share its help and type.
+type Metric struct {
+	Name   string
+	Help   string
+	Type   string
+	Labels map[string]string
+	Value  float64
+}
+
+// MetricsProvider returns the current samples.
+type MetricsProvider interface {
+	Metrics(ctx context.Context) ([]Metric, error)
+}
+
+// MetricsHandler serves the metrics in the prometheus text format, to bearers of token only unless empty.
+func MetricsHandler(provider MetricsProvider, token string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		if token != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+token)) != 1 {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		metrics, err := provider.Metrics(r.Context())
+		if err != nil {
+			log.Error().Err(err).Msg("Error reading metrics")
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		w.Header().Set("Cache-Control", "no-store")
+		w.Write([]byte(FormatMetrics(metrics)))
+	})
+}
+
+// FormatMetrics renders the samples, grouped by name in the order names first appear.
+func FormatMetrics(metrics []Metric) string {
+	var out strings.Builder
+	var names []string
+	byName := map[string][]Metric{}
+	for _, metric := range metrics {
+		if _, ok := byName[metric.Name]; !ok {
+			names = append(names, metric.Name)
+		}
+		byName[metric.Name] = append(byName[metric.Name], metric)
+	}
+	for _, name := range names {
+		first := byName[name][0]
+		fmt.Fprintf(&out, "# HELP %s %s\n# TYPE %s %s\n", name, first.Help, name, first.Type)
+		for _, metric := range byName[name] {
+			fmt.Fprintf(&out, "%s%s %s\n", name, formatLabels(metric.Labels), strconv.FormatFloat(metric.Value, 'g', -1, 64))
+		}
+	}
+	return out.String()
+}
+
+func formatLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	var keys []string
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var pairs []string
+	for _, key := range keys {
+		value := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(labels[key])
+		pairs = append(pairs, fmt.Sprintf("%s=\"%s\"", key, value))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
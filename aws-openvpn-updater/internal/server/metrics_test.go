@@ -0,0 +1,36 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type fakeMetrics []Metric
+
+func (f fakeMetrics) Metrics(ctx context.Context) ([]Metric, error) {
+	return f, nil
+}
+
+func TestMetricsHandler(t *testing.T) {
+	handler := MetricsHandler(fakeMetrics{
+		{Name: "vpn_members", Help: "Members", Type: "gauge", Value: 2},
+		{Name: "vpn_bytes_total", Help: "Bytes", Type: "counter", Labels: map[string]string{"user": "john", "direction": "sent"}, Value: 1.5e9},
+		{Name: "vpn_bytes_total", Help: "Bytes", Type: "counter", Labels: map[string]string{"user": `ja"ne`}, Value: 3},
+	}, "")
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, MetricsPath, nil))
+
+	want := `# HELP vpn_members Members
+# TYPE vpn_members gauge
+vpn_members 2
+# HELP vpn_bytes_total Bytes
+# TYPE vpn_bytes_total counter
+vpn_bytes_total{direction="sent",user="john"} 1.5e+09
+vpn_bytes_total{user="ja\"ne"} 3
+`
+	if recorder.Code != http.StatusOK || recorder.Body.String() != want {
+		t.Errorf("got %d %q, wanted %q", recorder.Code, recorder.Body, want)
+	}
+}
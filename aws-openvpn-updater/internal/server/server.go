@@ -0,0 +1,42 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const shutdownTimeout time.Duration = time.Duration(10) * time.Second
+
+// Server is the http endpoint of the updater, features register their handlers before Start.
+type Server struct {
+	Listen string
+	mux    *http.ServeMux
+}
+
+func CreateServer(listen string) *Server {
+	return &Server{Listen: listen, mux: http.NewServeMux()}
+}
+
+func (s *Server) Handle(pattern string, handler http.Handler) {
+	s.mux.Handle(pattern, handler)
+}
+
+// Start serves until ctx is cancelled, requests in progress are given shutdownTimeout to complete.
+func (s *Server) Start(ctx context.Context) {
+	httpServer := &http.Server{Addr: s.Listen, Handler: s.mux, ReadHeaderTimeout: shutdownTimeout}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		httpServer.Shutdown(shutdownCtx)
+	}()
+	log.Info().Msgf("Http server listening on %s", s.Listen)
+	err := httpServer.ListenAndServe()
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Error().Err(err).Msg("Http server stopped")
+	}
+}
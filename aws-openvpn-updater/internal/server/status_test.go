@@ -0,0 +1,38 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type fakeStatus map[string]string
+
+func (f fakeStatus) Status(ctx context.Context) (interface{}, error) {
+	return f, nil
+}
+
+func TestStatusHandler(t *testing.T) {
+	handler := StatusHandler(fakeStatus{"phase": "published"}, "secret")
+	tests := map[string]int{
+		"":              http.StatusUnauthorized,
+		"Bearer wrong":  http.StatusUnauthorized,
+		"Bearer secret": http.StatusOK,
+	}
+	for authorization, want := range tests {
+		request := httptest.NewRequest(http.MethodGet, StatusPath, nil)
+		if authorization != "" {
+			request.Header.Set("Authorization", authorization)
+		}
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		if recorder.Code != want {
+			t.Errorf("%q: got %d, wanted %d", authorization, recorder.Code, want)
+		}
+		if want == http.StatusOK && !strings.Contains(recorder.Body.String(), `"phase": "published"`) {
+			t.Errorf("got %s, wanted the status", recorder.Body)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package settings
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// expiryLayout is the layout of the vpn-expires tag and of the group defaults.
+const expiryLayout string = "2006-01-02"
+
+// ParseExpiry reads an access end date, the access lasts until the end of that day in UTC.
+func ParseExpiry(value string) (time.Time, error) {
+	day, err := time.Parse(expiryLayout, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("date %q must be YYYY-MM-DD", value)
+	}
+	return day.AddDate(0, 0, 1), nil
+}
+
+// ParseGroupExpiries reads the default access ends of the members of IAM groups, written "group=YYYY-MM-DD".
+func ParseGroupExpiries(values []string) (map[string]time.Time, error) {
+	expiries := map[string]time.Time{}
+	for _, value := range values {
+		group, date, found := strings.Cut(value, "=")
+		if !found || group == "" {
+			return nil, fmt.Errorf("group expiry %q must be \"group=YYYY-MM-DD\"", value)
+		}
+		expires, err := ParseExpiry(date)
+		if err != nil {
+			return nil, fmt.Errorf("group expiry %q: %w", value, err)
+		}
+		expiries[group] = expires
+	}
+	return expiries, nil
+}
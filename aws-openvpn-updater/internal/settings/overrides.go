@@ -0,0 +1,235 @@
+package settings
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pelletier/go-toml/v2"
+)
+
+// EnvPrefix prefixes the environment variables overriding settings,
+// settings.dry-run is overridden by OPENVPN_UPDATER_SETTINGS_DRY_RUN.
+const EnvPrefix string = "OPENVPN_UPDATER_"
+
+const (
+	SourceDefault string = "default"
+	SourceFile    string = "file"
+	SourceEnv     string = "env"
+	SourceFlag    string = "flag"
+)
+
+// flagOverrides holds the setting flags explicitly set on the command line.
+var flagOverrides = map[string]string{}
+
+type field struct {
+	key   string
+	value reflect.Value
+}
+
+// fields lists the overridable settings as section.key, in declaration order.
+func (s *Settings) fields() []field {
+	var fields []field
+	root := reflect.ValueOf(s).Elem()
+	for i := 0; i < root.NumField(); i++ {
+		section := root.Type().Field(i).Tag.Get("toml")
+		if section == "" || section == "-" || root.Field(i).IsNil() {
+			continue
+		}
+		sectionValue := root.Field(i).Elem()
+		for j := 0; j < sectionValue.NumField(); j++ {
+			key := sectionValue.Type().Field(j).Tag.Get("toml")
+			if key == "" || key == "-" || !supported(sectionValue.Field(j)) {
+				continue
+			}
+			fields = append(fields, field{key: section + "." + key, value: sectionValue.Field(j)})
+		}
+	}
+	return fields
+}
+
+func supported(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.String, reflect.Bool, reflect.Int, reflect.Int64:
+		return true
+	case reflect.Slice:
+		return v.Type().Elem().Kind() == reflect.String || v.Type().Elem().Kind() == reflect.Int
+	}
+	return false
+}
+
+func setValue(v reflect.Value, raw string) error {
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(raw)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int64:
+		if v.Type() == reflect.TypeOf(time.Duration(0)) {
+			d, err := time.ParseDuration(raw)
+			if err != nil {
+				return err
+			}
+			v.SetInt(int64(d))
+			return nil
+		}
+		fallthrough
+	case reflect.Int:
+		i, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return err
+		}
+		v.SetInt(i)
+	case reflect.Slice:
+		var items []string
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		if v.Type().Elem().Kind() != reflect.Int {
+			v.Set(reflect.ValueOf(items))
+			return nil
+		}
+		ints := []int{}
+		for _, item := range items {
+			i, err := strconv.Atoi(item)
+			if err != nil {
+				return err
+			}
+			ints = append(ints, i)
+		}
+		v.Set(reflect.ValueOf(ints))
+	}
+	return nil
+}
+
+func formatValue(v reflect.Value) string {
+	switch v.Kind() {
+	case reflect.String:
+		return strconv.Quote(v.String())
+	case reflect.Slice:
+		var items []string
+		for i := 0; i < v.Len(); i++ {
+			if v.Index(i).Kind() == reflect.Int {
+				items = append(items, strconv.FormatInt(v.Index(i).Int(), 10))
+			} else {
+				items = append(items, strconv.Quote(v.Index(i).String()))
+			}
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	case reflect.Int64:
+		if v.Type() == reflect.TypeOf(time.Duration(0)) {
+			return strconv.Quote(time.Duration(v.Int()).String())
+		}
+	}
+	return fmt.Sprintf("%v", v.Interface())
+}
+
+// EnvName returns the environment variable overriding a section.key setting.
+func EnvName(key string) string {
+	return EnvPrefix + strings.ToUpper(strings.NewReplacer(".", "_", "-", "_").Replace(key))
+}
+
+// setFileSources marks the settings set by the configuration file, unknown keys are left out.
+func (s *Settings) setFileSources(cfg []byte) {
+	var doc map[string]interface{}
+	if err := toml.Unmarshal(cfg, &doc); err != nil {
+		return
+	}
+	known := map[string]bool{}
+	for _, f := range s.fields() {
+		known[f.key] = true
+	}
+	for section, content := range doc {
+		keys, ok := content.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		for key := range keys {
+			if known[section+"."+key] {
+				s.sources[section+"."+key] = SourceFile
+			}
+		}
+	}
+}
+
+func (s *Settings) applyOverrides(lookupEnv func(string) (string, bool), flags map[string]string) error {
+	var errs []error
+	for _, f := range s.fields() {
+		if raw, ok := lookupEnv(EnvName(f.key)); ok {
+			if err := setValue(f.value, raw); err != nil {
+				errs = append(errs, fmt.Errorf("environment variable %s: %w", EnvName(f.key), err))
+			} else {
+				s.sources[f.key] = SourceEnv
+			}
+		}
+		if raw, ok := flags[f.key]; ok {
+			if err := setValue(f.value, raw); err != nil {
+				errs = append(errs, fmt.Errorf("flag -%s: %w", f.key, err))
+			} else {
+				s.sources[f.key] = SourceFlag
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Source returns where the effective value of a section.key setting comes from.
+func (s *Settings) Source(key string) string {
+	if source, ok := s.sources[key]; ok {
+		return source
+	}
+	return SourceDefault
+}
+
+// Print writes the effective settings in toml format, each value commented with its source.
+func (s *Settings) Print(w io.Writer) {
+	section := ""
+	for _, f := range s.fields() {
+		name, key, _ := strings.Cut(f.key, ".")
+		if name != section {
+			if section != "" {
+				fmt.Fprintln(w)
+			}
+			fmt.Fprintf(w, "[%s]\n", name)
+			section = name
+		}
+		fmt.Fprintf(w, "%s = %s # %s\n", key, formatValue(f.value), s.Source(f.key))
+	}
+}
+
+type overrideFlag struct {
+	key    string
+	isBool bool
+}
+
+func (f *overrideFlag) String() string {
+	return ""
+}
+
+func (f *overrideFlag) Set(raw string) error {
+	flagOverrides[f.key] = raw
+	return nil
+}
+
+func (f *overrideFlag) IsBoolFlag() bool {
+	return f.isBool
+}
+
+// RegisterFlags declares a flag per setting, named after its section.key, ie -settings.dry-run.
+func RegisterFlags(fs *flag.FlagSet) {
+	for _, f := range defaultSettings(nil).fields() {
+		usage := fmt.Sprintf("overrides %s, also set by %s", f.key, EnvName(f.key))
+		fs.Var(&overrideFlag{key: f.key, isBool: f.value.Kind() == reflect.Bool}, f.key, usage)
+	}
+}
@@ -0,0 +1,28 @@
+package settings
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+// ParseTrustedProxies reads the addresses or networks of the proxies allowed to set X-Forwarded-For.
+func ParseTrustedProxies(values []string) ([]netip.Prefix, error) {
+	var proxies []netip.Prefix
+	for _, value := range values {
+		if !strings.Contains(value, "/") {
+			address, err := netip.ParseAddr(value)
+			if err != nil {
+				return nil, fmt.Errorf("trusted proxy %q must be an address or a network", value)
+			}
+			proxies = append(proxies, netip.PrefixFrom(address, address.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(value)
+		if err != nil {
+			return nil, fmt.Errorf("trusted proxy %q must be an address or a network", value)
+		}
+		proxies = append(proxies, prefix.Masked())
+	}
+	return proxies, nil
+}
@@ -0,0 +1,56 @@
+package settings
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+const (
+	SsmPrefix            string = "ssm:"
+	SecretsManagerPrefix string = "secretsmanager:"
+)
+
+// Resolver fetches the value behind a ssm: or secretsmanager: reference.
+type Resolver interface {
+	Resolve(ctx context.Context, reference string) (string, error)
+}
+
+// IsReference reports whether a setting value must be fetched from SSM Parameter Store or Secrets Manager.
+func IsReference(value string) bool {
+	return strings.HasPrefix(value, SsmPrefix) || strings.HasPrefix(value, SecretsManagerPrefix)
+}
+
+// collectReferences records the settings holding a reference, they are resolved again on every refresh.
+func (s *Settings) collectReferences() {
+	s.references = map[string]string{}
+	for _, f := range s.fields() {
+		if f.value.Kind() == reflect.String && IsReference(f.value.String()) {
+			s.references[f.key] = f.value.String()
+		}
+	}
+}
+
+// ResolveReferences replaces every reference with its value. A setting keeps its previous value if
+// its reference cannot be resolved.
+func (s *Settings) ResolveReferences(ctx context.Context, resolver Resolver) error {
+	if len(s.references) == 0 {
+		return nil
+	}
+	var errs []error
+	for _, f := range s.fields() {
+		reference, ok := s.references[f.key]
+		if !ok {
+			continue
+		}
+		value, err := resolver.Resolve(ctx, reference)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s %q: %w", f.key, reference, err))
+			continue
+		}
+		f.value.SetString(value)
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,73 @@
+package settings
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// remoteProtos are the protocols accepted by the openvpn remote directive.
+var remoteProtos = []string{"udp", "udp4", "udp6", "tcp", "tcp4", "tcp6", "tcp-client"}
+
+// Remote is an endpoint written as a remote directive of the client profiles.
+type Remote struct {
+	Host string
+	// Port and Proto fall back to the port and proto directives when empty
+	Port  string
+	Proto string
+}
+
+func (r Remote) String() string {
+	return fmt.Sprintf("[ Host: %v, Port: %v, Proto: %v ]", r.Host, r.Port, r.Proto)
+}
+
+// Line renders the remote directive.
+func (r Remote) Line() string {
+	return strings.Join(strings.Fields(fmt.Sprintf("remote %s %s %s", r.Host, r.Port, r.Proto)), " ")
+}
+
+// ParseRemote reads a remote written like the openvpn directive, "host [port [proto]]".
+// Hosts are names, ipv4 or ipv6 addresses.
+func ParseRemote(value string) (Remote, error) {
+	fields := strings.Fields(value)
+	if len(fields) == 0 || len(fields) > 3 {
+		return Remote{}, fmt.Errorf("remote %q must be \"host [port [proto]]\"", value)
+	}
+	remote := Remote{Host: fields[0]}
+	if len(fields) > 1 {
+		port, err := strconv.Atoi(fields[1])
+		if err != nil || port < 1 || port > 65535 {
+			return Remote{}, fmt.Errorf("remote %q has an invalid port %q", value, fields[1])
+		}
+		remote.Port = fields[1]
+	}
+	if len(fields) > 2 {
+		remote.Proto = fields[2]
+		if !contains(remoteProtos, remote.Proto) {
+			return Remote{}, fmt.Errorf("remote %q has an invalid proto %q, must be one of %s", value, remote.Proto, strings.Join(remoteProtos, ", "))
+		}
+	}
+	return remote, nil
+}
+
+// ParseRemotes parses every configured remote.
+func ParseRemotes(values []string) ([]Remote, error) {
+	var remotes []Remote
+	for _, value := range values {
+		remote, err := ParseRemote(value)
+		if err != nil {
+			return nil, err
+		}
+		remotes = append(remotes, remote)
+	}
+	return remotes, nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -1,8 +1,11 @@
 package settings
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"os"
+	"strings"
 
 	"github.com/FinalCAD/vpn-stack/aws-openvpn-updater/internal/configs"
 	"github.com/pelletier/go-toml/v2"
@@ -15,17 +18,67 @@ const (
 	defaultOpenVpnServerPath   string = "/etc/openvpn/server"
 	defaultRegion              string = "eu-central-1"
 	defaultSenderMail          string = ""
+	defaultParameterCacheTtl   int    = 300
+	defaultPresignTtl          int    = 21600
+	defaultStateFile           string = "/var/lib/aws-openvpn-updater/state.json"
+	defaultUserClaim           string = "email"
+	defaultSessionTtl          int    = 3600
+	defaultRefreshLimit        int    = 20
+	defaultCaRotationDays      int    = 30
+	defaultTotpPath            string = "/var/lib/aws-openvpn-updater/totp"
+	defaultTotpKeyFile         string = "/var/lib/aws-openvpn-updater/totp.key"
+	defaultTotpIssuer          string = "VPN"
+	defaultTotpSkew            int    = 1
+	defaultMembersFile         string = "/var/lib/aws-openvpn-updater/members.json"
+	defaultMembersMaxAge       int    = 900
+	defaultAuditLog            string = "/var/log/aws-openvpn-updater/connect.log"
+	defaultStatusInterval      int    = 60
+	defaultDormantWarning      int    = 7
+	defaultExpiryNotice        int    = 7
+	defaultDigestDays          int    = 30
+	PassphraseChannelEmail     string = "email"
+	PassphraseChannelWebhook   string = "webhook"
+	ProfileFormatInline        string = "inline"
+	ProfileFormatP12           string = "p12"
+	ProfileFormatSplit         string = "split"
+	QrCodeUrl                  string = "url"
+	QrCodeImport               string = "openvpn-connect"
+	TlsCryptShared             string = "shared"
+	TlsCryptV2                 string = "v2"
+	KeyAlgoRsa                 string = "rsa"
+	KeyAlgoEc                  string = "ec"
+	KeyAlgoEd                  string = "ed"
 )
 
+// defaultCertificateNoticeDays are the days before the expiry of their certificate the users are warned.
+var defaultCertificateNoticeDays = []int{30, 7, 1}
+
+// ProfileFormats lists the formats client profiles can be exported in.
+var ProfileFormats = []string{ProfileFormatInline, ProfileFormatP12, ProfileFormatSplit}
+
+func IsProfileFormat(format string) bool {
+	return contains(ProfileFormats, format)
+}
+
 type Settings struct {
-	Aws     *Aws `toml:"aws"`
-	Config  *configs.Config
-	OpenVpn *OpenVpn `toml:"openvpn"`
-	Params  *Params  `toml:"settings"`
+	Aws        *Aws            `toml:"aws"`
+	Config     *configs.Config `toml:"-"`
+	OpenVpn    *OpenVpn        `toml:"openvpn"`
+	Params     *Params         `toml:"settings"`
+	Server     *Server         `toml:"server"`
+	Portal     *Portal         `toml:"portal"`
+	Passphrase *Passphrase     `toml:"passphrase"`
+	Totp       *Totp           `toml:"totp"`
+	Connect    *Connect        `toml:"connect"`
+	Expiry     *Expiry         `toml:"expiry"`
+	Ops        *Ops            `toml:"ops"`
+	sources    map[string]string
+	references map[string]string
 }
 
 func (s Settings) String() string {
-	return fmt.Sprintf("[ Aws: %v, Config: %v, OpenVpn: %v, Params: %v ]", s.Aws, s.Config, s.OpenVpn, s.Params)
+	return fmt.Sprintf("[ Aws: %v, Config: %v, OpenVpn: %v, Params: %v, Server: %v, Portal: %v, Passphrase: %v, Totp: %v, Connect: %v, Expiry: %v, Ops: %v ]",
+		s.Aws, s.Config, s.OpenVpn, s.Params, s.Server, s.Portal, s.Passphrase, s.Totp, s.Connect, s.Expiry, s.Ops)
 }
 
 type Params struct {
@@ -35,50 +88,214 @@ type Params struct {
 	SenderMail      string `toml:"sender"`
 	SendMail        bool   `toml:"send-mail"`
 	UseFqdn         bool   `toml:"use-fqdn"`
+	Inventory       bool   `toml:"inventory"`
+	InventoryClean  bool   `toml:"inventory-cleanup"`
+	DownloadOnce    bool   `toml:"download-once"`
+	StateFile       string `toml:"state-file"`
+	ProfileFormat   string `toml:"profile-format"`
+	QrCode          string `toml:"qr-code"`
+	RefreshLimit    int    `toml:"refresh-limit"`
+	DormantDays     int    `toml:"dormant-days"`
+	DormantWarning  int    `toml:"dormant-warning-days"`
 }
 
 func (p Params) String() string {
-	return fmt.Sprintf("[ RequestInterval: %v, S3Upload: %v, SendMail: %v, SenderMail: %v, Dryrun: %v ]",
-		p.RequestInterval, p.S3Upload, p.SendMail, p.SenderMail, p.Dryrun)
+	return fmt.Sprintf("[ RequestInterval: %v, S3Upload: %v, SendMail: %v, SenderMail: %v, Dryrun: %v, Inventory: %v, InventoryClean: %v, DownloadOnce: %v, StateFile: %v, ProfileFormat: %v, QrCode: %v, RefreshLimit: %v, DormantDays: %v, DormantWarning: %v ]",
+		p.RequestInterval, p.S3Upload, p.SendMail, p.SenderMail, p.Dryrun, p.Inventory, p.InventoryClean, p.DownloadOnce, p.StateFile, p.ProfileFormat, p.QrCode, p.RefreshLimit,
+		p.DormantDays, p.DormantWarning)
+}
+
+type Server struct {
+	Listen      string `toml:"listen"`
+	PublicUrl   string `toml:"public-url"`
+	StatusToken string `toml:"status-token"`
+	// TrustedProxies are the addresses or networks whose X-Forwarded-For header is honoured
+	TrustedProxies []string `toml:"trusted-proxies"`
+}
+
+func (s Server) String() string {
+	return fmt.Sprintf("[ Listen: %v, PublicUrl: %v, TrustedProxies: %v ]", s.Listen, s.PublicUrl, s.TrustedProxies)
+}
+
+type Portal struct {
+	Enabled      bool   `toml:"enabled"`
+	Issuer       string `toml:"issuer"`
+	ClientId     string `toml:"client-id"`
+	ClientSecret string `toml:"client-secret"`
+	UserClaim    string `toml:"user-claim"`
+	SessionTtl   int    `toml:"session-ttl"`
+}
+
+func (p Portal) String() string {
+	return fmt.Sprintf("[ Enabled: %v, Issuer: %v, ClientId: %v, UserClaim: %v, SessionTtl: %v ]", p.Enabled, p.Issuer, p.ClientId, p.UserClaim, p.SessionTtl)
+}
+
+// Passphrase encrypts the client keys of every user when enabled, or of the members of groups only.
+type Passphrase struct {
+	Enabled    bool     `toml:"enabled"`
+	Groups     []string `toml:"groups"`
+	Channel    string   `toml:"channel"`
+	WebhookUrl string   `toml:"webhook-url"`
+}
+
+func (p Passphrase) String() string {
+	return fmt.Sprintf("[ Enabled: %v, Groups: %v, Channel: %v ]", p.Enabled, p.Groups, p.Channel)
+}
+
+// Totp enrolls every user with a second factor checked by the verify command.
+type Totp struct {
+	Enabled bool   `toml:"enabled"`
+	Path    string `toml:"secrets-path"`
+	KeyFile string `toml:"key-file"`
+	Issuer  string `toml:"issuer"`
+	Skew    int    `toml:"skew"`
+	// Group is the group of the user running the openvpn scripts
+	Group string `toml:"group"`
+}
+
+func (t Totp) String() string {
+	return fmt.Sprintf("[ Enabled: %v, Path: %v, KeyFile: %v, Issuer: %v, Skew: %v, Group: %v ]", t.Enabled, t.Path, t.KeyFile, t.Issuer, t.Skew, t.Group)
+}
+
+// Connect caches the members of the vpn group for the client-connect command.
+type Connect struct {
+	Enabled   bool   `toml:"enabled"`
+	CacheFile string `toml:"cache-file"`
+	MaxAge    int    `toml:"max-age"`
+	AuditLog  string `toml:"audit-log"`
+}
+
+func (c Connect) String() string {
+	return fmt.Sprintf("[ Enabled: %v, CacheFile: %v, MaxAge: %v, AuditLog: %v ]", c.Enabled, c.CacheFile, c.MaxAge, c.AuditLog)
+}
+
+// Expiry ends the access of the members of IAM groups at a date, unless their vpn-expires tag sets another.
+// Users are warned CertificateNoticeDays before the expiry of their certificates, and the operators get a
+// daily digest of the expirations within DigestDays.
+type Expiry struct {
+	Groups                []string `toml:"groups"`
+	NoticeDays            int      `toml:"notice-days"`
+	CertificateNoticeDays []int    `toml:"certificate-notice-days"`
+	DigestDays            int      `toml:"digest-days"`
+}
+
+func (e Expiry) String() string {
+	return fmt.Sprintf("[ Groups: %v, NoticeDays: %v, CertificateNoticeDays: %v, DigestDays: %v ]", e.Groups, e.NoticeDays, e.CertificateNoticeDays, e.DigestDays)
+}
+
+// Ops receives the notices about users, by email, webhook or both.
+type Ops struct {
+	Email      string `toml:"email"`
+	WebhookUrl string `toml:"webhook-url"`
+}
+
+func (o Ops) String() string {
+	return fmt.Sprintf("[ Email: %v, WebhookUrl: %v ]", o.Email, o.WebhookUrl)
 }
 
 type OpenVpn struct {
-	EasyRsaPath         string `toml:"easy-rsa-path"`
-	EasyRsaKeyDirectory string `toml:"key-directory"`
-	OpenVpnServerPath   string `toml:"server-path"`
+	EasyRsaPath         string   `toml:"easy-rsa-path"`
+	EasyRsaKeyDirectory string   `toml:"key-directory"`
+	OpenVpnServerPath   string   `toml:"server-path"`
+	Remotes             []string `toml:"remotes"`
+	RemoteRandom        bool     `toml:"remote-random"`
+	CaRotationDays      int      `toml:"ca-rotation-days"`
+	TlsCrypt            string   `toml:"tls-crypt"`
+	KeyAlgo             string   `toml:"key-algo"`
+	KeySize             int      `toml:"key-size"`
+	KeyCurve            string   `toml:"key-curve"`
+	Digest              string   `toml:"digest"`
+	KeyMigrate          bool     `toml:"key-migrate"`
+	StatusFile          string   `toml:"status-file"`
+	StatusInterval      int      `toml:"status-interval"`
+	ClientConfigDir     string   `toml:"client-config-dir"`
 }
 
 func (o OpenVpn) String() string {
-	return fmt.Sprintf("[ EasyRsaPath: %v, EasyRsaKeyDirectory: %v, OpenVpnServerPath: %v ]", o.EasyRsaPath, o.EasyRsaKeyDirectory, o.OpenVpnServerPath)
+	return fmt.Sprintf("[ EasyRsaPath: %v, EasyRsaKeyDirectory: %v, OpenVpnServerPath: %v, Remotes: %v, RemoteRandom: %v, CaRotationDays: %v, TlsCrypt: %v, "+
+		"KeyAlgo: %v, KeySize: %v, KeyCurve: %v, Digest: %v, KeyMigrate: %v, StatusFile: %v, StatusInterval: %v, ClientConfigDir: %v ]",
+		o.EasyRsaPath, o.EasyRsaKeyDirectory, o.OpenVpnServerPath, o.Remotes, o.RemoteRandom, o.CaRotationDays, o.TlsCrypt,
+		o.KeyAlgo, o.KeySize, o.KeyCurve, o.Digest, o.KeyMigrate, o.StatusFile, o.StatusInterval, o.ClientConfigDir)
 }
 
 type Aws struct {
-	Profile      string `toml:"profile"`
-	BucketName   string `toml:"s3-bucket-name"`
-	Region       string `toml:"region"`
-	RoleToAssume string `toml:"assume-role"`
-	VpnGroup     string `toml:"vpn-group"`
+	Profile           string `toml:"profile"`
+	BucketName        string `toml:"s3-bucket-name"`
+	KmsKeyId          string `toml:"s3-kms-key-id"`
+	PresignTtl        int    `toml:"presign-ttl"`
+	Region            string `toml:"region"`
+	RoleToAssume      string `toml:"assume-role"`
+	VpnGroup          string `toml:"vpn-group"`
+	ParameterEndpoint string `toml:"parameter-endpoint"`
+	ParameterCacheTtl int    `toml:"parameter-cache-ttl"`
+	EventQueueUrl     string `toml:"event-queue-url"`
+	SqsEndpoint       string `toml:"sqs-endpoint"`
 }
 
 func (a Aws) String() string {
-	return fmt.Sprintf("[ Profile: %v, Region: %v, BucketName: %v, VpnGroup: %v , RoleToAssume: %v ]", a.Profile, a.Region, a.BucketName, a.VpnGroup, a.RoleToAssume)
+	return fmt.Sprintf("[ Profile: %v, Region: %v, BucketName: %v, KmsKeyId: %v, PresignTtl: %v, VpnGroup: %v , RoleToAssume: %v, ParameterEndpoint: %v, ParameterCacheTtl: %v, EventQueueUrl: %v ]",
+		a.Profile, a.Region, a.BucketName, a.KmsKeyId, a.PresignTtl, a.VpnGroup, a.RoleToAssume, a.ParameterEndpoint, a.ParameterCacheTtl, a.EventQueueUrl)
 }
 
-func CreateSettings(config *configs.Config) (*Settings, error) {
+func defaultSettings(config *configs.Config) *Settings {
 	params := &Params{RequestInterval: defaultRequestInterval, S3Upload: true, SendMail: true,
-		SenderMail: defaultSenderMail, Dryrun: false, UseFqdn: false}
+		SenderMail: defaultSenderMail, Dryrun: false, UseFqdn: false, StateFile: defaultStateFile,
+		ProfileFormat: ProfileFormatInline, RefreshLimit: defaultRefreshLimit, DormantWarning: defaultDormantWarning}
 	openvpn := &OpenVpn{EasyRsaPath: defaultEasyRsaPath,
 		EasyRsaKeyDirectory: defaultEasyRsaKeyDirectory,
-		OpenVpnServerPath:   defaultOpenVpnServerPath}
-	aws := &Aws{Profile: "", Region: defaultRegion, RoleToAssume: ""}
-	settings := &Settings{Config: config, Params: params, OpenVpn: openvpn, Aws: aws}
-	cfg, err := os.ReadFile(config.ConfigFile)
-	if err != nil {
-		return nil, err
+		OpenVpnServerPath:   defaultOpenVpnServerPath,
+		CaRotationDays:      defaultCaRotationDays,
+		TlsCrypt:            TlsCryptShared,
+		StatusInterval:      defaultStatusInterval}
+	aws := &Aws{Profile: "", Region: defaultRegion, RoleToAssume: "", ParameterCacheTtl: defaultParameterCacheTtl,
+		PresignTtl: defaultPresignTtl}
+	server := &Server{}
+	portal := &Portal{UserClaim: defaultUserClaim, SessionTtl: defaultSessionTtl}
+	passphrase := &Passphrase{Channel: PassphraseChannelEmail}
+	totp := &Totp{Path: defaultTotpPath, KeyFile: defaultTotpKeyFile, Issuer: defaultTotpIssuer, Skew: defaultTotpSkew}
+	connect := &Connect{CacheFile: defaultMembersFile, MaxAge: defaultMembersMaxAge, AuditLog: defaultAuditLog}
+	expiry := &Expiry{NoticeDays: defaultExpiryNotice, CertificateNoticeDays: append([]int(nil), defaultCertificateNoticeDays...), DigestDays: defaultDigestDays}
+	return &Settings{Config: config, Params: params, OpenVpn: openvpn, Aws: aws, Server: server, Portal: portal,
+		Passphrase: passphrase, Totp: totp, Connect: connect, Expiry: expiry, Ops: &Ops{}, sources: map[string]string{}}
+}
+
+// CreateSettings builds the settings from, in increasing order of precedence, the defaults,
+// the configuration file, the environment variables and the command line flags.
+// An empty configuration file path skips the file.
+func CreateSettings(config *configs.Config) (*Settings, error) {
+	return createSettings(config, os.LookupEnv, flagOverrides)
+}
+
+func createSettings(config *configs.Config, lookupEnv func(string) (string, bool), flags map[string]string) (*Settings, error) {
+	var errs []error
+	settings := defaultSettings(config)
+	if config.ConfigFile != "" {
+		cfg, err := os.ReadFile(config.ConfigFile)
+		if err != nil {
+			return nil, err
+		}
+		err = toml.NewDecoder(bytes.NewReader(cfg)).DisallowUnknownFields().Decode(settings)
+		if err != nil {
+			var strictErr *toml.StrictMissingError
+			if !errors.As(err, &strictErr) {
+				return nil, err
+			}
+			// Known keys are decoded anyway, settings can still be validated
+			errs = append(errs, decodeError(strictErr))
+		}
+		settings.setFileSources(cfg)
 	}
-	err = toml.Unmarshal(cfg, &settings)
-	if err != nil {
-		return nil, err
+	errs = append(errs, settings.applyOverrides(lookupEnv, flags))
+	settings.collectReferences()
+	return settings, errors.Join(errs...)
+}
+
+// decodeError turns a strict decoding error into one error per unknown key.
+func decodeError(strictErr *toml.StrictMissingError) error {
+	var errs []error
+	for _, e := range strictErr.Errors {
+		row, _ := e.Position()
+		errs = append(errs, fmt.Errorf("unknown configuration key %q at line %d", strings.Join(e.Key(), "."), row))
 	}
-	return settings, nil
+	return errors.Join(errs...)
 }
@@ -0,0 +1,334 @@
+package settings
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/FinalCAD/vpn-stack/aws-openvpn-updater/internal/configs"
+)
+
+func writeConfig(t *testing.T, content string) *configs.Config {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.toml")
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return &configs.Config{ConfigFile: path, Environment: "test"}
+}
+
+// noEnv keeps the environment of the test process out of the settings.
+func noEnv(string) (string, bool) {
+	return "", false
+}
+
+func TestCreateSettingsUnknownKey(t *testing.T) {
+	config := writeConfig(t, "[aws]\ns3-bucket = \"bucket\"\nvpn-group = \"group\"\n")
+
+	got, err := createSettings(config, noEnv, nil)
+
+	if err == nil || !strings.Contains(err.Error(), `"aws.s3-bucket"`) {
+		t.Errorf("got error %v, wanted unknown key aws.s3-bucket", err)
+	}
+	if got == nil || got.Aws.VpnGroup != "group" {
+		t.Errorf("got %v, wanted decoded known keys", got)
+	}
+	if got != nil && got.Source("aws.s3-bucket") != SourceDefault {
+		t.Errorf("got aws.s3-bucket from %s, wanted an unknown key left out", got.Source("aws.s3-bucket"))
+	}
+}
+
+func TestValidate(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "pki"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	config := writeConfig(t, `
+[settings]
+sender = "vpn@example.com"
+[openvpn]
+easy-rsa-path = "`+dir+`"
+server-path = "`+dir+`"
+[aws]
+s3-bucket-name = "bucket"
+vpn-group = "group"
+`)
+
+	settings, err := createSettings(config, noEnv, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = settings.Validate(); err != nil {
+		t.Errorf("got %v, wanted valid settings", err)
+	}
+}
+
+func TestValidateListsAllProblems(t *testing.T) {
+	config := writeConfig(t, `
+[settings]
+request-interval = -1
+sender = "not-an-email"
+[openvpn]
+easy-rsa-path = "/nonexistent"
+server-path = "/nonexistent"
+`)
+
+	settings, err := createSettings(config, noEnv, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = settings.Validate()
+	if err == nil {
+		t.Fatal("got nil, wanted validation errors")
+	}
+	for _, want := range []string{"request-interval", "settings.sender", "easy-rsa-path", "server-path", "vpn-group", "s3-bucket-name"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("got %q, wanted a problem about %s", err, want)
+		}
+	}
+}
+
+func TestValidatePassphrase(t *testing.T) {
+	tests := []struct {
+		config string
+		want   string
+	}{
+		{"[passphrase]\nenabled = true\nchannel = \"sms\"\n", "passphrase.channel"},
+		{"[passphrase]\ngroups = [\"admins\"]\nchannel = \"webhook\"\n", "passphrase.webhook-url"},
+	}
+	for _, tt := range tests {
+		settings, err := createSettings(writeConfig(t, tt.config), noEnv, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = settings.Validate()
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("got %v, wanted a problem about %s", err, tt.want)
+		}
+	}
+}
+
+func TestValidatePresignTtl(t *testing.T) {
+	tests := []struct {
+		config string
+		want   bool
+	}{
+		{"[aws]\npresign-ttl = 604800\n", false},
+		{"[aws]\npresign-ttl = 21600\nassume-role = \"arn:aws:iam::123456789012:role/vpn\"\n", false},
+		{"[aws]\npresign-ttl = 86400\nassume-role = \"arn:aws:iam::123456789012:role/vpn\"\n", true},
+	}
+	for _, tt := range tests {
+		settings, err := createSettings(writeConfig(t, tt.config), noEnv, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = settings.Validate()
+		if got := err != nil && strings.Contains(err.Error(), "aws.presign-ttl"); got != tt.want {
+			t.Errorf("%q: got %v, wanted a problem about aws.presign-ttl %v", tt.config, err, tt.want)
+		}
+	}
+}
+
+func TestValidateDormantMail(t *testing.T) {
+	tests := []struct {
+		config string
+		want   bool
+	}{
+		{"[settings]\ndormant-days = 90\nsend-mail = true\nsender = \"vpn@example.com\"\n", false},
+		{"[settings]\ndormant-days = 90\nsend-mail = false\nsender = \"vpn@example.com\"\n", true},
+		{"[settings]\ndormant-days = 90\nsend-mail = true\n", true},
+		{"[settings]\nsend-mail = false\n", false},
+	}
+	for _, tt := range tests {
+		settings, err := createSettings(writeConfig(t, tt.config), noEnv, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = settings.Validate()
+		if got := err != nil && strings.Contains(err.Error(), "settings.sender are required when settings.dormant-days"); got != tt.want {
+			t.Errorf("%q: got %v, wanted a problem about the dormancy mail %v", tt.config, err, tt.want)
+		}
+	}
+}
+
+func TestValidateAuditLog(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		auditLog string
+		want     bool
+	}{
+		{filepath.Join(dir, "connect.log"), false},
+		{filepath.Join(dir, "missing", "connect.log"), true},
+		{"", false},
+	}
+	for _, tt := range tests {
+		config := fmt.Sprintf("[connect]\nenabled = true\naudit-log = %q\n", tt.auditLog)
+		settings, err := createSettings(writeConfig(t, config), noEnv, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = settings.Validate()
+		if got := err != nil && strings.Contains(err.Error(), "connect.audit-log"); got != tt.want {
+			t.Errorf("%q: got %v, wanted a problem about connect.audit-log %v", tt.auditLog, err, tt.want)
+		}
+	}
+}
+
+func TestValidateKey(t *testing.T) {
+	tests := []struct {
+		openvpn OpenVpn
+		want    string
+	}{
+		{OpenVpn{KeyAlgo: KeyAlgoRsa, KeySize: 4096, Digest: "sha512"}, ""},
+		{OpenVpn{KeyAlgo: KeyAlgoEc, KeyCurve: "secp384r1"}, ""},
+		{OpenVpn{KeyAlgo: KeyAlgoEd}, ""},
+		{OpenVpn{KeyAlgo: "dsa"}, "openvpn.key-algo"},
+		{OpenVpn{KeyAlgo: KeyAlgoRsa, KeySize: 1024}, "openvpn.key-size"},
+		{OpenVpn{KeyAlgo: KeyAlgoEd, KeyCurve: "secp384r1"}, "openvpn.key-curve"},
+		{OpenVpn{KeySize: 4096}, "require openvpn.key-algo"},
+		{OpenVpn{Digest: "md5"}, "openvpn.digest"},
+	}
+	for _, tt := range tests {
+		err := errors.Join(validateKey(&tt.openvpn)...)
+		if tt.want == "" && err != nil {
+			t.Errorf("got %v, wanted %v valid", err, tt.openvpn)
+		}
+		if tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)) {
+			t.Errorf("got %v, wanted a problem about %s", err, tt.want)
+		}
+	}
+}
+
+func TestParseRemote(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+		err   bool
+	}{
+		{"vpn.example.com", "remote vpn.example.com", false},
+		{"2001:db8::1 443 tcp", "remote 2001:db8::1 443 tcp", false},
+		{"vpn.example.com 70000", "", true},
+		{"vpn.example.com 1194 sctp", "", true},
+		{"", "", true},
+	}
+	for _, tt := range tests {
+		remote, err := ParseRemote(tt.value)
+		if (err != nil) != tt.err || (err == nil && remote.Line() != tt.want) {
+			t.Errorf("%q: got %q %v, wanted %q", tt.value, remote.Line(), err, tt.want)
+		}
+	}
+}
+
+func TestParseGroupExpiries(t *testing.T) {
+	expiries, err := ParseGroupExpiries([]string{"contractors=2026-12-31"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC); !expiries["contractors"].Equal(want) {
+		t.Errorf("got %s, wanted the end of the day %s", expiries["contractors"], want)
+	}
+	for _, value := range []string{"contractors", "=2026-12-31", "contractors=31/12/2026"} {
+		if _, err = ParseGroupExpiries([]string{value}); err == nil {
+			t.Errorf("%q: got no error", value)
+		}
+	}
+}
+
+func TestApplyOverridesPrecedence(t *testing.T) {
+	config := writeConfig(t, "[settings]\nrequest-interval = 10\nsender = \"file@example.com\"\n[aws]\nregion = \"eu-west-1\"\n")
+	settings, err := createSettings(config, noEnv, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := map[string]string{
+		"OPENVPN_UPDATER_SETTINGS_REQUEST_INTERVAL": "20",
+		"OPENVPN_UPDATER_AWS_REGION":                "us-east-1",
+	}
+	lookupEnv := func(key string) (string, bool) {
+		value, ok := env[key]
+		return value, ok
+	}
+	flags := map[string]string{"settings.request-interval": "30", "settings.dry-run": "true", "expiry.certificate-notice-days": "14, 2"}
+
+	if err = settings.applyOverrides(lookupEnv, flags); err != nil {
+		t.Fatal(err)
+	}
+
+	if settings.Params.RequestInterval != 30 || settings.Source("settings.request-interval") != SourceFlag {
+		t.Errorf("got %d from %s, wanted 30 from flag", settings.Params.RequestInterval, settings.Source("settings.request-interval"))
+	}
+	if settings.Aws.Region != "us-east-1" || settings.Source("aws.region") != SourceEnv {
+		t.Errorf("got %s from %s, wanted us-east-1 from env", settings.Aws.Region, settings.Source("aws.region"))
+	}
+	if settings.Params.SenderMail != "file@example.com" || settings.Source("settings.sender") != SourceFile {
+		t.Errorf("got %s from %s, wanted file@example.com from file", settings.Params.SenderMail, settings.Source("settings.sender"))
+	}
+	if days := settings.Expiry.CertificateNoticeDays; len(days) != 2 || days[0] != 14 || days[1] != 2 {
+		t.Errorf("got certificate notice days %v, wanted [14 2] from flag", days)
+	}
+	if !settings.Params.Dryrun || settings.Source("aws.profile") != SourceDefault {
+		t.Errorf("got dry-run %v and aws.profile from %s, wanted true and default", settings.Params.Dryrun, settings.Source("aws.profile"))
+	}
+}
+
+func TestApplyOverridesInvalidValue(t *testing.T) {
+	settings := defaultSettings(nil)
+	lookupEnv := func(key string) (string, bool) {
+		return "often", key == "OPENVPN_UPDATER_SETTINGS_DRY_RUN"
+	}
+
+	err := settings.applyOverrides(lookupEnv, nil)
+
+	if err == nil || !strings.Contains(err.Error(), "OPENVPN_UPDATER_SETTINGS_DRY_RUN") {
+		t.Errorf("got %v, wanted an error on OPENVPN_UPDATER_SETTINGS_DRY_RUN", err)
+	}
+}
+
+type fakeResolver map[string]string
+
+func (f fakeResolver) Resolve(ctx context.Context, reference string) (string, error) {
+	if value, ok := f[reference]; ok {
+		return value, nil
+	}
+	return "", errors.New("parameter not found")
+}
+
+func TestResolveReferences(t *testing.T) {
+	config := writeConfig(t, "[settings]\nsender = \"ssm:/vpn/sender\"\n[aws]\nvpn-group = \"ssm:/vpn/missing\"\n")
+	settings, err := createSettings(config, noEnv, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = settings.ResolveReferences(context.Background(), fakeResolver{"ssm:/vpn/sender": "vpn@example.com"})
+
+	if settings.Params.SenderMail != "vpn@example.com" {
+		t.Errorf("got %q, wanted vpn@example.com", settings.Params.SenderMail)
+	}
+	if err == nil || !strings.Contains(err.Error(), "aws.vpn-group") {
+		t.Errorf("got %v, wanted an error on aws.vpn-group", err)
+	}
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+	proxies, err := ParseTrustedProxies([]string{"10.0.0.1", "172.16.0.0/12", "2001:db8::/32"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"10.0.0.1/32", "172.16.0.0/12", "2001:db8::/32"}
+	for i, proxy := range proxies {
+		if proxy.String() != want[i] {
+			t.Errorf("got %s, wanted %s", proxy, want[i])
+		}
+	}
+	for _, value := range []string{"proxy.example.com", "10.0.0.0/33"} {
+		if _, err = ParseTrustedProxies([]string{value}); err == nil {
+			t.Errorf("%q: got no error", value)
+		}
+	}
+}
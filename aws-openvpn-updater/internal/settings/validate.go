@@ -0,0 +1,242 @@
+package settings
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"net/url"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+)
+
+const maxPresignTtl int = 7 * 24 * 3600
+
+// AssumedRoleSessionTtl is the duration in seconds of the session of aws.assume-role.
+const AssumedRoleSessionTtl int = 6 * 3600
+
+// Validate checks the semantic consistency of the settings and returns all the problems found.
+func (s *Settings) Validate() error {
+	var errs []error
+
+	if s.Config != nil && s.Config.Environment == "" {
+		errs = append(errs, errors.New("missing environment command line parameter"))
+	}
+
+	if s.Params.RequestInterval <= 0 {
+		errs = append(errs, fmt.Errorf("settings.request-interval must be positive, got %d", s.Params.RequestInterval))
+	}
+	if s.Params.SendMail && s.Params.SenderMail == "" {
+		errs = append(errs, errors.New("settings.sender is required when settings.send-mail is enabled"))
+	}
+	if s.Params.SenderMail != "" && !IsReference(s.Params.SenderMail) {
+		if _, err := mail.ParseAddress(s.Params.SenderMail); err != nil {
+			errs = append(errs, fmt.Errorf("settings.sender %q is not a valid email: %w", s.Params.SenderMail, err))
+		}
+	}
+	if !IsProfileFormat(s.Params.ProfileFormat) {
+		errs = append(errs, fmt.Errorf("settings.profile-format must be one of %s, got %q", strings.Join(ProfileFormats, ", "), s.Params.ProfileFormat))
+	}
+	if s.Params.RefreshLimit < 0 {
+		errs = append(errs, fmt.Errorf("settings.refresh-limit must not be negative, got %d", s.Params.RefreshLimit))
+	}
+	if s.Params.DormantDays < 0 {
+		errs = append(errs, fmt.Errorf("settings.dormant-days must not be negative, got %d", s.Params.DormantDays))
+	}
+	if s.Params.DormantDays > 0 {
+		if s.OpenVpn.StatusFile == "" {
+			errs = append(errs, errors.New("openvpn.status-file is required when settings.dormant-days is set"))
+		}
+		if s.Params.DormantWarning < 0 || s.Params.DormantWarning >= s.Params.DormantDays {
+			errs = append(errs, fmt.Errorf("settings.dormant-warning-days must be between 0 and dormant-days, got %d", s.Params.DormantWarning))
+		}
+		if !s.Params.SendMail || s.Params.SenderMail == "" {
+			errs = append(errs, errors.New("settings.send-mail and settings.sender are required when settings.dormant-days is set"))
+		}
+	}
+	if s.Params.QrCode != "" && s.Params.QrCode != QrCodeUrl && s.Params.QrCode != QrCodeImport {
+		errs = append(errs, fmt.Errorf("settings.qr-code must be empty, %s or %s, got %q", QrCodeUrl, QrCodeImport, s.Params.QrCode))
+	}
+
+	errs = append(errs, checkDirectory("openvpn.easy-rsa-path", s.OpenVpn.EasyRsaPath))
+	errs = append(errs, checkDirectory("openvpn.key-directory", fmt.Sprintf("%s/%s", s.OpenVpn.EasyRsaPath, s.OpenVpn.EasyRsaKeyDirectory)))
+	errs = append(errs, checkDirectory("openvpn.server-path", s.OpenVpn.OpenVpnServerPath))
+	if _, err := ParseRemotes(s.OpenVpn.Remotes); err != nil {
+		errs = append(errs, fmt.Errorf("openvpn.remotes: %w", err))
+	}
+	if s.OpenVpn.CaRotationDays <= 0 {
+		errs = append(errs, fmt.Errorf("openvpn.ca-rotation-days must be positive, got %d", s.OpenVpn.CaRotationDays))
+	}
+	if s.OpenVpn.TlsCrypt != TlsCryptShared && s.OpenVpn.TlsCrypt != TlsCryptV2 {
+		errs = append(errs, fmt.Errorf("openvpn.tls-crypt must be %s or %s, got %q", TlsCryptShared, TlsCryptV2, s.OpenVpn.TlsCrypt))
+	}
+	errs = append(errs, validateKey(s.OpenVpn)...)
+	if s.OpenVpn.StatusFile != "" && s.OpenVpn.StatusInterval <= 0 {
+		errs = append(errs, fmt.Errorf("openvpn.status-interval must be positive, got %d", s.OpenVpn.StatusInterval))
+	}
+	if s.OpenVpn.ClientConfigDir != "" {
+		errs = append(errs, checkDirectory("openvpn.client-config-dir", s.OpenVpn.ClientConfigDir))
+	}
+	if s.Params.UseFqdn && len(s.OpenVpn.Remotes) > 0 {
+		errs = append(errs, errors.New("settings.use-fqdn and openvpn.remotes are exclusive"))
+	}
+
+	if s.Aws.ParameterCacheTtl < 0 {
+		errs = append(errs, fmt.Errorf("aws.parameter-cache-ttl must not be negative, got %d", s.Aws.ParameterCacheTtl))
+	}
+	// Presigned urls are limited to 7 days by S3, and to the session duration with an assumed role
+	if s.Aws.PresignTtl <= 0 || s.Aws.PresignTtl > maxPresignTtl {
+		errs = append(errs, fmt.Errorf("aws.presign-ttl must be between 1 and %d seconds, got %d", maxPresignTtl, s.Aws.PresignTtl))
+	} else if s.Aws.RoleToAssume != "" && s.Aws.PresignTtl > AssumedRoleSessionTtl {
+		errs = append(errs, fmt.Errorf("aws.presign-ttl must not exceed the %d seconds session of aws.assume-role, got %d",
+			AssumedRoleSessionTtl, s.Aws.PresignTtl))
+	}
+	if s.Params.DownloadOnce {
+		if !s.Params.S3Upload {
+			errs = append(errs, errors.New("settings.download-once requires settings.s3-upload"))
+		}
+		if s.Server.Listen == "" || s.Server.PublicUrl == "" {
+			errs = append(errs, errors.New("server.listen and server.public-url are required when settings.download-once is enabled"))
+		}
+	}
+	if s.Portal.Enabled {
+		if !s.Params.S3Upload {
+			errs = append(errs, errors.New("portal.enabled requires settings.s3-upload"))
+		}
+		if s.Server.Listen == "" || s.Server.PublicUrl == "" {
+			errs = append(errs, errors.New("server.listen and server.public-url are required when portal.enabled is true"))
+		}
+		if s.Portal.Issuer == "" || s.Portal.ClientId == "" || s.Portal.UserClaim == "" {
+			errs = append(errs, errors.New("portal.issuer, portal.client-id and portal.user-claim are required when portal.enabled is true"))
+		}
+		if s.Portal.SessionTtl <= 0 {
+			errs = append(errs, fmt.Errorf("portal.session-ttl must be positive, got %d", s.Portal.SessionTtl))
+		}
+	}
+	if s.Passphrase.Enabled || len(s.Passphrase.Groups) > 0 {
+		switch s.Passphrase.Channel {
+		case PassphraseChannelEmail:
+			if s.Params.SenderMail == "" {
+				errs = append(errs, errors.New("settings.sender is required when passphrases are sent by email"))
+			}
+		case PassphraseChannelWebhook:
+			if s.Passphrase.WebhookUrl == "" {
+				errs = append(errs, errors.New("passphrase.webhook-url is required when passphrase.channel is webhook"))
+			}
+		default:
+			errs = append(errs, fmt.Errorf("passphrase.channel must be %s or %s, got %q", PassphraseChannelEmail, PassphraseChannelWebhook, s.Passphrase.Channel))
+		}
+	}
+	if s.Totp.Enabled {
+		if s.Totp.Path == "" || s.Totp.KeyFile == "" || s.Totp.Issuer == "" {
+			errs = append(errs, errors.New("totp.secrets-path, totp.key-file and totp.issuer are required when totp.enabled is true"))
+		}
+		if s.Totp.Skew < 0 {
+			errs = append(errs, fmt.Errorf("totp.skew must not be negative, got %d", s.Totp.Skew))
+		}
+		if _, err := user.LookupGroup(s.Totp.Group); s.Totp.Group != "" && err != nil {
+			errs = append(errs, fmt.Errorf("totp.group: %w", err))
+		}
+		if !s.Params.SendMail || s.Params.SenderMail == "" {
+			errs = append(errs, errors.New("settings.send-mail and settings.sender are required when totp.enabled is true"))
+		}
+	}
+	if s.Connect.Enabled {
+		if s.Connect.CacheFile == "" {
+			errs = append(errs, errors.New("connect.cache-file is required when connect.enabled is true"))
+		}
+		// A member is seen at every loop, a shorter max-age refuses members between two loops
+		if s.Connect.MaxAge <= s.Params.RequestInterval {
+			errs = append(errs, fmt.Errorf("connect.max-age must be greater than settings.request-interval, got %d", s.Connect.MaxAge))
+		}
+		// The client-connect hook runs unprivileged and cannot create it
+		if s.Connect.AuditLog != "" {
+			errs = append(errs, checkDirectory("connect.audit-log", filepath.Dir(s.Connect.AuditLog)))
+		}
+	}
+	if _, err := ParseGroupExpiries(s.Expiry.Groups); err != nil {
+		errs = append(errs, fmt.Errorf("expiry.groups: %w", err))
+	}
+	if s.Expiry.NoticeDays < 0 {
+		errs = append(errs, fmt.Errorf("expiry.notice-days must not be negative, got %d", s.Expiry.NoticeDays))
+	}
+	for _, days := range s.Expiry.CertificateNoticeDays {
+		if days <= 0 {
+			errs = append(errs, fmt.Errorf("expiry.certificate-notice-days must be positive, got %d", days))
+		}
+	}
+	if s.Expiry.DigestDays < 0 {
+		errs = append(errs, fmt.Errorf("expiry.digest-days must not be negative, got %d", s.Expiry.DigestDays))
+	}
+	if s.Ops.Email != "" && s.Params.SenderMail == "" {
+		errs = append(errs, errors.New("settings.sender is required when ops.email is set"))
+	}
+	if s.Server.PublicUrl != "" {
+		if u, err := url.Parse(s.Server.PublicUrl); err != nil || u.Scheme == "" || u.Host == "" {
+			errs = append(errs, fmt.Errorf("server.public-url %q is not an absolute url", s.Server.PublicUrl))
+		}
+	}
+	if _, err := ParseTrustedProxies(s.Server.TrustedProxies); err != nil {
+		errs = append(errs, fmt.Errorf("server.trusted-proxies: %w", err))
+	}
+	if s.Aws.VpnGroup == "" {
+		errs = append(errs, errors.New("aws.vpn-group is required"))
+	}
+	if s.Params.S3Upload && s.Aws.BucketName == "" {
+		errs = append(errs, errors.New("aws.s3-bucket-name is required when settings.s3-upload is enabled"))
+	}
+
+	return errors.Join(errs...)
+}
+
+func checkDirectory(key string, path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("%s %q: %w", key, path, err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s %q is not a directory", key, path)
+	}
+	return nil
+}
+
+// Curves the key algorithm of a certificate can be reported for, by their openssl name.
+var ecCurves = []string{"prime256v1", "secp384r1", "secp521r1"}
+var edCurves = []string{"ed25519"}
+var digests = []string{"sha256", "sha384", "sha512"}
+
+// validateKey checks the key settings passed to easyrsa, empty values keep those of its vars file.
+func validateKey(o *OpenVpn) []error {
+	var errs []error
+	switch o.KeyAlgo {
+	case "":
+		if o.KeySize != 0 || o.KeyCurve != "" || o.KeyMigrate {
+			errs = append(errs, errors.New("openvpn.key-size, openvpn.key-curve and openvpn.key-migrate require openvpn.key-algo"))
+		}
+	case KeyAlgoRsa:
+		if o.KeySize != 0 && o.KeySize < 2048 {
+			errs = append(errs, fmt.Errorf("openvpn.key-size must be at least 2048, got %d", o.KeySize))
+		}
+		if o.KeyCurve != "" {
+			errs = append(errs, errors.New("openvpn.key-curve is not used with rsa keys"))
+		}
+	case KeyAlgoEc, KeyAlgoEd:
+		curves := ecCurves
+		if o.KeyAlgo == KeyAlgoEd {
+			curves = edCurves
+		}
+		if o.KeyCurve != "" && !contains(curves, o.KeyCurve) {
+			errs = append(errs, fmt.Errorf("openvpn.key-curve must be one of %s for %s keys, got %q", strings.Join(curves, ", "), o.KeyAlgo, o.KeyCurve))
+		}
+		if o.KeySize != 0 {
+			errs = append(errs, errors.New("openvpn.key-size is only used with rsa keys"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("openvpn.key-algo must be empty, %s, %s or %s, got %q", KeyAlgoRsa, KeyAlgoEc, KeyAlgoEd, o.KeyAlgo))
+	}
+	if o.Digest != "" && !contains(digests, o.Digest) {
+		errs = append(errs, fmt.Errorf("openvpn.digest must be one of %s, got %q", strings.Join(digests, ", "), o.Digest))
+	}
+	return errs
+}
@@ -0,0 +1,116 @@
+package state
+
+import (
+	"fmt"
+	"time"
+)
+
+// maxSessions bounds the sessions kept per user, the totals include the sessions dropped.
+const maxSessions int = 100
+
+// Connection is the vpn usage of a user, read from the openvpn status file.
+type Connection struct {
+	User string `json:"user"`
+	// LastConnected is the start of the last session, LastSeen the last status update the user was connected in
+	LastConnected time.Time `json:"last-connected"`
+	LastSeen      time.Time `json:"last-seen"`
+	RealAddress   string    `json:"real-address"`
+	BytesReceived int64     `json:"bytes-received"`
+	BytesSent     int64     `json:"bytes-sent"`
+	// Sessions are the most recent sessions, oldest first
+	Sessions []*Session `json:"sessions"`
+}
+
+func (c Connection) String() string {
+	return fmt.Sprintf("[ User: %s, LastConnected: %s, RealAddress: %s, Sessions: %d ]", c.User, c.LastConnected, c.RealAddress, len(c.Sessions))
+}
+
+// Session is a connection of a client, ended at the last status update it was seen in.
+type Session struct {
+	Start         time.Time `json:"start"`
+	End           time.Time `json:"end"`
+	RealAddress   string    `json:"real-address"`
+	BytesReceived int64     `json:"bytes-received"`
+	BytesSent     int64     `json:"bytes-sent"`
+	Active        bool      `json:"active"`
+}
+
+func (s Session) Duration() time.Duration {
+	return s.End.Sub(s.Start)
+}
+
+// Observe records the session started at start as connected at now with its current byte counters.
+func (c *Connection) Observe(start time.Time, address string, received int64, sent int64, now time.Time) {
+	var session *Session
+	for _, s := range c.Sessions {
+		if s.Active && s.Start.Equal(start) {
+			session = s
+			break
+		}
+	}
+	if session == nil {
+		session = &Session{Start: start, Active: true}
+		c.Sessions = append(c.Sessions, session)
+		if len(c.Sessions) > maxSessions {
+			c.Sessions = c.Sessions[len(c.Sessions)-maxSessions:]
+		}
+	}
+	// Counters only grow during a session
+	if received >= session.BytesReceived && sent >= session.BytesSent {
+		c.BytesReceived += received - session.BytesReceived
+		c.BytesSent += sent - session.BytesSent
+		session.BytesReceived, session.BytesSent = received, sent
+	}
+	session.End = now
+	session.RealAddress = address
+	if start.After(c.LastConnected) {
+		c.LastConnected = start
+	}
+	c.LastSeen = now
+	c.RealAddress = address
+}
+
+// Disconnect ends the active sessions not started at one of connected.
+func (c *Connection) Disconnect(connected []time.Time) {
+	for _, s := range c.Sessions {
+		if !s.Active {
+			continue
+		}
+		found := false
+		for _, start := range connected {
+			if s.Start.Equal(start) {
+				found = true
+				break
+			}
+		}
+		s.Active = found
+	}
+}
+
+// Connected tells whether a session of the user is active.
+func (c *Connection) Connected() bool {
+	for _, s := range c.Sessions {
+		if s.Active {
+			return true
+		}
+	}
+	return false
+}
+
+// SessionTime sums the durations of the sessions kept.
+func (c *Connection) SessionTime() time.Duration {
+	var total time.Duration
+	for _, s := range c.Sessions {
+		total += s.Duration()
+	}
+	return total
+}
+
+// PruneConnections forgets the history of the users that are not members, once they are disconnected.
+func (d *Data) PruneConnections(members map[string]bool) {
+	for user, connection := range d.Connections {
+		if !members[user] && !connection.Connected() {
+			delete(d.Connections, user)
+		}
+	}
+}
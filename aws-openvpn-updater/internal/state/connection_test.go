@@ -0,0 +1,60 @@
+package state
+
+import (
+	"testing"
+	"time"
+)
+
+func TestConnectionObserve(t *testing.T) {
+	start := time.Date(2026, 10, 19, 7, 0, 0, 0, time.UTC)
+	c := &Connection{User: "john"}
+
+	c.Observe(start, "192.0.2.1:51234", 100, 200, start.Add(time.Minute))
+	c.Observe(start, "192.0.2.1:51234", 150, 300, start.Add(2*time.Minute))
+	c.Disconnect(nil)
+	second := start.Add(time.Hour)
+	c.Observe(second, "198.51.100.7:40000", 10, 20, second.Add(time.Minute))
+
+	if len(c.Sessions) != 2 || c.Sessions[0].Active || !c.Sessions[1].Active || !c.Connected() {
+		t.Fatalf("got %v, wanted an ended and an active session", c.Sessions)
+	}
+	if c.BytesReceived != 160 || c.BytesSent != 320 {
+		t.Errorf("got %d received, %d sent, wanted the totals of both sessions", c.BytesReceived, c.BytesSent)
+	}
+	if c.Sessions[0].Duration() != 2*time.Minute || c.SessionTime() != 3*time.Minute {
+		t.Errorf("got durations %s and %s", c.Sessions[0].Duration(), c.SessionTime())
+	}
+	if !c.LastConnected.Equal(second) || c.RealAddress != "198.51.100.7:40000" {
+		t.Errorf("got %v, wanted the last session", c)
+	}
+
+	for i := 0; i < maxSessions; i++ {
+		c.Observe(second.Add(time.Duration(i+1)*time.Hour), "", 0, 0, second.Add(time.Duration(i+1)*time.Hour))
+	}
+	if len(c.Sessions) != maxSessions || c.BytesReceived != 160 {
+		t.Errorf("got %d sessions and %d received, wanted the oldest dropped and the totals kept", len(c.Sessions), c.BytesReceived)
+	}
+}
+
+func TestPruneConnections(t *testing.T) {
+	now := time.Date(2026, 10, 19, 8, 0, 0, 0, time.UTC)
+	data := newData()
+	for _, user := range []string{"john", "jane", "jack"} {
+		data.Connections[user] = &Connection{User: user}
+		data.Connections[user].Observe(now.Add(-time.Hour), "192.0.2.1:51234", 100, 200, now)
+	}
+	data.Connections["jane"].Disconnect(nil)
+	data.Connections["jack"].Disconnect(nil)
+
+	data.PruneConnections(map[string]bool{"jack": true})
+
+	if _, ok := data.Connections["jane"]; ok {
+		t.Errorf("got %v, wanted jane forgotten after leaving", data.Connections)
+	}
+	if _, ok := data.Connections["john"]; !ok {
+		t.Errorf("got %v, wanted john kept while connected", data.Connections)
+	}
+	if _, ok := data.Connections["jack"]; !ok {
+		t.Errorf("got %v, wanted the member jack kept", data.Connections)
+	}
+}
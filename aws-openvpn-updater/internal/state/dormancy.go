@@ -0,0 +1,84 @@
+package state
+
+import (
+	"fmt"
+	"time"
+)
+
+// Dormancy records the warning and the revocation of a user without connection for settings.dormant-days.
+type Dormancy struct {
+	User    string    `json:"user"`
+	Warned  time.Time `json:"warned,omitempty"`
+	Revoked time.Time `json:"revoked,omitempty"`
+}
+
+func (d Dormancy) String() string {
+	return fmt.Sprintf("[ User: %s, Warned: %s, Revoked: %s ]", d.User, d.Warned, d.Revoked)
+}
+
+// DormantAction is the step the dormancy of a member calls for.
+type DormantAction string
+
+const (
+	DormantNone   DormantAction = "none"
+	DormantWarn   DormantAction = "warn"
+	DormantRevoke DormantAction = "revoke"
+	// DormantEnable forgets the revocation of a user given the vpn-keep tag
+	DormantEnable DormantAction = "enable"
+)
+
+// NextAction returns the step due at now for a member last active at last, revoked after dormancy
+// and warned warning before. Kept members are never warned nor revoked.
+func (d Dormancy) NextAction(last time.Time, now time.Time, dormancy time.Duration, warning time.Duration, keep bool) DormantAction {
+	if !d.Revoked.IsZero() {
+		if keep {
+			return DormantEnable
+		}
+		return DormantNone
+	}
+	if keep || now.Sub(last) < dormancy-warning {
+		return DormantNone
+	}
+	// A warning sent before the last activity belongs to a previous dormancy
+	if d.Warned.Before(last) {
+		return DormantWarn
+	}
+	if deadline := last.Add(dormancy); !now.Before(deadline) && !now.Before(d.Warned.Add(warning)) {
+		return DormantRevoke
+	}
+	return DormantNone
+}
+
+// LastActivity returns the last connection of the user, or the issue of its certificate or the start of
+// the history when more recent.
+func (d *Data) LastActivity(user string, issued time.Time) time.Time {
+	last := d.ConnectionsSince
+	if issued.After(last) {
+		last = issued
+	}
+	if connection, ok := d.Connections[user]; ok && connection.LastSeen.After(last) {
+		last = connection.LastSeen
+	}
+	return last
+}
+
+// RecordDormant records the step taken for the user at now.
+func (d *Data) RecordDormant(user string, action DormantAction, now time.Time) {
+	switch action {
+	case DormantWarn:
+		d.dormancy(user).Warned = now
+	case DormantRevoke:
+		d.dormancy(user).Revoked = now
+	case DormantEnable:
+		delete(d.Dormant, user)
+	}
+}
+
+func (d *Data) dormancy(user string) *Dormancy {
+	record, ok := d.Dormant[user]
+	if !ok {
+		record = &Dormancy{User: user}
+		d.Dormant[user] = record
+	}
+	return record
+}
@@ -0,0 +1,99 @@
+package state
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDormancyNextAction(t *testing.T) {
+	dormancy := 90 * 24 * time.Hour
+	warning := 14 * 24 * time.Hour
+	last := time.Date(2026, 7, 1, 8, 0, 0, 0, time.UTC)
+	deadline := last.Add(dormancy)
+	warned := deadline.Add(-warning)
+
+	tests := []struct {
+		name   string
+		record Dormancy
+		now    time.Time
+		keep   bool
+		want   DormantAction
+	}{
+		{"active", Dormancy{}, warned.Add(-time.Hour), false, DormantNone},
+		{"warning threshold", Dormancy{}, warned, false, DormantWarn},
+		{"warned before deadline", Dormancy{Warned: warned}, deadline.Add(-time.Hour), false, DormantNone},
+		{"deadline", Dormancy{Warned: warned}, deadline, false, DormantRevoke},
+		{"late warning", Dormancy{Warned: deadline}, deadline.Add(time.Hour), false, DormantNone},
+		{"late warning expired", Dormancy{Warned: deadline}, deadline.Add(warning), false, DormantRevoke},
+		{"warned before activity", Dormancy{Warned: last.Add(-time.Hour)}, deadline, false, DormantWarn},
+		{"kept", Dormancy{Warned: warned}, deadline, true, DormantNone},
+		{"revoked", Dormancy{Revoked: deadline}, deadline.Add(time.Hour), false, DormantNone},
+		{"revoked then kept", Dormancy{Revoked: deadline}, deadline.Add(time.Hour), true, DormantEnable},
+	}
+	for _, tt := range tests {
+		if got := tt.record.NextAction(last, tt.now, dormancy, warning, tt.keep); got != tt.want {
+			t.Errorf("%s: got %s, wanted %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRecordDormant(t *testing.T) {
+	dormancy := 90 * 24 * time.Hour
+	warning := 14 * 24 * time.Hour
+	last := time.Date(2026, 7, 1, 8, 0, 0, 0, time.UTC)
+	loops := []time.Time{last.Add(dormancy - warning), last.Add(dormancy), last.Add(dormancy + warning)}
+
+	tests := []struct {
+		dryRun bool
+		want   []DormantAction
+	}{
+		{false, []DormantAction{DormantWarn, DormantRevoke, DormantNone}},
+		// Nothing is recorded in dry run, the user is warned again and never revoked
+		{true, []DormantAction{DormantWarn, DormantWarn, DormantWarn}},
+	}
+	for _, tt := range tests {
+		data := newData()
+		for i, now := range loops {
+			record := Dormancy{}
+			if r, ok := data.Dormant["john"]; ok {
+				record = *r
+			}
+			action := record.NextAction(last, now, dormancy, warning, false)
+			if action != tt.want[i] {
+				t.Errorf("dry run %v, loop %d: got %s, wanted %s", tt.dryRun, i, action, tt.want[i])
+			}
+			if !tt.dryRun {
+				data.RecordDormant("john", action, now)
+			}
+		}
+	}
+
+	data := newData()
+	data.RecordDormant("john", DormantRevoke, last)
+	data.RecordDormant("john", DormantEnable, last.Add(time.Hour))
+	if _, ok := data.Dormant["john"]; ok {
+		t.Errorf("got %v, wanted the revocation forgotten once enabled", data.Dormant)
+	}
+}
+
+func TestLastActivity(t *testing.T) {
+	since := time.Date(2026, 7, 1, 8, 0, 0, 0, time.UTC)
+	data := newData()
+	data.ConnectionsSince = since
+	data.Connections["john"] = &Connection{User: "john", LastSeen: since.AddDate(0, 1, 0)}
+
+	tests := []struct {
+		user   string
+		issued time.Time
+		want   time.Time
+	}{
+		{"john", since.AddDate(0, 0, 1), since.AddDate(0, 1, 0)},
+		{"jane", since.AddDate(0, 0, 1), since.AddDate(0, 0, 1)},
+		{"jane", since.AddDate(0, 0, -1), since},
+	}
+	for _, tt := range tests {
+		if got := data.LastActivity(tt.user, tt.issued); !got.Equal(tt.want) {
+			t.Errorf("%s issued %s: got %s, wanted %s", tt.user, tt.issued, got, tt.want)
+		}
+	}
+}
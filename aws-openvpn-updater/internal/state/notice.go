@@ -0,0 +1,100 @@
+package state
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Notice records a notification sent to a user about an event due at a date, so that it is sent once.
+type Notice struct {
+	Kind string    `json:"kind"`
+	User string    `json:"user"`
+	Due  time.Time `json:"due"`
+	Sent time.Time `json:"sent"`
+}
+
+func (n Notice) String() string {
+	return fmt.Sprintf("[ Kind: %s, User: %s, Due: %s, Sent: %s ]", n.Kind, n.User, n.Due, n.Sent)
+}
+
+func noticeKey(kind string, user string, due time.Time) string {
+	return fmt.Sprintf("%s/%s/%d", kind, user, due.Unix())
+}
+
+// Notified returns whether the notice of kind about the event of the user due at due was sent.
+func (d *Data) Notified(kind string, user string, due time.Time) bool {
+	_, ok := d.Notices[noticeKey(kind, user, due)]
+	return ok
+}
+
+// RecordNotice records the notice as sent at now.
+func (d *Data) RecordNotice(kind string, user string, due time.Time, now time.Time) {
+	d.Notices[noticeKey(kind, user, due)] = &Notice{Kind: kind, User: user, Due: due, Sent: now}
+}
+
+// PruneNotices forgets the notices of the events past at now.
+func (d *Data) PruneNotices(now time.Time) {
+	for key, notice := range d.Notices {
+		if notice.Due.Before(now) {
+			delete(d.Notices, key)
+		}
+	}
+}
+
+// NoticeCertificateExpiry is the kind of the notices sent before the expiry of a certificate, suffixed by
+// the days of the threshold.
+const NoticeCertificateExpiry string = "certificate-expiry"
+
+// CertificateNoticeKind returns the kind of the notice of the smallest threshold in days crossed by a
+// certificate expiring in remaining, empty when none is.
+func CertificateNoticeKind(thresholds []int, remaining time.Duration) string {
+	if remaining <= 0 {
+		return ""
+	}
+	smallest := 0
+	for _, days := range thresholds {
+		if remaining <= time.Duration(days)*24*time.Hour && (smallest == 0 || days < smallest) {
+			smallest = days
+		}
+	}
+	if smallest == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%s-%d", NoticeCertificateExpiry, smallest)
+}
+
+// Expiration is the expiry of the certificate of a user, or the end of its access, listed in the digest of the operators.
+type Expiration struct {
+	User   string
+	Date   time.Time
+	Access bool
+}
+
+func (e Expiration) String() string {
+	return fmt.Sprintf("[ User: %s, Date: %s, Access: %v ]", e.User, e.Date, e.Access)
+}
+
+// Line describes the expiration in the digest, an access ends the day before its date at midnight.
+func (e Expiration) Line() string {
+	if e.Access {
+		return fmt.Sprintf("%s: access ends on %s", e.User, e.Date.AddDate(0, 0, -1).Format("2006-01-02"))
+	}
+	return fmt.Sprintf("%s: certificate expires on %s", e.User, e.Date.UTC().Format("2006-01-02 15:04 MST"))
+}
+
+// UpcomingExpirations returns the lines of the expirations within window after now, by date.
+func UpcomingExpirations(expirations []Expiration, now time.Time, window time.Duration) []string {
+	var upcoming []Expiration
+	for _, e := range expirations {
+		if !e.Date.IsZero() && e.Date.Sub(now) <= window {
+			upcoming = append(upcoming, e)
+		}
+	}
+	sort.SliceStable(upcoming, func(i, j int) bool { return upcoming[i].Date.Before(upcoming[j].Date) })
+	lines := make([]string, 0, len(upcoming))
+	for _, e := range upcoming {
+		lines = append(lines, e.Line())
+	}
+	return lines
+}
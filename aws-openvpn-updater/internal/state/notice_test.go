@@ -0,0 +1,71 @@
+package state
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNotices(t *testing.T) {
+	data := newData()
+	now := time.Date(2026, 10, 19, 8, 0, 0, 0, time.UTC)
+	due := now.AddDate(0, 0, 7)
+	data.RecordNotice("expiry", "john", due, now)
+
+	if !data.Notified("expiry", "john", due) {
+		t.Error("got not notified, wanted the recorded notice")
+	}
+	if data.Notified("expiry", "john", due.AddDate(0, 0, 1)) || data.Notified("expiry", "jane", due) {
+		t.Error("got notified, wanted notices by user and due date")
+	}
+	data.PruneNotices(due.Add(time.Second))
+	if len(data.Notices) != 0 {
+		t.Errorf("got %v, wanted past notices pruned", data.Notices)
+	}
+}
+
+func TestCertificateNoticeKind(t *testing.T) {
+	thresholds := []int{30, 7, 1}
+	tests := []struct {
+		remaining time.Duration
+		want      string
+	}{
+		{31 * 24 * time.Hour, ""},
+		{30 * 24 * time.Hour, "certificate-expiry-30"},
+		{8 * 24 * time.Hour, "certificate-expiry-30"},
+		{7 * 24 * time.Hour, "certificate-expiry-7"},
+		{time.Hour, "certificate-expiry-1"},
+		{0, ""},
+		{-time.Hour, ""},
+	}
+	for _, tt := range tests {
+		if got := CertificateNoticeKind(thresholds, tt.remaining); got != tt.want {
+			t.Errorf("got %q for %s, wanted %q", got, tt.remaining, tt.want)
+		}
+	}
+	if got := CertificateNoticeKind(nil, time.Hour); got != "" {
+		t.Errorf("got %q without thresholds, wanted none", got)
+	}
+}
+
+func TestUpcomingExpirations(t *testing.T) {
+	now := time.Date(2026, 10, 19, 8, 0, 0, 0, time.UTC)
+	expirations := []Expiration{
+		{User: "john", Date: now.AddDate(0, 0, 20)},
+		{User: "jane", Date: now.AddDate(0, 0, 40)},
+		{User: "jack", Date: time.Date(2026, 10, 25, 0, 0, 0, 0, time.UTC), Access: true},
+		{User: "jill", Access: true},
+	}
+	got := UpcomingExpirations(expirations, now, 30*24*time.Hour)
+	want := []string{
+		"jack: access ends on 2026-10-24",
+		"john: certificate expires on 2026-11-08 08:00 UTC",
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %q, wanted %q", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("got %q, wanted %q", got[i], want[i])
+		}
+	}
+}
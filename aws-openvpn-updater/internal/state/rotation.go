@@ -0,0 +1,75 @@
+package state
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Phases of a ca rotation.
+const (
+	// RotationPrepared: the new ca exists, the server does not trust it yet
+	RotationPrepared string = "prepared"
+	// RotationPublished: the server trusts both cas, clients are reissued under the new one
+	RotationPublished string = "published"
+	// RotationRetired: the new ca replaced the old one
+	RotationRetired string = "retired"
+)
+
+// Rotation tracks the last ca rotation.
+type Rotation struct {
+	Phase     string    `json:"phase"`
+	Started   time.Time `json:"started"`
+	Published time.Time `json:"published,omitempty"`
+	Deadline  time.Time `json:"deadline,omitempty"`
+	Retired   time.Time `json:"retired,omitempty"`
+	// Reissued is when each user was reissued under the new ca
+	Reissued map[string]time.Time `json:"reissued"`
+}
+
+func (r Rotation) String() string {
+	return fmt.Sprintf("[ Phase: %s, Started: %s, Deadline: %s, Reissued: %d ]", r.Phase, r.Started, r.Deadline, len(r.Reissued))
+}
+
+// Active tells whether clients are being moved to a new ca.
+func (r *Rotation) Active() bool {
+	return r != nil && (r.Phase == RotationPrepared || r.Phase == RotationPublished)
+}
+
+// StartRotation records a ca rotation prepared at now, refused while another one is in progress.
+func (d *Data) StartRotation(now time.Time) error {
+	if d.Rotation.Active() {
+		return errors.New("a ca rotation is already in progress")
+	}
+	d.Rotation = &Rotation{Phase: RotationPrepared, Started: now, Reissued: map[string]time.Time{}}
+	return nil
+}
+
+// PublishRotation records the publication of the prepared rotation at now, the old ca being retired after days.
+func (d *Data) PublishRotation(now time.Time, days int) error {
+	if d.Rotation == nil || d.Rotation.Phase != RotationPrepared {
+		return errors.New("no prepared ca rotation to publish")
+	}
+	d.Rotation.Phase = RotationPublished
+	d.Rotation.Published = now
+	d.Rotation.Deadline = now.AddDate(0, 0, days)
+	return nil
+}
+
+// RetireRotation records the retirement of the old ca at now. The profiles of the users reissued under the
+// new ca now match the fingerprints of the pki, the others are forgotten as their certificate was retired.
+func (d *Data) RetireRotation(now time.Time, fingerprints map[string]string) error {
+	if d.Rotation == nil || d.Rotation.Phase != RotationPublished {
+		return errors.New("no published ca rotation to retire")
+	}
+	d.Rotation.Phase = RotationRetired
+	d.Rotation.Retired = now
+	for user, profile := range d.Profiles {
+		if _, ok := d.Rotation.Reissued[user]; !ok {
+			delete(d.Profiles, user)
+			continue
+		}
+		profile.Fingerprints = fingerprints
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package state
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRotationTransitions(t *testing.T) {
+	data := newData()
+	now := time.Date(2026, 10, 19, 8, 0, 0, 0, time.UTC)
+	data.Profiles["john"] = &Profile{User: "john", Fingerprints: map[string]string{"ca": "old"}}
+	data.Profiles["jane"] = &Profile{User: "jane", Fingerprints: map[string]string{"ca": "old"}}
+
+	if err := data.PublishRotation(now, 30); err == nil {
+		t.Error("got no error, wanted publish refused without prepared rotation")
+	}
+	if err := data.RetireRotation(now, nil); err == nil {
+		t.Error("got no error, wanted retire refused without published rotation")
+	}
+	if err := data.StartRotation(now); err != nil {
+		t.Fatal(err)
+	}
+	if err := data.StartRotation(now); err == nil {
+		t.Error("got no error, wanted a second rotation refused")
+	}
+	if err := data.RetireRotation(now, nil); err == nil {
+		t.Error("got no error, wanted retire refused before publish")
+	}
+	if err := data.PublishRotation(now, 30); err != nil {
+		t.Fatal(err)
+	}
+	if data.Rotation.Phase != RotationPublished || !data.Rotation.Deadline.Equal(now.AddDate(0, 0, 30)) {
+		t.Errorf("got %v, wanted a published rotation with a deadline in 30 days", data.Rotation)
+	}
+	data.Rotation.Reissued["john"] = now
+
+	if err := data.RetireRotation(now, map[string]string{"ca": "new"}); err != nil {
+		t.Fatal(err)
+	}
+	if data.Rotation.Phase != RotationRetired || data.Rotation.Active() {
+		t.Errorf("got %v, wanted a retired rotation", data.Rotation)
+	}
+	if _, ok := data.Profiles["jane"]; ok || data.Profiles["john"].Fingerprints["ca"] != "new" {
+		t.Errorf("got %v, wanted the reissued profile kept with the new fingerprints", data.Profiles)
+	}
+	if err := data.StartRotation(now); err != nil {
+		t.Errorf("got %v, wanted a new rotation allowed after retirement", err)
+	}
+}
@@ -0,0 +1,194 @@
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Data is everything the updater persists between runs.
+type Data struct {
+	Downloads   map[string]*Download   `json:"downloads"`
+	Profiles    map[string]*Profile    `json:"profiles"`
+	Rotation    *Rotation              `json:"rotation,omitempty"`
+	Connections map[string]*Connection `json:"connections"`
+	// ConnectionsSince is when the connection history started to be recorded
+	ConnectionsSince time.Time            `json:"connections-since,omitempty"`
+	Dormant          map[string]*Dormancy `json:"dormant"`
+	Notices          map[string]*Notice   `json:"notices"`
+}
+
+// Download is a one-time download link handed out for a client profile.
+type Download struct {
+	Token        string    `json:"token"`
+	User         string    `json:"user"`
+	Key          string    `json:"key"`
+	Created      time.Time `json:"created"`
+	Expires      time.Time `json:"expires"`
+	DownloadedAt time.Time `json:"downloaded-at,omitempty"`
+	RemoteAddr   string    `json:"remote-addr,omitempty"`
+	UserAgent    string    `json:"user-agent,omitempty"`
+	Refused      int       `json:"refused,omitempty"`
+}
+
+func (d Download) String() string {
+	return fmt.Sprintf("[ User: %s, Key: %s, Created: %s, DownloadedAt: %s ]", d.User, d.Key, d.Created, d.DownloadedAt)
+}
+
+// PruneDownloads forgets the download links expired before since.
+func (d *Data) PruneDownloads(since time.Time) {
+	for token, download := range d.Downloads {
+		if download.Expires.Before(since) {
+			delete(d.Downloads, token)
+		}
+	}
+}
+
+// Profile records the server side inputs a client profile was written from, by user.
+type Profile struct {
+	User         string            `json:"user"`
+	Fingerprints map[string]string `json:"fingerprints"`
+	Written      time.Time         `json:"written"`
+}
+
+func (p Profile) String() string {
+	return fmt.Sprintf("[ User: %s, Fingerprints: %v, Written: %s ]", p.User, p.Fingerprints, p.Written)
+}
+
+// Store is a json file holding Data, rewritten atomically on every update. The file is shared with the
+// commands run next to the daemon: it is read again when another process changed it, and updates are
+// serialized between processes by a lock file.
+type Store struct {
+	path  string
+	mutex sync.Mutex
+	data  *Data
+	// read describes the file data was read from, every save replaces the file
+	read os.FileInfo
+}
+
+func newData() *Data {
+	data := &Data{}
+	data.initMaps()
+	return data
+}
+
+// initMaps creates the maps missing from a file written by a previous version.
+func (d *Data) initMaps() {
+	if d.Downloads == nil {
+		d.Downloads = map[string]*Download{}
+	}
+	if d.Profiles == nil {
+		d.Profiles = map[string]*Profile{}
+	}
+	if d.Connections == nil {
+		d.Connections = map[string]*Connection{}
+	}
+	if d.Dormant == nil {
+		d.Dormant = map[string]*Dormancy{}
+	}
+	if d.Notices == nil {
+		d.Notices = map[string]*Notice{}
+	}
+}
+
+// Open loads the store, a missing file is an empty store.
+func Open(path string) (*Store, error) {
+	store := &Store{path: path, data: newData()}
+	if err := store.load(); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// load reads the file again when it changed since it was last read or written.
+func (s *Store) load() error {
+	info, err := os.Stat(s.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if s.read != nil && os.SameFile(info, s.read) && info.ModTime().Equal(s.read.ModTime()) && info.Size() == s.read.Size() {
+		return nil
+	}
+	content, err := os.ReadFile(s.path)
+	if err != nil {
+		return err
+	}
+	data := &Data{}
+	if err = json.Unmarshal(content, data); err != nil {
+		return fmt.Errorf("invalid state file %s: %w", s.path, err)
+	}
+	data.initMaps()
+	s.data = data
+	s.read = info
+	return nil
+}
+
+// View calls fn with the data, fn must not keep references to it. The data last read is kept when the
+// file cannot be read again.
+func (s *Store) View(fn func(data *Data)) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	_ = s.load()
+	fn(s.data)
+}
+
+// Update calls fn with the data read under the lock file and saves it when fn succeeds, fn must leave
+// data unchanged on error.
+func (s *Store) Update(fn func(data *Data) error) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if err = s.load(); err != nil {
+		return err
+	}
+	if err = fn(s.data); err != nil {
+		return err
+	}
+	return s.save()
+}
+
+// lock takes the lock file of the store, released by the returned function.
+func (s *Store) lock() (func(), error) {
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(s.path+".lock", os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return func() {
+		syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+		file.Close()
+	}, nil
+}
+
+func (s *Store) save() error {
+	content, err := json.MarshalIndent(s.data, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err = os.WriteFile(tmp, content, 0600); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp, s.path); err != nil {
+		return err
+	}
+	s.read, err = os.Stat(s.path)
+	return err
+}
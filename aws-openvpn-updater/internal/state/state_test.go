@@ -0,0 +1,81 @@
+package state
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStoreUpdate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "state.json")
+	store, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	created := time.Date(2026, 10, 19, 8, 0, 0, 0, time.UTC)
+	err = store.Update(func(data *Data) error {
+		data.Downloads["token"] = &Download{Token: "token", User: "johndoe", Created: created}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reopened.View(func(data *Data) {
+		got, ok := data.Downloads["token"]
+		if !ok || got.User != "johndoe" || !got.Created.Equal(created) {
+			t.Errorf("got %v, wanted the saved download", got)
+		}
+	})
+}
+
+func TestStoreSharedBetweenProcesses(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	daemon, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	command, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = command.Update(func(data *Data) error {
+		data.Rotation = &Rotation{Phase: RotationPublished, Reissued: map[string]time.Time{}}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = daemon.Update(func(data *Data) error {
+		data.Downloads["token"] = &Download{Token: "token", User: "johndoe"}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	command.View(func(data *Data) {
+		if data.Rotation == nil || data.Rotation.Phase != RotationPublished || data.Downloads["token"] == nil {
+			t.Errorf("got rotation %v and downloads %v, wanted both updates", data.Rotation, data.Downloads)
+		}
+	})
+}
+
+func TestPruneDownloads(t *testing.T) {
+	data := newData()
+	now := time.Date(2026, 10, 19, 8, 0, 0, 0, time.UTC)
+	data.Downloads["old"] = &Download{Token: "old", Expires: now.AddDate(0, 0, -91)}
+	data.Downloads["recent"] = &Download{Token: "recent", Expires: now.AddDate(0, 0, -1)}
+
+	data.PruneDownloads(now.AddDate(0, 0, -90))
+
+	if _, ok := data.Downloads["old"]; ok || len(data.Downloads) != 1 {
+		t.Errorf("got %v, wanted only the recent download kept", data.Downloads)
+	}
+}
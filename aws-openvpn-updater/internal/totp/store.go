@@ -0,0 +1,274 @@
+package totp
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// keySize selects AES-256-GCM for the secrets.
+const keySize int = 32
+
+// Store keeps the secret of each user encrypted with the key of keyFile, and the last time step
+// accepted for the user so that a code cannot be used twice. Group, when set, is given read access
+// to the key and the secrets and write access to the time steps, for verify run by openvpn.
+type Store struct {
+	Path    string
+	KeyFile string
+	Group   string
+}
+
+func (s Store) String() string {
+	return fmt.Sprintf("[ Path: %v, KeyFile: %v, Group: %v ]", s.Path, s.KeyFile, s.Group)
+}
+
+func CreateStore(path string, keyFile string, group string) *Store {
+	return &Store{Path: path, KeyFile: keyFile, Group: group}
+}
+
+// Enroll generates, stores and returns a new secret for the user. The key is created with the first secret.
+func (s *Store) Enroll(user string) (string, error) {
+	err := checkUser(user)
+	if err != nil {
+		return "", err
+	}
+	key, err := s.key(true)
+	if err != nil {
+		return "", err
+	}
+	secret, err := GenerateSecret()
+	if err != nil {
+		return "", err
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return "", err
+	}
+	// The user is authenticated with the secret so that secret files cannot be swapped
+	sealed := gcm.Seal(nonce, nonce, []byte(secret), []byte(user))
+	err = s.mkdir(s.Path, 0770)
+	if err != nil {
+		return "", err
+	}
+	err = os.WriteFile(s.secretPath(user), []byte(base64.StdEncoding.EncodeToString(sealed)+"\n"), 0600)
+	if err != nil {
+		return "", err
+	}
+	err = s.setMode(s.secretPath(user), 0640)
+	if err != nil {
+		return "", err
+	}
+	err = os.Remove(s.lastPath(user))
+	if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+	return secret, nil
+}
+
+// Has reports whether the user is enrolled.
+func (s *Store) Has(user string) bool {
+	if checkUser(user) != nil {
+		return false
+	}
+	_, err := os.Stat(s.secretPath(user))
+	return err == nil
+}
+
+// Secret returns the decrypted secret of the user.
+func (s *Store) Secret(user string) (string, error) {
+	err := checkUser(user)
+	if err != nil {
+		return "", err
+	}
+	key, err := s.key(false)
+	if err != nil {
+		return "", err
+	}
+	content, err := os.ReadFile(s.secretPath(user))
+	if err != nil {
+		return "", err
+	}
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(content)))
+	if err != nil {
+		return "", err
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return "", fmt.Errorf("truncated secret for user %s", user)
+	}
+	secret, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], []byte(user))
+	if err != nil {
+		return "", err
+	}
+	return string(secret), nil
+}
+
+// Verify checks the code of the user and records its time step, a code is only accepted once.
+func (s *Store) Verify(user string, value string, now time.Time, skew int) (bool, error) {
+	secret, err := s.Secret(user)
+	if err != nil {
+		return false, err
+	}
+	step, ok, err := Validate(secret, value, now, skew)
+	if err != nil || !ok {
+		return false, err
+	}
+	return s.acceptStep(user, step)
+}
+
+// acceptStep records step as the last time step of the user unless it is not newer than the recorded one.
+// The file is locked so that concurrent verifications of the same code accept it once.
+func (s *Store) acceptStep(user string, step uint64) (bool, error) {
+	path := s.lastPath(user)
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600)
+	if err == nil {
+		err = s.setMode(path, 0660)
+	} else if os.IsExist(err) {
+		file, err = os.OpenFile(path, os.O_RDWR, 0)
+	}
+	if err != nil {
+		if file != nil {
+			file.Close()
+		}
+		return false, err
+	}
+	defer file.Close()
+	// Released when the file is closed
+	if err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX); err != nil {
+		return false, err
+	}
+	content, err := io.ReadAll(file)
+	if err != nil {
+		return false, err
+	}
+	last, err := strconv.ParseUint(strings.TrimSpace(string(content)), 10, 64)
+	if err == nil && step <= last {
+		return false, nil
+	}
+	if err = file.Truncate(0); err != nil {
+		return false, err
+	}
+	_, err = file.WriteAt([]byte(strconv.FormatUint(step, 10)+"\n"), 0)
+	return err == nil, err
+}
+
+// Delete removes the secret of the user.
+func (s *Store) Delete(user string) error {
+	err := checkUser(user)
+	if err != nil {
+		return err
+	}
+	for _, path := range []string{s.secretPath(user), s.lastPath(user)} {
+		err = os.Remove(path)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Store) secretPath(user string) string {
+	return filepath.Join(s.Path, user+".secret")
+}
+
+func (s *Store) lastPath(user string) string {
+	return filepath.Join(s.Path, user+".last")
+}
+
+// key reads the hex encoded key, generating it when missing and create is set.
+func (s *Store) key(create bool) ([]byte, error) {
+	content, err := os.ReadFile(s.KeyFile)
+	if os.IsNotExist(err) && create {
+		key := make([]byte, keySize)
+		_, err = rand.Read(key)
+		if err != nil {
+			return nil, err
+		}
+		err = s.mkdir(filepath.Dir(s.KeyFile), 0750)
+		if err != nil {
+			return nil, err
+		}
+		err = os.WriteFile(s.KeyFile, []byte(hex.EncodeToString(key)+"\n"), 0600)
+		if err != nil {
+			return nil, err
+		}
+		return key, s.setMode(s.KeyFile, 0640)
+	}
+	if err != nil {
+		return nil, err
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(string(content)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid key in %s: %w", s.KeyFile, err)
+	}
+	if len(key) != keySize {
+		return nil, fmt.Errorf("invalid key in %s: got %d bytes, wanted %d", s.KeyFile, len(key), keySize)
+	}
+	return key, nil
+}
+
+// mkdir creates the directory and sets its mode, see setMode.
+func (s *Store) mkdir(path string, mode os.FileMode) error {
+	err := os.MkdirAll(path, 0700)
+	if err != nil {
+		return err
+	}
+	return s.setMode(path, mode)
+}
+
+// setMode sets the mode of a file of the store and gives it to Group. Without group, the group and other
+// permissions are dropped.
+func (s *Store) setMode(path string, mode os.FileMode) error {
+	if s.Group == "" {
+		return os.Chmod(path, mode&0700)
+	}
+	group, err := user.LookupGroup(s.Group)
+	if err != nil {
+		return err
+	}
+	gid, err := strconv.Atoi(group.Gid)
+	if err != nil {
+		return err
+	}
+	err = os.Chown(path, -1, gid)
+	if err != nil {
+		return err
+	}
+	return os.Chmod(path, mode)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// checkUser refuses names that would escape the store, the name comes from the client certificate in verify.
+func checkUser(user string) error {
+	if user == "" || strings.ContainsAny(user, "/\\") || strings.HasPrefix(user, ".") {
+		return errors.New("invalid user name")
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package totp
+
+import (
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestStore(t *testing.T) {
+	dir := t.TempDir()
+	store := CreateStore(filepath.Join(dir, "secrets"), filepath.Join(dir, "totp.key"), "")
+
+	secret, err := store.Enroll("john")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !store.Has("john") || store.Has("jane") {
+		t.Error("got wrong enrollment, wanted only john")
+	}
+	got, err := store.Secret("john")
+	if err != nil || got != secret {
+		t.Fatalf("got %q %v, wanted the enrolled secret", got, err)
+	}
+	content, _ := os.ReadFile(filepath.Join(dir, "secrets", "john.secret"))
+	if len(content) == 0 || string(content) == secret {
+		t.Errorf("got %q, wanted an encrypted secret", content)
+	}
+
+	now := time.Now()
+	code, _ := Code(secret, now)
+	if ok, err := store.Verify("john", code, now, 1); !ok || err != nil {
+		t.Errorf("got %v %v, wanted the code accepted", ok, err)
+	}
+	if ok, _ := store.Verify("john", code, now, 1); ok {
+		t.Error("got accepted, wanted a replayed code refused")
+	}
+
+	// A secret moved to another user does not decrypt
+	if _, err = store.Enroll("jane"); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Rename(filepath.Join(dir, "secrets", "john.secret"), filepath.Join(dir, "secrets", "jane.secret")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = store.Secret("jane"); err == nil {
+		t.Error("got no error, wanted a swapped secret refused")
+	}
+
+	if err = store.Delete("jane"); err != nil {
+		t.Fatal(err)
+	}
+	if store.Has("jane") {
+		t.Error("got jane enrolled, wanted the secret deleted")
+	}
+	if _, err = store.Secret("../totp"); err == nil {
+		t.Error("got no error, wanted a path refused")
+	}
+}
+
+func TestStoreGroup(t *testing.T) {
+	group, err := user.LookupGroupId(strconv.Itoa(os.Getgid()))
+	if err != nil {
+		t.Skip(err)
+	}
+	dir := t.TempDir()
+	store := CreateStore(filepath.Join(dir, "secrets"), filepath.Join(dir, "keys", "totp.key"), group.Name)
+
+	secret, err := store.Enroll("john")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	code, _ := Code(secret, now)
+	if ok, err := store.Verify("john", code, now, 1); !ok || err != nil {
+		t.Fatalf("got %v %v, wanted the code accepted", ok, err)
+	}
+	for path, want := range map[string]os.FileMode{
+		filepath.Join(dir, "secrets"):                os.ModeDir | 0770,
+		filepath.Join(dir, "secrets", "john.secret"): 0640,
+		filepath.Join(dir, "secrets", "john.last"):   0660,
+		filepath.Join(dir, "keys"):                   os.ModeDir | 0750,
+		filepath.Join(dir, "keys", "totp.key"):       0640,
+	} {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode() != want {
+			t.Errorf("got %v for %s, wanted %v", info.Mode(), path, want)
+		}
+	}
+}
+
+func TestAcceptStepConcurrent(t *testing.T) {
+	store := CreateStore(t.TempDir(), "", "")
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	accepted := 0
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ok, err := store.acceptStep("john", 42)
+			if err != nil {
+				t.Error(err)
+			}
+			if ok {
+				mutex.Lock()
+				accepted++
+				mutex.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if accepted != 1 {
+		t.Errorf("got the time step accepted %d times, wanted once", accepted)
+	}
+}
@@ -0,0 +1,54 @@
+package totp
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfc6238Secret is the sha1 secret of the RFC 6238 test vectors, "12345678901234567890".
+const rfc6238Secret string = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestCode(t *testing.T) {
+	// Last 6 digits of the RFC 6238 sha1 test vectors
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+	for _, tt := range tests {
+		got, err := Code(rfc6238Secret, time.Unix(tt.unix, 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("got %s at %d, wanted %s", got, tt.unix, tt.want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	previous, _ := Code(rfc6238Secret, now.Add(-period))
+	if _, ok, _ := Validate(rfc6238Secret, previous, now, 1); !ok {
+		t.Error("got refused, wanted the previous step accepted with a skew of 1")
+	}
+	if _, ok, _ := Validate(rfc6238Secret, previous, now, 0); ok {
+		t.Error("got accepted, wanted the previous step refused without skew")
+	}
+	if _, ok, _ := Validate(rfc6238Secret, "12345", now, 1); ok {
+		t.Error("got accepted, wanted a short code refused")
+	}
+}
+
+func TestURI(t *testing.T) {
+	got := URI("ACME VPN", "john.doe", "ABCD")
+	if !strings.HasPrefix(got, "otpauth://totp/ACME%20VPN:john.doe?") || !strings.Contains(got, "secret=ABCD") ||
+		!strings.Contains(got, "issuer=ACME+VPN") {
+		t.Errorf("got %s", got)
+	}
+}